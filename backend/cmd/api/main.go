@@ -5,11 +5,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/collab-docs/backend/internal/api"
+	"github.com/collab-docs/backend/internal/auth"
 	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/redis"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -30,21 +33,56 @@ func main() {
 	}
 	defer database.Close()
 
+	// Initialize Redis (optional: features that need it are disabled without it)
+	pubsub, err := redis.New(ctx)
+	if err != nil {
+		log.Printf("Redis unavailable, continuing without it: %v", err)
+		pubsub = nil
+	} else {
+		defer pubsub.Close()
+	}
+	auth.SetTokenStore(pubsub)
+
+	if auth.IsDevEnv() {
+		log.Println("APP_ENV=dev: development auth shortcuts (X-User-ID) are enabled")
+	}
+
+	// Mark stale access requests as expired in the background (ACCESS_REQUEST_EXPIRY_INTERVAL)
+	go api.RunAccessRequestExpiry(ctx, database, api.AccessRequestExpiryInterval())
+
 	// Create Gin router
 	r := gin.Default()
 
+	// Client IPs (rate limiting) come from X-Forwarded-For only when sent by a
+	// trusted proxy: TRUSTED_PROXIES is a comma-separated list of IPs/CIDRs.
+	// Unset (or "none") trusts no proxy, so the header cannot be spoofed.
+	var proxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); !strings.EqualFold(v, "none") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				proxies = append(proxies, p)
+			}
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS configuration - allow all origins for development
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-User-ID", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-User-ID", "Accept", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed", "X-Document-Role", "X-Snapshot-Version", "X-Open-Count", "X-Resolved-Count"},
 		AllowCredentials: false, // Must be false when AllowOrigins is *
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Security headers (X-Frame-Options, Referrer-Policy, optional CSP, ...)
+	r.Use(api.SecurityHeaders())
+
 	// Register API routes
-	handler := api.NewHandler(database)
+	handler := api.NewHandler(database, pubsub)
 	handler.RegisterRoutes(r)
 
 	// Get port from environment
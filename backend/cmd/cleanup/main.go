@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/email"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/joho/godotenv"
+)
+
+// Inactive account cleanup job.
+//
+// By default the job only reports inactive accounts. Pass -export to write
+// them to a JSON file, -notify to email each account a warning before a later
+// -delete run, and -delete to permanently remove them. Pass
+// -purge-trash to also permanently delete documents that have been in the
+// trash for longer than the retention period.
+//
+//	go run ./cmd/cleanup -inactive-for 8760h
+//	go run ./cmd/cleanup -inactive-for 8760h -export inactive.json
+//	go run ./cmd/cleanup -inactive-for 8760h -notify
+//	go run ./cmd/cleanup -inactive-for 8760h -delete
+//	go run ./cmd/cleanup -purge-trash
+func main() {
+	// Load .env file if exists
+	godotenv.Load()
+
+	defaultPeriod := 365 * 24 * time.Hour
+	if v := os.Getenv("INACTIVE_USER_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid INACTIVE_USER_PERIOD %q: must be a positive duration", v)
+		}
+		defaultPeriod = d
+	}
+
+	inactiveFor := flag.Duration("inactive-for", defaultPeriod, "how long an account must be inactive to be selected")
+	exportPath := flag.String("export", "", "write the selected accounts as JSON to this file")
+	doNotify := flag.Bool("notify", false, "email the selected accounts that they are going to be deleted")
+	doDelete := flag.Bool("delete", false, "permanently delete the selected accounts")
+	purgeTrash := flag.Bool("purge-trash", false, "permanently delete documents trashed more than 30 days ago")
+	flag.Parse()
+
+	if *inactiveFor <= 0 {
+		log.Fatalf("-inactive-for must be a positive duration")
+	}
+	if *doNotify && *doDelete {
+		// A warning is only useful if the account survives until the user reacts
+		log.Fatalf("-notify and -delete cannot be combined: warn first, delete in a later run")
+	}
+
+	ctx := context.Background()
+
+	database, err := db.New(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	if *purgeTrash {
+		purged, err := database.PurgeTrash(ctx, db.TrashRetention)
+		if err != nil {
+			log.Fatalf("Failed to purge trash: %v", err)
+		}
+		log.Printf("Purged %d documents from the trash", purged)
+	}
+
+	users, err := database.ListInactiveUsers(ctx, *inactiveFor)
+	if err != nil {
+		log.Fatalf("Failed to list inactive users: %v", err)
+	}
+
+	log.Printf("Found %d accounts inactive for more than %s", len(users), *inactiveFor)
+	for _, u := range users {
+		lastLogin := "never"
+		if u.LastLoginAt != nil {
+			lastLogin = u.LastLoginAt.Format(time.RFC3339)
+		}
+		log.Printf("  %s  %s  created=%s  last_login=%s", u.ID, u.Email, u.CreatedAt.Format(time.RFC3339), lastLogin)
+	}
+
+	if *exportPath != "" {
+		data, err := json.MarshalIndent(users, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode export: %v", err)
+		}
+		if err := os.WriteFile(*exportPath, data, 0o600); err != nil {
+			log.Fatalf("Failed to write export: %v", err)
+		}
+		log.Printf("Exported %d accounts to %s", len(users), *exportPath)
+	}
+
+	if *doNotify {
+		sent := notifyInactive(ctx, email.NewFromEnv(), users, *inactiveFor)
+		log.Printf("Notified %d of %d inactive accounts", sent, len(users))
+		return
+	}
+
+	if !*doDelete {
+		log.Println("Report only - pass -notify to warn or -delete to remove these accounts")
+		return
+	}
+
+	deleted := 0
+	for _, u := range users {
+		if err := database.DeleteUser(ctx, u.ID); err != nil {
+			log.Printf("Failed to delete user %s: %v", u.ID, err)
+			continue
+		}
+		deleted++
+	}
+	log.Printf("Deleted %d of %d inactive accounts", deleted, len(users))
+}
+
+// notifySendTimeout bounds each warning email
+const notifySendTimeout = 30 * time.Second
+
+// notifyInactive emails each user that their account is inactive and will be
+// deleted. Returns how many messages were sent.
+func notifyInactive(ctx context.Context, sender email.EmailSender, users []*models.User, inactiveFor time.Duration) int {
+	sent := 0
+	for _, u := range users {
+		body := fmt.Sprintf("Hi %s,\n\nYour CollabDocs account %s has not been used for more than %d days "+
+			"and is scheduled for deletion. Sign in to keep it.", u.Name, u.Email, int(inactiveFor.Hours()/24))
+		sendCtx, cancel := context.WithTimeout(ctx, notifySendTimeout)
+		err := sender.Send(sendCtx, u.Email, "Your CollabDocs account will be deleted", body)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to notify user %s: %v", u.ID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
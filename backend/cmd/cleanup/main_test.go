@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// recordingSender records the recipients and bodies of sent messages and
+// fails for the addresses in fail
+type recordingSender struct {
+	sent   []string
+	bodies []string
+	fail   map[string]bool
+}
+
+func (s *recordingSender) Send(ctx context.Context, to, subject, body string) error {
+	if s.fail[to] {
+		return errors.New("mailbox unavailable")
+	}
+	s.sent = append(s.sent, to)
+	s.bodies = append(s.bodies, body)
+	return nil
+}
+
+func TestNotifyInactive(t *testing.T) {
+	users := []*models.User{
+		{ID: uuid.New(), Email: "a@example.com", Name: "A"},
+		{ID: uuid.New(), Email: "bounce@example.com", Name: "B"},
+		{ID: uuid.New(), Email: "c@example.com", Name: "C"},
+	}
+	sender := &recordingSender{fail: map[string]bool{"bounce@example.com": true}}
+
+	// A failed message does not stop the others
+	if sent := notifyInactive(context.Background(), sender, users, 30*24*time.Hour); sent != 2 {
+		t.Errorf("sent = %d, want 2", sent)
+	}
+	if strings.Join(sender.sent, ",") != "a@example.com,c@example.com" {
+		t.Errorf("sent to %v, want a@ and c@", sender.sent)
+	}
+	for _, body := range sender.bodies {
+		if !strings.Contains(body, "more than 30 days") {
+			t.Errorf("body %q does not state the inactivity period", body)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpdateAccessRequestOnlyFromPending(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	requester := dbtest.User(t, database, "Requester")
+	doc := dbtest.Document(t, database, owner.ID, "Private")
+
+	newRequest := func(expiresAt time.Time) string {
+		t.Helper()
+		req, err := database.CreateAccessRequest(ctx, doc.ID, requester.ID, models.RoleEdit, "", expiresAt)
+		if err != nil {
+			t.Fatalf("CreateAccessRequest: %v", err)
+		}
+		return "/api/access-requests/" + req.ID.String()
+	}
+	decide := func(path, status string) int {
+		t.Helper()
+		return doRequest(t, r, http.MethodPut, path, owner, models.UpdateAccessRequestRequest{Status: status}).Code
+	}
+
+	path := newRequest(time.Now().Add(time.Hour))
+	if code := decide(path, models.AccessRequestRejected); code != http.StatusOK {
+		t.Fatalf("reject pending: status %d", code)
+	}
+	// A rejected request cannot be approved afterwards, which would grant access
+	if code := decide(path, models.AccessRequestApproved); code != http.StatusConflict {
+		t.Errorf("approve rejected: status %d, want %d", code, http.StatusConflict)
+	}
+	perm, err := database.ResolveEffectivePermission(ctx, doc.ID, requester.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm != nil {
+		t.Errorf("requester was granted %q after a rejected request", perm.Role)
+	}
+
+	// Requesting again reopens the same request
+	path = newRequest(time.Now().Add(time.Hour))
+	if code := decide(path, models.AccessRequestApproved); code != http.StatusOK {
+		t.Fatalf("approve pending: status %d", code)
+	}
+	if code := decide(path, models.AccessRequestRejected); code != http.StatusConflict {
+		t.Errorf("reject approved: status %d, want %d", code, http.StatusConflict)
+	}
+
+	cancelled := dbtest.Document(t, database, owner.ID, "Cancelled")
+	req, err := database.CreateAccessRequest(ctx, cancelled.ID, requester.ID, models.RoleView, "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CancelAccessRequest(ctx, req.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := decide("/api/access-requests/"+req.ID.String(), models.AccessRequestApproved); code != http.StatusConflict {
+		t.Errorf("approve cancelled: status %d, want %d", code, http.StatusConflict)
+	}
+
+	expired := dbtest.Document(t, database, owner.ID, "Expired")
+	req, err = database.CreateAccessRequest(ctx, expired.ID, requester.ID, models.RoleView, "", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := decide("/api/access-requests/"+req.ID.String(), models.AccessRequestApproved); code != http.StatusConflict {
+		t.Errorf("approve expired: status %d, want %d", code, http.StatusConflict)
+	}
+}
+
+func TestListAccessRequestsByStatus(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Private")
+	path := "/api/docs/" + doc.ID.String() + "/access-requests"
+
+	// One requester per status: pending, approved, rejected
+	byStatus := map[string]*models.User{}
+	for _, status := range []string{models.AccessRequestPending, models.AccessRequestApproved, models.AccessRequestRejected} {
+		requester := dbtest.User(t, database, "Requester "+status)
+		req, err := database.CreateAccessRequest(ctx, doc.ID, requester.ID, models.RoleView, "", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != models.AccessRequestPending {
+			if _, err := database.UpdateAccessRequestStatus(ctx, req.ID, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		byStatus[status] = requester
+	}
+
+	list := func(t *testing.T, query string) []models.AccessRequest {
+		t.Helper()
+		w := doRequest(t, r, http.MethodGet, path+query, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var requests []models.AccessRequest
+		decodeBody(t, w, &requests)
+		return requests
+	}
+
+	for status, requester := range byStatus {
+		t.Run(status, func(t *testing.T) {
+			requests := list(t, "?status="+status)
+			if len(requests) != 1 || requests[0].Status != status || requests[0].RequesterID != requester.ID {
+				t.Fatalf("requests = %+v, want the %s request", requests, status)
+			}
+			if got := requests[0].Requester; got == nil || got.Name != requester.Name || got.Email != requester.Email {
+				t.Errorf("requester = %+v, want %s", got, requester.Name)
+			}
+		})
+	}
+
+	t.Run("all", func(t *testing.T) {
+		for _, query := range []string{"", "?status=all"} {
+			if requests := list(t, query); len(requests) != 3 {
+				t.Errorf("%q: %d requests, want 3", query, len(requests))
+			}
+		}
+		seen := map[string]bool{}
+		for offset := 0; offset < 3; offset++ {
+			page := list(t, "?limit=1&offset="+strconv.Itoa(offset))
+			if len(page) != 1 {
+				t.Fatalf("page %d has %d requests, want 1", offset, len(page))
+			}
+			seen[page[0].Status] = true
+		}
+		if len(seen) != 3 {
+			t.Errorf("pages covered statuses %v, want all three", seen)
+		}
+	})
+
+	if w := doRequest(t, r, http.MethodGet, path+"?status=maybe", owner, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid status: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequest(t, r, http.MethodGet, path, byStatus[models.AccessRequestApproved], nil); w.Code != http.StatusForbidden {
+		t.Errorf("non-owner: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAccessDecisionEmail(t *testing.T) {
+	database := dbtest.New(t)
+	t.Setenv("APP_URL", "https://docs.example.com/")
+	sender := newFakeEmailSender()
+	h := NewHandler(database, nil)
+	h.SetEmailSender(sender)
+	r := gin.New()
+	h.RegisterRoutes(r)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	requester := dbtest.User(t, database, "Requester")
+	doc := dbtest.Document(t, database, owner.ID, "Roadmap")
+
+	for _, status := range []string{models.AccessRequestApproved, models.AccessRequestRejected} {
+		t.Run(status, func(t *testing.T) {
+			req, err := database.CreateAccessRequest(ctx, doc.ID, requester.ID, models.RoleView, "", time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := "/api/access-requests/" + req.ID.String()
+			if w := doRequest(t, r, http.MethodPut, path, owner, models.UpdateAccessRequestRequest{Status: status}); w.Code != http.StatusOK {
+				t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+			}
+
+			mail := sender.next(t)
+			if mail.to != requester.Email || mail.subject != "Access request "+status {
+				t.Errorf("email to %s with subject %q, want %s and %q", mail.to, mail.subject, requester.Email, "Access request "+status)
+			}
+			if !strings.Contains(mail.body, `"Roadmap" was `+status) {
+				t.Errorf("body %q does not name the document and decision", mail.body)
+			}
+			link := "https://docs.example.com/doc/" + doc.ID.String()
+			if got, want := strings.Contains(mail.body, link), status == models.AccessRequestApproved; got != want {
+				t.Errorf("body %q: contains the document link %v, want %v", mail.body, got, want)
+			}
+		})
+	}
+}
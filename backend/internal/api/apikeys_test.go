@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	r, database := newTestAPI(t)
+	user := dbtest.User(t, database, "User")
+	other := dbtest.User(t, database, "Other")
+
+	// me requests /api/auth/me with an ApiKey authorization header
+	me := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := doRequest(t, r, http.MethodPost, "/api/auth/api-keys", user, models.CreateAPIKeyRequest{Name: "CI"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d (%s)", w.Code, w.Body.String())
+	}
+	var created models.CreateAPIKeyResponse
+	decodeBody(t, w, &created)
+	if created.APIKey == nil || !strings.HasPrefix(created.Key, created.APIKey.Prefix) || created.APIKey.Name != "CI" {
+		t.Fatalf("create: response %+v does not describe the new key", created)
+	}
+
+	w = doRequest(t, r, http.MethodGet, "/api/auth/api-keys", user, nil)
+	if strings.Contains(w.Body.String(), created.Key) {
+		t.Errorf("list exposes the full key: %s", w.Body.String())
+	}
+	var keys []models.APIKey
+	decodeBody(t, w, &keys)
+	if len(keys) != 1 || keys[0].ID != created.APIKey.ID || keys[0].Prefix != created.APIKey.Prefix {
+		t.Errorf("list = %+v, want the created key", keys)
+	}
+
+	w = me(created.Key)
+	if w.Code != http.StatusOK {
+		t.Fatalf("use key: status %d (%s)", w.Code, w.Body.String())
+	}
+	var got models.User
+	decodeBody(t, w, &got)
+	if got.ID != user.ID {
+		t.Errorf("use key: authenticated as %s, want %s", got.ID, user.ID)
+	}
+	if w := me(created.Key + "x"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = doRequest(t, r, http.MethodGet, "/api/auth/api-keys", user, nil)
+	decodeBody(t, w, &keys)
+	if len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Errorf("list after use = %+v, want last_used_at set", keys)
+	}
+
+	keyPath := "/api/auth/api-keys/" + created.APIKey.ID.String()
+	if w := doRequest(t, r, http.MethodDelete, keyPath, other, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoke by other user: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(t, r, http.MethodDelete, keyPath, user, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d (%s)", w.Code, w.Body.String())
+	}
+	if w := me(created.Key); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// aliceID is the seeded user DevAuthMiddleware falls back to in development
+const aliceID = "11111111-1111-1111-1111-111111111111"
+
+func TestDevUserIDHeader(t *testing.T) {
+	database := dbtest.New(t)
+	user := dbtest.User(t, database, "User")
+	other := dbtest.User(t, database, "Other")
+	token, err := auth.GenerateToken(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// me requests /api/auth/me with the given headers and returns the status and user ID
+	me := func(r http.Handler, headers map[string]string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var got models.User
+		if w.Code == http.StatusOK {
+			decodeBody(t, w, &got)
+		}
+		return w.Code, got.ID.String()
+	}
+	router := func() *gin.Engine {
+		r := gin.New()
+		NewHandler(database, nil).RegisterRoutes(r)
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		headers map[string]string
+		status  int
+		userID  string
+	}{
+		{"dev header", "dev", map[string]string{"X-User-ID": user.ID.String()}, http.StatusOK, user.ID.String()},
+		{"dev default user", "dev", nil, http.StatusOK, aliceID},
+		{"dev invalid header", "dev", map[string]string{"X-User-ID": "nope"}, http.StatusUnauthorized, ""},
+		{"dev token wins", "dev", map[string]string{"X-User-ID": user.ID.String(), "Authorization": "Bearer " + token}, http.StatusOK, other.ID.String()},
+		{"prod header ignored", "prod", map[string]string{"X-User-ID": user.ID.String()}, http.StatusUnauthorized, ""},
+		{"prod no default user", "prod", nil, http.StatusUnauthorized, ""},
+		{"prod token", "prod", map[string]string{"Authorization": "Bearer " + token}, http.StatusOK, other.ID.String()},
+		{"unset header ignored", "", map[string]string{"X-User-ID": user.ID.String()}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", tt.env)
+			status, userID := me(router(), tt.headers)
+			if status != tt.status || userID != tt.userID && tt.userID != "" {
+				t.Errorf("got %d as %s, want %d as %s", status, userID, tt.status, tt.userID)
+			}
+		})
+	}
+
+	// The middleware checks APP_ENV again on every request
+	t.Run("dev router after leaving dev", func(t *testing.T) {
+		t.Setenv("APP_ENV", "dev")
+		r := router()
+		t.Setenv("APP_ENV", "prod")
+		if status, _ := me(r, map[string]string{"X-User-ID": user.ID.String()}); status != http.StatusUnauthorized {
+			t.Errorf("status %d, want %d", status, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestValidateToken(t *testing.T) {
+	r, database := newTestAPI(t)
+	t.Setenv("JWT_SECRET", "validate-test-secret")
+	user := dbtest.User(t, database, "Alice")
+
+	validate := func(t *testing.T, token string) (int, map[string]interface{}) {
+		t.Helper()
+		w := doRequest(t, r, http.MethodPost, "/api/auth/validate", nil, models.ValidateTokenRequest{Token: token})
+		var body map[string]interface{}
+		decodeBody(t, w, &body)
+		return w.Code, body
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		token, err := auth.GenerateToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, body := validate(t, "Bearer "+token)
+		if code != http.StatusOK || body["valid"] != true {
+			t.Fatalf("status %d, body %v; want a valid token", code, body)
+		}
+		claims, _ := body["claims"].(map[string]interface{})
+		want := map[string]string{"sub": user.ID.String(), "email": user.Email, "name": user.Name, "typ": auth.TokenTypeAccess}
+		for key, value := range want {
+			if claims[key] != value {
+				t.Errorf("claims[%q] = %v, want %q", key, claims[key], value)
+			}
+		}
+		if _, ok := claims["chain"]; ok {
+			t.Error("claims expose the refresh chain ID")
+		}
+		if expiresIn, _ := body["expires_in"].(float64); expiresIn <= 0 {
+			t.Errorf("expires_in = %v, want a positive number of seconds", body["expires_in"])
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		claims := auth.Claims{
+			UserID:    user.ID.String(),
+			TokenType: auth.TokenTypeAccess,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        uuid.NewString(),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("validate-test-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code, body := validate(t, token); code != http.StatusUnauthorized || body["valid"] != false {
+			t.Errorf("status %d, body %v; want 401 and valid false", code, body)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		auth.SetTokenStore(newTestRedis(t))
+		t.Cleanup(func() { auth.SetTokenStore(nil) })
+		token, err := auth.GenerateToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := auth.ValidateToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.RevokeToken(context.Background(), claims); err != nil {
+			t.Fatal(err)
+		}
+		if code, body := validate(t, token); code != http.StatusUnauthorized || body["valid"] != false {
+			t.Errorf("status %d, body %v; want 401 and valid false", code, body)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CollabSecretHeader authenticates backend requests to the collab server's internal endpoints
+const CollabSecretHeader = "X-Collab-Secret"
+
+// Room control channels: the collab servers subscribe to the "control:rooms:*"
+// pattern, so every instance acts on the room wherever it is open
+const (
+	// RoomEvictChannel closes a room (e.g. after its document was moved to the trash)
+	RoomEvictChannel = "control:rooms:evict"
+	// RoomReloadChannel replaces an open room's content with the latest snapshot
+	RoomReloadChannel = "control:rooms:reload"
+)
+
+var collabClient = &http.Client{Timeout: 10 * time.Second}
+
+// publishRoomControl sends {"room": docID} on a room control channel
+func (h *Handler) publishRoomControl(ctx context.Context, channel string, docID uuid.UUID) error {
+	msg, err := json.Marshal(map[string]string{"room": docID.String()})
+	if err != nil {
+		return err
+	}
+	return h.redis.Publish(ctx, channel, msg)
+}
+
+// publishRoomEviction tells the collab servers to disconnect everyone from a
+// document's room (e.g. after it was moved to the trash). A no-op without Redis.
+func (h *Handler) publishRoomEviction(ctx context.Context, docID uuid.UUID) error {
+	if h.redis == nil {
+		return nil
+	}
+	return h.publishRoomControl(ctx, RoomEvictChannel, docID)
+}
+
+// reloadRoom makes the collab servers replace a live room's content with the
+// latest snapshot, so connected clients receive the change instead of writing
+// their stale state back. With Redis the reload is published to every instance
+// and reported as done; without it, reloadCollabRoom asks COLLAB_SERVER_URL.
+func (h *Handler) reloadRoom(ctx context.Context, docID uuid.UUID) (bool, error) {
+	if h.redis == nil {
+		return reloadCollabRoom(ctx, docID)
+	}
+	if err := h.publishRoomControl(ctx, RoomReloadChannel, docID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// collabServerURL returns COLLAB_SERVER_URL without a trailing slash, or "" when unset
+func collabServerURL() string {
+	return strings.TrimRight(os.Getenv("COLLAB_SERVER_URL"), "/")
+}
+
+// roomBlockState is what the collab server reports about a block of a live room
+type roomBlockState int
+
+const (
+	roomUnknown    roomBlockState = iota // COLLAB_SERVER_URL is not set
+	roomClosed                           // not open (or still loading), so the latest snapshot is current
+	roomHasBlock                         // open and the block is present
+	roomLacksBlock                       // open and the block is absent
+)
+
+// liveRoomBlock asks the y-websocket server whether a document's open room
+// contains an element with the given block ID
+func liveRoomBlock(ctx context.Context, docID uuid.UUID, blockID string) (roomBlockState, error) {
+	base := collabServerURL()
+	if base == "" {
+		return roomUnknown, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/rooms/"+docID.String()+"/blocks/"+url.PathEscape(blockID), nil)
+	if err != nil {
+		return roomUnknown, err
+	}
+	req.Header.Set(CollabSecretHeader, os.Getenv("COLLAB_SECRET"))
+
+	resp, err := collabClient.Do(req)
+	if err != nil {
+		return roomUnknown, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return roomUnknown, fmt.Errorf("collab server returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Open   bool `json:"open"`
+		Exists bool `json:"exists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return roomUnknown, err
+	}
+	switch {
+	case !result.Open:
+		return roomClosed, nil
+	case result.Exists:
+		return roomHasBlock, nil
+	default:
+		return roomLacksBlock, nil
+	}
+}
+
+// reloadCollabRoom asks the y-websocket server at COLLAB_SERVER_URL to reload a
+// live room, which reaches only the instance behind that URL. Returns false when
+// the room is not open (it loads the latest snapshot anyway) or COLLAB_SERVER_URL
+// is not set.
+func reloadCollabRoom(ctx context.Context, docID uuid.UUID) (bool, error) {
+	base := collabServerURL()
+	if base == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/rooms/"+docID.String()+"/reload", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(CollabSecretHeader, os.Getenv("COLLAB_SECRET"))
+
+	resp, err := collabClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("collab server returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Reloaded bool `json:"reloaded"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Reloaded, nil
+}
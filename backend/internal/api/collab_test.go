@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReloadRoomWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	h := NewHandler(nil, nil)
+	open := uuid.New()
+
+	t.Setenv("COLLAB_SERVER_URL", "")
+	if reloaded, err := h.reloadRoom(ctx, open); err != nil || reloaded {
+		t.Errorf("without COLLAB_SERVER_URL: reloaded %v, err %v", reloaded, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(CollabSecretHeader) != "test-secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"reloaded": r.URL.Path == "/rooms/"+open.String()+"/reload"})
+	}))
+	defer srv.Close()
+	t.Setenv("COLLAB_SERVER_URL", srv.URL)
+	t.Setenv("COLLAB_SECRET", "test-secret")
+
+	if reloaded, err := h.reloadRoom(ctx, open); err != nil || !reloaded {
+		t.Errorf("open room: reloaded %v, err %v", reloaded, err)
+	}
+	if reloaded, err := h.reloadRoom(ctx, uuid.New()); err != nil || reloaded {
+		t.Errorf("closed room: reloaded %v, err %v", reloaded, err)
+	}
+	t.Setenv("COLLAB_SECRET", "wrong")
+	if _, err := h.reloadRoom(ctx, open); err == nil {
+		t.Error("expected an error when the collab server rejects the secret")
+	}
+}
+
+func TestRoomControlPublishes(t *testing.T) {
+	pubsub := newTestRedis(t)
+	ctx := context.Background()
+	h := NewHandler(nil, pubsub)
+	// Requests must not go to the collab server when Redis reaches every instance
+	t.Setenv("COLLAB_SERVER_URL", "http://127.0.0.1:1")
+
+	// Subscribe like the collab servers do
+	received := make(chan [2]string, 4)
+	sub, err := pubsub.PSubscribe(ctx, "control:rooms:*", func(channel string, payload []byte) {
+		received <- [2]string{channel, string(payload)}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	docID := uuid.New()
+	want := `{"room":"` + docID.String() + `"}`
+	if reloaded, err := h.reloadRoom(ctx, docID); err != nil || !reloaded {
+		t.Fatalf("reloadRoom: reloaded %v, err %v", reloaded, err)
+	}
+	if err := h.publishRoomEviction(ctx, docID); err != nil {
+		t.Fatalf("publishRoomEviction: %v", err)
+	}
+	got := make(map[string]string)
+	for len(got) < 2 {
+		select {
+		case msg := <-received:
+			got[msg[0]] = msg[1]
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %v, want a message on both channels", got)
+		}
+	}
+	for _, channel := range []string{RoomReloadChannel, RoomEvictChannel} {
+		if got[channel] != want {
+			t.Errorf("%s: received %q, want %q", channel, got[channel], want)
+		}
+	}
+}
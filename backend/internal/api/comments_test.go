@@ -0,0 +1,703 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+	"github.com/google/uuid"
+)
+
+func TestBlockExists(t *testing.T) {
+	snapshot := yjstest.Document(
+		yjstest.Block{Name: "heading", ID: "intro", Text: "Intro"},
+		yjstest.Block{Name: "paragraph", ID: "body", Text: "Text"},
+	)
+	for blockID, want := range map[string]bool{"intro": true, "body": true, "gone": false, "": false} {
+		got, err := blockExists(snapshot, blockID)
+		if err != nil {
+			t.Fatalf("blockExists(%q): %v", blockID, err)
+		}
+		if got != want {
+			t.Errorf("blockExists(%q) = %v, want %v", blockID, got, want)
+		}
+	}
+	if _, err := blockExists([]byte{1, 1}, "intro"); err == nil {
+		t.Error("expected an error for a malformed snapshot")
+	}
+}
+
+// fakeCollabServer answers block lookups like a y-websocket server holding the
+// given open rooms (document ID → block IDs)
+func fakeCollabServer(t *testing.T, rooms map[string][]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(CollabSecretHeader) != "test-secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/rooms/"), "/")
+		if r.Method != http.MethodGet || len(parts) != 3 || parts[1] != "blocks" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		blockID, err := url.PathUnescape(parts[2])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		blocks, open := rooms[parts[0]]
+		exists := false
+		for _, b := range blocks {
+			exists = exists || b == blockID
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"open": open, "exists": exists})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("COLLAB_SERVER_URL", srv.URL+"/")
+	t.Setenv("COLLAB_SECRET", "test-secret")
+}
+
+func TestLiveRoomBlock(t *testing.T) {
+	ctx := context.Background()
+	docID, closedID := uuid.New(), uuid.New()
+
+	t.Setenv("COLLAB_SERVER_URL", "")
+	if state, err := liveRoomBlock(ctx, docID, "a"); err != nil || state != roomUnknown {
+		t.Errorf("without COLLAB_SERVER_URL: state %v, err %v", state, err)
+	}
+
+	fakeCollabServer(t, map[string][]string{docID.String(): {"a", "with/slash"}})
+	tests := []struct {
+		docID   uuid.UUID
+		blockID string
+		want    roomBlockState
+	}{
+		{docID, "a", roomHasBlock},
+		{docID, "with/slash", roomHasBlock},
+		{docID, "b", roomLacksBlock},
+		{closedID, "a", roomClosed},
+	}
+	for _, tt := range tests {
+		state, err := liveRoomBlock(ctx, tt.docID, tt.blockID)
+		if err != nil {
+			t.Fatalf("liveRoomBlock(%s, %q): %v", tt.docID, tt.blockID, err)
+		}
+		if state != tt.want {
+			t.Errorf("liveRoomBlock(%s, %q) = %v, want %v", tt.docID, tt.blockID, state, tt.want)
+		}
+	}
+
+	t.Setenv("COLLAB_SECRET", "wrong")
+	if _, err := liveRoomBlock(ctx, docID, "a"); err == nil {
+		t.Error("expected an error when the collab server rejects the secret")
+	}
+}
+
+func TestCreateCommentVerifiesSelectionBlock(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	t.Setenv("COMMENT_VERIFY_BLOCK_ID", "true")
+
+	owner := dbtest.User(t, database, "Owner")
+	closedDoc := dbtest.Document(t, database, owner.ID, "Closed room")
+	liveDoc := dbtest.Document(t, database, owner.ID, "Live room")
+	newDoc := dbtest.Document(t, database, owner.ID, "No snapshot yet")
+
+	snapshot := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "saved", Text: "Saved text"})
+	for _, doc := range []*models.Document{closedDoc, liveDoc} {
+		if _, err := database.SaveSnapshot(ctx, doc.ID, snapshot); err != nil {
+			t.Fatalf("SaveSnapshot: %v", err)
+		}
+	}
+	// The live room has moved on from its snapshot: "saved" was deleted and "added" is not persisted yet
+	fakeCollabServer(t, map[string][]string{liveDoc.ID.String(): {"added"}})
+
+	tests := []struct {
+		name    string
+		doc     *models.Document
+		blockID string
+		want    int
+	}{
+		{"existing block in a closed room's snapshot", closedDoc, "saved", http.StatusCreated},
+		{"missing block in a closed room's snapshot", closedDoc, "missing", http.StatusBadRequest},
+		{"block only in the live room", liveDoc, "added", http.StatusCreated},
+		{"block deleted in the live room", liveDoc, "saved", http.StatusBadRequest},
+		{"document without a snapshot", newDoc, "anything", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := models.CreateCommentRequest{
+				Content:   "Looks good",
+				Selection: &models.Selection{Anchor: 1, Head: 4, BlockID: tt.blockID},
+			}
+			w := doRequest(t, r, http.MethodPost, "/api/docs/"+tt.doc.ID.String()+"/comments", owner, body)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("flag off", func(t *testing.T) {
+		t.Setenv("COMMENT_VERIFY_BLOCK_ID", "false")
+		body := models.CreateCommentRequest{Content: "Hi", Selection: &models.Selection{BlockID: "missing"}}
+		w := doRequest(t, r, http.MethodPost, "/api/docs/"+closedDoc.ID.String()+"/comments", owner, body)
+		if w.Code != http.StatusCreated {
+			t.Errorf("status = %d, want %d (%s)", w.Code, http.StatusCreated, w.Body.String())
+		}
+	})
+}
+
+func TestCreateCommentSanitizesSelection(t *testing.T) {
+	t.Setenv("COMMENT_MAX_BODY_BYTES", "1024")
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	path := "/api/docs/" + doc.ID.String() + "/comments"
+
+	body := map[string]interface{}{
+		"content": "Hi",
+		"selection": map[string]interface{}{
+			"anchor":  1,
+			"head":    4,
+			"blockId": "p1",
+			"extra":   map[string]interface{}{"nested": []int{1, 2, 3}},
+		},
+	}
+	w := doRequest(t, r, http.MethodPost, path, owner, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d (%s)", w.Code, w.Body.String())
+	}
+	var comment models.Comment
+	decodeBody(t, w, &comment)
+
+	var stored map[string]interface{}
+	if err := dbtest.QueryRow(t, `SELECT selection FROM comments WHERE id = $1`, comment.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"anchor": float64(1), "head": float64(4), "blockId": "p1"}
+	if len(stored) != len(want) {
+		t.Errorf("stored selection = %v, want %v", stored, want)
+	}
+	for k, v := range want {
+		if stored[k] != v {
+			t.Errorf("stored selection[%q] = %v, want %v", k, stored[k], v)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		selection interface{}
+	}{
+		{"negative anchor", models.Selection{Anchor: -1, Head: 2}},
+		{"long block ID", models.Selection{Head: 1, BlockID: strings.Repeat("b", 129)}},
+		{"oversized body", map[string]string{"padding": strings.Repeat("x", 2048)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"content": "Hi", "selection": tt.selection}
+			if w := doRequest(t, r, http.MethodPost, path, owner, body); w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d (%s)", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+		})
+	}
+}
+
+// postComment creates a comment (a reply when parent is set) and returns the response
+func postComment(t *testing.T, r http.Handler, user *models.User, docID uuid.UUID, content string, parent *models.Comment) *httptest.ResponseRecorder {
+	t.Helper()
+	req := models.CreateCommentRequest{Content: content}
+	if parent != nil {
+		parentID := parent.ID.String()
+		req.ParentID = &parentID
+	}
+	return doRequest(t, r, http.MethodPost, "/api/docs/"+docID.String()+"/comments", user, req)
+}
+
+// mustPostComment creates a comment and fails the test unless it is created
+func mustPostComment(t *testing.T, r http.Handler, user *models.User, docID uuid.UUID, content string, parent *models.Comment) *models.Comment {
+	t.Helper()
+	w := postComment(t, r, user, docID, content, parent)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create comment %q: status %d (%s)", content, w.Code, w.Body.String())
+	}
+	var comment models.Comment
+	decodeBody(t, w, &comment)
+	return &comment
+}
+
+func TestCreateCommentRejectsNestedReplies(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	otherDoc := dbtest.Document(t, database, owner.ID, "Other doc")
+
+	top := mustPostComment(t, r, owner, doc.ID, "Top", nil)
+	reply := mustPostComment(t, r, owner, doc.ID, "Reply", top)
+	if reply.ParentID == nil || *reply.ParentID != top.ID {
+		t.Errorf("reply parent = %v, want %s", reply.ParentID, top.ID)
+	}
+	elsewhere := mustPostComment(t, r, owner, otherDoc.ID, "Elsewhere", nil)
+
+	tests := []struct {
+		name   string
+		parent *models.Comment
+		want   string
+	}{
+		{"reply to a reply", reply, "Cannot reply to a reply"},
+		{"parent on another document", elsewhere, "Parent comment not found"},
+		{"unknown parent", &models.Comment{ID: uuid.New()}, "Parent comment not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postComment(t, r, owner, doc.ID, "Nested", tt.parent)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("status %d (%s), want %d %q", w.Code, w.Body.String(), http.StatusBadRequest, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentThreadSummaries(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	replier := dbtest.User(t, database, "Replier")
+	stranger := dbtest.User(t, database, "Stranger")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, replier.ID, models.RoleComment)
+
+	quiet := mustPostComment(t, r, owner, doc.ID, "No replies", nil)
+	busy := mustPostComment(t, r, owner, doc.ID, "Three replies", nil)
+	replies := []*models.Comment{
+		mustPostComment(t, r, replier, doc.ID, "First", busy),
+		mustPostComment(t, r, owner, doc.ID, "Second", busy),
+	}
+	long := strings.Repeat("x", 200)
+	replies = append(replies, mustPostComment(t, r, replier, doc.ID, long, busy))
+
+	w := doRequest(t, r, http.MethodGet, "/api/docs/"+doc.ID.String()+"/comments", owner, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: status %d (%s)", w.Code, w.Body.String())
+	}
+	var threads []*models.Comment
+	decodeBody(t, w, &threads)
+	byID := map[uuid.UUID]*models.Comment{}
+	for _, c := range threads {
+		byID[c.ID] = c
+	}
+	if len(threads) != 2 || byID[quiet.ID] == nil || byID[busy.ID] == nil {
+		t.Fatalf("list = %+v, want the two top-level comments", threads)
+	}
+	if got := byID[quiet.ID]; got.ReplyCount != 0 || got.LatestReply != nil {
+		t.Errorf("quiet thread: reply_count %d, latest_reply %+v; want 0 and none", got.ReplyCount, got.LatestReply)
+	}
+	got := byID[busy.ID]
+	if got.ReplyCount != 3 {
+		t.Errorf("busy thread: reply_count = %d, want 3", got.ReplyCount)
+	}
+	latest := got.LatestReply
+	if latest == nil || latest.ID != replies[2].ID || latest.User == nil || latest.User.ID != replier.ID {
+		t.Fatalf("busy thread: latest_reply = %+v, want the last reply by %s", latest, replier.ID)
+	}
+	if latest.Preview != long[:140] {
+		t.Errorf("preview has %d characters, want the first 140", len(latest.Preview))
+	}
+
+	repliesPath := "/api/comments/" + busy.ID.String() + "/replies"
+	pages := []struct {
+		query string
+		want  []*models.Comment
+	}{
+		{"?limit=2", replies[:2]},
+		{"?limit=2&offset=2", replies[2:]},
+	}
+	for _, page := range pages {
+		w := doRequest(t, r, http.MethodGet, repliesPath+page.query, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("replies%s: status %d (%s)", page.query, w.Code, w.Body.String())
+		}
+		var got []*models.Comment
+		decodeBody(t, w, &got)
+		if len(got) != len(page.want) {
+			t.Fatalf("replies%s: got %d, want %d", page.query, len(got), len(page.want))
+		}
+		for i := range got {
+			if got[i].ID != page.want[i].ID || got[i].Content != page.want[i].Content {
+				t.Errorf("replies%s[%d] = %q, want %q", page.query, i, got[i].Content, page.want[i].Content)
+			}
+		}
+	}
+
+	if w := doRequest(t, r, http.MethodGet, repliesPath, stranger, nil); w.Code != http.StatusForbidden {
+		t.Errorf("replies without access: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestCreateCommentOpenCommentCap(t *testing.T) {
+	t.Setenv("MAX_COMMENTS_PER_DOC", "2")
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Busy")
+	other := dbtest.Document(t, database, owner.ID, "Quiet")
+
+	first := mustPostComment(t, r, owner, doc.ID, "One", nil)
+	mustPostComment(t, r, owner, doc.ID, "Two", nil)
+	if w := postComment(t, r, owner, doc.ID, "Three", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("at the limit: status %d, want %d (%s)", w.Code, http.StatusTooManyRequests, w.Body.String())
+	}
+	mustPostComment(t, r, owner, other.ID, "Elsewhere", nil)
+
+	// Resolved comments do not count towards the cap
+	if w := doRequest(t, r, http.MethodPost, "/api/comments/"+first.ID.String()+"/resolve", owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("resolve: status %d (%s)", w.Code, w.Body.String())
+	}
+	mustPostComment(t, r, owner, doc.ID, "Three", nil)
+}
+
+func TestListCommentsReplyTree(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	listPath := "/api/docs/" + doc.ID.String() + "/comments"
+
+	// top <- level 1 <- level 2 <- level 3
+	t.Setenv("COMMENT_MAX_REPLY_DEPTH", "3")
+	thread := []*models.Comment{mustPostComment(t, r, owner, doc.ID, "Top", nil)}
+	for _, content := range []string{"Level 1", "Level 2", "Level 3"} {
+		thread = append(thread, mustPostComment(t, r, owner, doc.ID, content, thread[len(thread)-1]))
+	}
+	if w := postComment(t, r, owner, doc.ID, "Level 4", thread[3]); w.Code != http.StatusBadRequest {
+		t.Errorf("reply below the max depth: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// tree lists the thread and returns how many levels of replies are nested
+	tree := func(t *testing.T, query string) int {
+		t.Helper()
+		w := doRequest(t, r, http.MethodGet, listPath+query, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var comments []*models.Comment
+		decodeBody(t, w, &comments)
+		if len(comments) != 1 || comments[0].ID != thread[0].ID {
+			t.Fatalf("comments = %+v, want the top-level comment only", comments)
+		}
+		depth := 0
+		for node := comments[0]; len(node.Replies) > 0; node = node.Replies[0] {
+			depth++
+			if len(node.Replies) != 1 || node.Replies[0].ID != thread[depth].ID {
+				t.Fatalf("level %d replies = %+v, want %q", depth, node.Replies, thread[depth].Content)
+			}
+		}
+		return depth
+	}
+
+	t.Run("full tree", func(t *testing.T) {
+		for _, query := range []string{"", "?replies=tree"} {
+			if depth := tree(t, query); depth != 3 {
+				t.Errorf("%q: nested %d levels, want 3", query, depth)
+			}
+		}
+	})
+	t.Run("limited by max depth", func(t *testing.T) {
+		t.Setenv("COMMENT_MAX_REPLY_DEPTH", "2")
+		if depth := tree(t, "?replies=tree"); depth != 2 {
+			t.Errorf("nested %d levels, want 2", depth)
+		}
+	})
+	t.Run("summaries only", func(t *testing.T) {
+		if depth := tree(t, "?replies=none"); depth != 0 {
+			t.Errorf("nested %d levels, want none", depth)
+		}
+	})
+	if w := doRequest(t, r, http.MethodGet, listPath+"?replies=all", owner, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid replies option: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResolveCommentWithNote(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	viewer := dbtest.User(t, database, "Viewer")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, viewer.ID, models.RoleView)
+
+	resolve := func(user *models.User, comment *models.Comment, body interface{}) *httptest.ResponseRecorder {
+		return doRequest(t, r, http.MethodPost, "/api/comments/"+comment.ID.String()+"/resolve", user, body)
+	}
+	type resolveResponse struct {
+		Comment *models.Comment `json:"comment"`
+		Note    *models.Comment `json:"note"`
+	}
+
+	t.Run("with note", func(t *testing.T) {
+		top := mustPostComment(t, r, owner, doc.ID, "Needs a source", nil)
+		w := resolve(owner, top, models.ResolveCommentRequest{Note: "Added the citation"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var got resolveResponse
+		decodeBody(t, w, &got)
+		if got.Comment == nil || !got.Comment.Resolved {
+			t.Errorf("comment = %+v, want resolved", got.Comment)
+		}
+		if got.Note == nil || !got.Note.IsResolution || got.Note.Content != "Added the citation" ||
+			got.Note.ParentID == nil || *got.Note.ParentID != top.ID {
+			t.Fatalf("note = %+v, want a resolution reply to the thread", got.Note)
+		}
+
+		w = doRequest(t, r, http.MethodGet, "/api/comments/"+top.ID.String()+"/replies", owner, nil)
+		var replies []*models.Comment
+		decodeBody(t, w, &replies)
+		if len(replies) != 1 || replies[0].ID != got.Note.ID || !replies[0].IsResolution {
+			t.Errorf("replies = %+v, want the resolution note", replies)
+		}
+	})
+
+	t.Run("without note", func(t *testing.T) {
+		top := mustPostComment(t, r, owner, doc.ID, "Typo", nil)
+		w := resolve(owner, top, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var got resolveResponse
+		decodeBody(t, w, &got)
+		if got.Comment == nil || !got.Comment.Resolved || got.Note != nil {
+			t.Errorf("response = %+v, want resolved without a note", got)
+		}
+		if w := resolve(owner, top, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("resolve twice: status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		top := mustPostComment(t, r, owner, doc.ID, "Open question", nil)
+		reply := mustPostComment(t, r, owner, doc.ID, "Answer", top)
+		if w := resolve(viewer, top, models.ResolveCommentRequest{Note: "Done"}); w.Code != http.StatusForbidden {
+			t.Errorf("viewer: status %d, want %d", w.Code, http.StatusForbidden)
+		}
+		if w := resolve(owner, reply, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("reply: status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+
+		// Neither rejection may have left a note or resolved the thread
+		w := doRequest(t, r, http.MethodGet, "/api/comments/"+top.ID.String()+"/replies", owner, nil)
+		var replies []*models.Comment
+		decodeBody(t, w, &replies)
+		if len(replies) != 1 || replies[0].IsResolution {
+			t.Errorf("replies = %+v, want only the original reply", replies)
+		}
+		comment, err := database.GetComment(context.Background(), top.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment.Resolved {
+			t.Error("thread resolved by a rejected request")
+		}
+	})
+}
+
+func TestListCommentsTwoLevelThread(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	editor := dbtest.User(t, database, "Editor")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, editor.ID, models.RoleEdit)
+
+	top := mustPostComment(t, r, owner, doc.ID, "Top", nil)
+	parentID := top.ID.String()
+	w := doRequest(t, r, http.MethodPost, "/api/docs/"+doc.ID.String()+"/comments", editor, models.CreateCommentRequest{
+		Content:   "First reply",
+		ParentID:  &parentID,
+		Selection: &models.Selection{Anchor: 1, Head: 4},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create reply: status %d (%s)", w.Code, w.Body.String())
+	}
+	var first models.Comment
+	decodeBody(t, w, &first)
+	second := mustPostComment(t, r, owner, doc.ID, "Second reply", top)
+
+	resolved := true
+	if w := doRequest(t, r, http.MethodPut, "/api/comments/"+first.ID.String(), editor, models.UpdateCommentRequest{Resolved: &resolved}); w.Code != http.StatusOK {
+		t.Fatalf("resolve reply: status %d (%s)", w.Code, w.Body.String())
+	}
+
+	// checkReplies asserts both replies come oldest first with their own state
+	checkReplies := func(t *testing.T, replies []*models.Comment) {
+		t.Helper()
+		if len(replies) != 2 || replies[0].ID != first.ID || replies[1].ID != second.ID {
+			t.Fatalf("replies = %+v, want the first then the second reply", replies)
+		}
+		if got := replies[0]; !got.Resolved || got.Selection == nil || got.Selection.Anchor != 1 || got.Selection.Head != 4 {
+			t.Errorf("first reply: resolved %v, selection %+v; want resolved with 1-4", got.Resolved, got.Selection)
+		}
+		if got := replies[1]; got.Resolved || got.Selection != nil {
+			t.Errorf("second reply: resolved %v, selection %+v; want neither", got.Resolved, got.Selection)
+		}
+		for _, reply := range replies {
+			if reply.ParentID == nil || *reply.ParentID != top.ID || reply.User == nil {
+				t.Errorf("reply %q: parent %v, user %+v; want the thread and its author", reply.Content, reply.ParentID, reply.User)
+			}
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		w := doRequest(t, r, http.MethodGet, "/api/docs/"+doc.ID.String()+"/comments", owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var comments []*models.Comment
+		decodeBody(t, w, &comments)
+		if len(comments) != 1 || comments[0].ID != top.ID || comments[0].Resolved {
+			t.Fatalf("comments = %+v, want the open top-level comment only", comments)
+		}
+		if comments[0].ReplyCount != 2 || comments[0].LatestReply == nil || comments[0].LatestReply.ID != second.ID {
+			t.Errorf("summary: reply_count %d, latest_reply %+v; want 2 and the second reply", comments[0].ReplyCount, comments[0].LatestReply)
+		}
+		checkReplies(t, comments[0].Replies)
+	})
+
+	t.Run("replies endpoint", func(t *testing.T) {
+		w := doRequest(t, r, http.MethodGet, "/api/comments/"+top.ID.String()+"/replies", editor, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var replies []*models.Comment
+		decodeBody(t, w, &replies)
+		checkReplies(t, replies)
+	})
+}
+
+func TestResolveCommentConcurrently(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	var resolvers []*models.User
+	for _, name := range []string{"First", "Second", "Third", "Fourth"} {
+		user := dbtest.User(t, database, name)
+		dbtest.Grant(t, database, doc.ID, user.ID, models.RoleComment)
+		resolvers = append(resolvers, user)
+	}
+	top := mustPostComment(t, r, owner, doc.ID, "Question", nil)
+	path := "/api/comments/" + top.ID.String() + "/resolve"
+
+	// All pass the handler's pre-check; only one may resolve and leave a note
+	codes := make(chan int, len(resolvers))
+	var wg sync.WaitGroup
+	for _, user := range resolvers {
+		wg.Add(1)
+		go func(user *models.User) {
+			defer wg.Done()
+			codes <- doRequest(t, r, http.MethodPost, path, user, models.ResolveCommentRequest{Note: "Done by " + user.Name}).Code
+		}(user)
+	}
+	wg.Wait()
+	close(codes)
+	ok := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d resolves succeeded, want 1", ok)
+	}
+
+	replies, err := database.ListCommentReplies(ctx, top.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || !replies[0].IsResolution {
+		t.Fatalf("replies = %+v, want one resolution note", replies)
+	}
+	comment, err := database.GetComment(ctx, top.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.ResolvedBy == nil || comment.ResolvedBy.ID != replies[0].UserID || comment.ResolvedAt == nil {
+		t.Fatalf("resolved_by %+v at %v, want the author of the note", comment.ResolvedBy, comment.ResolvedAt)
+	}
+
+	// Resolving again keeps the original resolver
+	if _, _, err := database.ResolveComment(ctx, top.ID, owner.ID, "Late"); !errors.Is(err, db.ErrCommentResolved) {
+		t.Errorf("resolve twice: err %v, want %v", err, db.ErrCommentResolved)
+	}
+	if again, _ := database.GetComment(ctx, top.ID); again.ResolvedBy == nil || again.ResolvedBy.ID != comment.ResolvedBy.ID ||
+		!again.ResolvedAt.Equal(*comment.ResolvedAt) {
+		t.Errorf("resolver changed to %+v at %v", again.ResolvedBy, again.ResolvedAt)
+	}
+}
+
+func TestUpdateCommentResolveChecksAccess(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	author := dbtest.User(t, database, "Author")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, author.ID, models.RoleComment)
+
+	comment := mustPostComment(t, r, author, doc.ID, "Mine", nil)
+	setResolved := func(user *models.User, resolved bool) int {
+		t.Helper()
+		path := "/api/comments/" + comment.ID.String()
+		return doRequest(t, r, http.MethodPut, path, user, models.UpdateCommentRequest{Resolved: &resolved}).Code
+	}
+
+	if code := setResolved(author, true); code != http.StatusOK {
+		t.Fatalf("resolve as commenter: status %d", code)
+	}
+	if code := setResolved(author, false); code != http.StatusOK {
+		t.Fatalf("reopen as commenter: status %d", code)
+	}
+
+	dbtest.Grant(t, database, doc.ID, author.ID, models.RoleView)
+	if code := setResolved(author, true); code != http.StatusForbidden {
+		t.Errorf("resolve after downgrade to view: status %d, want %d", code, http.StatusForbidden)
+	}
+	// Editing the text stays possible for the author
+	content := "Still mine"
+	if w := doRequest(t, r, http.MethodPut, "/api/comments/"+comment.ID.String(), author, models.UpdateCommentRequest{Content: &content}); w.Code != http.StatusOK {
+		t.Errorf("edit after downgrade: status %d (%s)", w.Code, w.Body.String())
+	}
+
+	if err := database.RemovePermission(ctx, doc.ID, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := setResolved(author, true); code != http.StatusForbidden {
+		t.Errorf("resolve after losing access: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	own := mustPostComment(t, r, owner, doc.ID, "Owner's", nil)
+	if err := database.SoftDeleteDocument(ctx, doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	resolved := true
+	w := doRequest(t, r, http.MethodPut, "/api/comments/"+own.ID.String(), owner, models.UpdateCommentRequest{Resolved: &resolved})
+	if w.Code != http.StatusGone {
+		t.Errorf("resolve on a trashed document: status %d, want %d", w.Code, http.StatusGone)
+	}
+
+	got, err := database.GetComment(ctx, comment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Resolved {
+		t.Error("a rejected request resolved the comment")
+	}
+}
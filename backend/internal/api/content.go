@@ -0,0 +1,76 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSnapshotContentTypes are the request media types accepted for snapshot uploads
+const defaultSnapshotContentTypes = gin.MIMEJSON
+
+// foreignFileTypes are sniffed media type prefixes that can never be a Yjs update
+var foreignFileTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/",
+	"application/pdf",
+	"application/zip",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/vnd.ms-fontobject",
+	"application/wasm",
+	"application/ogg",
+	"text/html",
+	"text/xml",
+}
+
+// snapshotContentTypes returns the accepted snapshot upload media types,
+// configurable via SNAPSHOT_CONTENT_TYPES (comma-separated)
+func snapshotContentTypes() []string {
+	list := os.Getenv("SNAPSHOT_CONTENT_TYPES")
+	if list == "" {
+		list = defaultSnapshotContentTypes
+	}
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// requireContentType rejects requests whose Content-Type is not one of allowed with 415
+func requireContentType(allowed []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err == nil {
+			for _, t := range allowed {
+				if mediaType == t {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported content type"})
+		c.Abort()
+	}
+}
+
+// sniffForeignFile reports the detected media type when data starts with the
+// magic bytes of a known file format (an image, archive, PDF, ...), which
+// means a client posted the wrong file rather than document state
+func sniffForeignFile(data []byte) (string, bool) {
+	detected := http.DetectContentType(data)
+	for _, prefix := range foreignFileTypes {
+		if strings.HasPrefix(detected, prefix) {
+			return detected, true
+		}
+	}
+	return "", false
+}
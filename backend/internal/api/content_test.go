@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSniffForeignFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		foreign bool
+	}{
+		{"yjs update", yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "Hi"}), false},
+		{"empty yjs update", []byte{0, 0}, false},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), true},
+		{"pdf", []byte("%PDF-1.7\n"), true},
+		{"zip", []byte("PK\x03\x04\x14\x00"), true},
+		{"gzip", []byte("\x1f\x8b\x08\x00"), true},
+		{"html", []byte("<!DOCTYPE html><html>"), true},
+	}
+	for _, tt := range tests {
+		if _, foreign := sniffForeignFile(tt.data); foreign != tt.foreign {
+			t.Errorf("%s: foreign = %v, want %v", tt.name, foreign, tt.foreign)
+		}
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	t.Setenv("SNAPSHOT_CONTENT_TYPES", "")
+	r := gin.New()
+	r.POST("/", requireContentType(snapshotContentTypes()), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		contentType string
+		want        int
+	}{
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"image/png", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("Content-Type %q: status %d, want %d", tt.contentType, w.Code, tt.want)
+		}
+	}
+
+	t.Setenv("SNAPSHOT_CONTENT_TYPES", "application/json, Application/Octet-Stream")
+	if got := snapshotContentTypes(); len(got) != 2 || got[1] != "application/octet-stream" {
+		t.Errorf("snapshotContentTypes = %q, want json and octet-stream", got)
+	}
+}
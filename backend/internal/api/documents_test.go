@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestDocumentResponsesIncludePermission(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	editor := dbtest.User(t, database, "Editor")
+	viewer := dbtest.User(t, database, "Viewer")
+
+	w := doRequest(t, r, http.MethodPost, "/api/docs", owner, models.CreateDocumentRequest{Title: "Plan"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d (%s)", w.Code, w.Body.String())
+	}
+	var doc models.Document
+	decodeBody(t, w, &doc)
+	if doc.Permission != models.RoleOwner {
+		t.Errorf("create: permission = %q, want %q", doc.Permission, models.RoleOwner)
+	}
+	dbtest.Grant(t, database, doc.ID, editor.ID, models.RoleEdit)
+	dbtest.Grant(t, database, doc.ID, viewer.ID, models.RoleView)
+	docPath := "/api/docs/" + doc.ID.String()
+
+	tests := []struct {
+		name   string
+		method string
+		user   *models.User
+		body   interface{}
+		want   string
+	}{
+		{"get as owner", http.MethodGet, owner, nil, models.RoleOwner},
+		{"get as viewer", http.MethodGet, viewer, nil, models.RoleView},
+		{"update as editor", http.MethodPut, editor, models.UpdateDocumentRequest{Title: "Plan v2"}, models.RoleEdit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, r, tt.method, docPath, tt.user, tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+			}
+			var got models.Document
+			decodeBody(t, w, &got)
+			if got.Permission != tt.want {
+				t.Errorf("permission = %q, want %q", got.Permission, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDocumentAccess(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	viewer := dbtest.User(t, database, "Viewer")
+	stranger := dbtest.User(t, database, "Stranger")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, viewer.ID, models.RoleView)
+	trashed := dbtest.Document(t, database, owner.ID, "Trashed")
+	if w := doRequest(t, r, http.MethodDelete, "/api/docs/"+trashed.ID.String(), owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("trash: status %d (%s)", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		path   string
+		status int
+		role   string
+	}{
+		{"owner", owner, doc.ID.String(), http.StatusOK, models.RoleOwner},
+		{"viewer", viewer, doc.ID.String(), http.StatusOK, models.RoleView},
+		{"no permission", stranger, doc.ID.String(), http.StatusForbidden, ""},
+		{"not found", owner, uuid.NewString(), http.StatusNotFound, ""},
+		{"trashed", owner, trashed.ID.String(), http.StatusNotFound, ""},
+		{"invalid ID", owner, "nope", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, r, http.MethodHead, "/api/docs/"+tt.path, tt.user, nil)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get(DocumentRoleHeader); got != tt.role {
+				t.Errorf("%s = %q, want %q", DocumentRoleHeader, got, tt.role)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("body = %q, want none", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateSanitizesNames(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+
+	w := doRequest(t, r, http.MethodPost, "/api/docs", owner, models.CreateDocumentRequest{Title: "Q1\nplan\x00 draft"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create document: status %d (%s)", w.Code, w.Body.String())
+	}
+	var doc models.Document
+	decodeBody(t, w, &doc)
+	if doc.Title != "Q1 plan draft" {
+		t.Errorf("title = %q, want %q", doc.Title, "Q1 plan draft")
+	}
+	if w := doRequest(t, r, http.MethodPost, "/api/docs", owner, models.CreateDocumentRequest{Title: "\x00\n"}); w.Code != http.StatusBadRequest {
+		t.Errorf("title of control characters only: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/api/folders", owner, models.CreateFolderRequest{Name: "Team\r\n\x00notes"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create folder: status %d (%s)", w.Code, w.Body.String())
+	}
+	var folder models.Folder
+	decodeBody(t, w, &folder)
+	if folder.Name != "Team notes" {
+		t.Errorf("folder name = %q, want %q", folder.Name, "Team notes")
+	}
+
+	w = doRequest(t, r, http.MethodPost, "/api/docs/"+doc.ID.String()+"/comments", owner, models.CreateCommentRequest{Content: "first\r\nsecond\x00"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create comment: status %d (%s)", w.Code, w.Body.String())
+	}
+	var comment models.Comment
+	decodeBody(t, w, &comment)
+	if comment.Content != "first\nsecond" {
+		t.Errorf("comment content = %q, want %q", comment.Content, "first\nsecond")
+	}
+}
+
+func TestListDocumentsByRole(t *testing.T) {
+	r, database := newTestAPI(t)
+	user := dbtest.User(t, database, "User")
+	other := dbtest.User(t, database, "Other")
+
+	// user owns one document and has each lesser role on one of other's
+	docs := map[string]*models.Document{models.RoleOwner: dbtest.Document(t, database, user.ID, "Owned")}
+	for _, role := range []string{models.RoleEdit, models.RoleComment, models.RoleView} {
+		docs[role] = dbtest.Document(t, database, other.ID, role)
+		dbtest.Grant(t, database, docs[role].ID, user.ID, role)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{models.RoleOwner, models.RoleEdit, models.RoleComment, models.RoleView}},
+		{"role=edit", []string{models.RoleEdit}},
+		{"role=view", []string{models.RoleView}},
+		{"min_role=edit", []string{models.RoleOwner, models.RoleEdit}},
+		{"min_role=comment", []string{models.RoleOwner, models.RoleEdit, models.RoleComment}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := doRequest(t, r, http.MethodGet, "/api/docs?"+tt.query, user, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+			}
+			var page models.DocumentPage
+			decodeBody(t, w, &page)
+			got := make(map[uuid.UUID]bool)
+			for _, doc := range page.Items {
+				got[doc.ID] = true
+			}
+			if len(got) != len(tt.want) || page.Total != len(tt.want) {
+				t.Errorf("got %d documents (total %d), want %v", len(got), page.Total, tt.want)
+			}
+			for _, role := range tt.want {
+				if !got[docs[role].ID] {
+					t.Errorf("missing the %s document", role)
+				}
+			}
+		})
+	}
+
+	for _, query := range []string{"role=admin", "min_role=Owner", "role=edit&min_role=view"} {
+		if w := doRequest(t, r, http.MethodGet, "/api/docs?"+query, user, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
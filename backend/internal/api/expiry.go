@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/logger"
+)
+
+// Access request expiry defaults
+const (
+	defaultAccessRequestTTL            = 7 * 24 * time.Hour
+	defaultAccessRequestExpiryInterval = time.Hour
+)
+
+// AccessRequestTTL returns how long an access request stays pending,
+// configurable via ACCESS_REQUEST_TTL (e.g. "72h", at least a minute)
+func AccessRequestTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("ACCESS_REQUEST_TTL"))
+	if err != nil || ttl < time.Minute {
+		return defaultAccessRequestTTL
+	}
+	return ttl
+}
+
+// AccessRequestExpiryInterval returns how often expired access requests are
+// swept, configurable via ACCESS_REQUEST_EXPIRY_INTERVAL (at least a second)
+func AccessRequestExpiryInterval() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("ACCESS_REQUEST_EXPIRY_INTERVAL"))
+	if err != nil || d < time.Second {
+		return defaultAccessRequestExpiryInterval
+	}
+	return d
+}
+
+// RunAccessRequestExpiry marks pending access requests past their expiry as
+// expired every interval until ctx is cancelled
+func RunAccessRequestExpiry(ctx context.Context, database *db.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := database.ExpireAccessRequests(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("ExpireAccessRequests: %v", err)
+				}
+				continue
+			}
+			if n > 0 {
+				logger.Info("[Expiry] marked %d access request(s) as expired", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestFolderTreeDepth(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	other := dbtest.User(t, database, "Other")
+
+	// Owner has /A/B/C, a document in A and one at the root
+	folder := func(name string, parent *models.Folder) *models.Folder {
+		var parentID *uuid.UUID
+		if parent != nil {
+			parentID = &parent.ID
+		}
+		f, err := database.CreateFolder(ctx, name, owner.ID, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	a := folder("A", nil)
+	b := folder("B", a)
+	c := folder("C", b)
+	inA := dbtest.Document(t, database, owner.ID, "In A")
+	if err := database.MoveDocument(ctx, inA.ID, &a.ID); err != nil {
+		t.Fatal(err)
+	}
+	atRoot := dbtest.Document(t, database, owner.ID, "At root")
+
+	getLevel := func(t *testing.T, query string) *models.FolderTreeLevel {
+		t.Helper()
+		w := doRequest(t, r, http.MethodGet, "/api/folders/tree?"+query, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var level models.FolderTreeLevel
+		decodeBody(t, w, &level)
+		return &level
+	}
+
+	t.Run("root depth 1", func(t *testing.T) {
+		level := getLevel(t, "depth=1")
+		if level.Folder != nil {
+			t.Errorf("folder = %+v, want nil at the root", level.Folder)
+		}
+		if len(level.Children) != 1 || level.Children[0].ID != a.ID {
+			t.Fatalf("children = %+v, want A", level.Children)
+		}
+		node := level.Children[0]
+		if len(node.Children) != 0 || !node.HasChildren {
+			t.Errorf("A: %d children loaded, has_children %v; want none loaded and true", len(node.Children), node.HasChildren)
+		}
+		if len(node.Documents) != 1 || node.Documents[0].ID != inA.ID {
+			t.Errorf("A documents = %+v, want the document in A", node.Documents)
+		}
+		if len(level.Documents) != 1 || level.Documents[0].ID != atRoot.ID {
+			t.Errorf("root documents = %+v, want the root document", level.Documents)
+		}
+	})
+
+	t.Run("folder depth 1", func(t *testing.T) {
+		level := getLevel(t, "depth=1&folder_id="+a.ID.String())
+		if level.Folder == nil || level.Folder.ID != a.ID {
+			t.Errorf("folder = %+v, want A", level.Folder)
+		}
+		if len(level.Children) != 1 || level.Children[0].ID != b.ID {
+			t.Fatalf("children = %+v, want B", level.Children)
+		}
+		node := level.Children[0]
+		if node.Path != "/A/B" || node.Level != 1 {
+			t.Errorf("B: path %q level %d, want /A/B and 1", node.Path, node.Level)
+		}
+		if len(node.Children) != 0 || !node.HasChildren {
+			t.Errorf("B: %d children loaded, has_children %v; want none loaded and true", len(node.Children), node.HasChildren)
+		}
+		if len(level.Documents) != 1 || level.Documents[0].ID != inA.ID {
+			t.Errorf("documents = %+v, want the document in A", level.Documents)
+		}
+	})
+
+	t.Run("root depth 2", func(t *testing.T) {
+		level := getLevel(t, "depth=2")
+		if len(level.Children) != 1 || len(level.Children[0].Children) != 1 {
+			t.Fatalf("children = %+v, want A with B", level.Children)
+		}
+		if node := level.Children[0].Children[0]; node.ID != b.ID || len(node.Children) != 0 {
+			t.Errorf("second level = %+v, want B without loaded children", node)
+		}
+	})
+
+	t.Run("full tree", func(t *testing.T) {
+		w := doRequest(t, r, http.MethodGet, "/api/folders/tree", owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s)", w.Code, w.Body.String())
+		}
+		var tree []*models.FolderTreeNode
+		decodeBody(t, w, &tree)
+		if len(tree) != 1 || len(tree[0].Children) != 1 || len(tree[0].Children[0].Children) != 1 {
+			t.Fatalf("tree = %+v, want A/B/C", tree)
+		}
+		if got := tree[0].Children[0].Children[0]; got.ID != c.ID || got.Path != "/A/B/C" {
+			t.Errorf("leaf = %+v, want C at /A/B/C", got)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			user   *models.User
+			query  string
+			status int
+		}{
+			{"zero depth", owner, "depth=0", http.StatusBadRequest},
+			{"bad folder", owner, "depth=1&folder_id=nope", http.StatusBadRequest},
+			{"unknown folder", owner, "depth=1&folder_id=" + uuid.NewString(), http.StatusNotFound},
+			{"other user's folder", other, "depth=1&folder_id=" + a.ID.String(), http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			if w := doRequest(t, r, http.MethodGet, "/api/folders/tree?"+tt.query, tt.user, nil); w.Code != tt.status {
+				t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.status, w.Body.String())
+			}
+		}
+	})
+}
+
+func TestTransferFolder(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	target := dbtest.User(t, database, "Target")
+
+	// Owner has /P/A/B with a document in A and one in B; only A's subtree moves
+	parent, err := database.CreateFolder(ctx, "P", owner.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := database.CreateFolder(ctx, "A", owner.ID, &parent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := database.CreateFolder(ctx, "B", owner.ID, &a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []*models.Document{
+		dbtest.Document(t, database, owner.ID, "In A"),
+		dbtest.Document(t, database, owner.ID, "In B"),
+	}
+	for i, folderID := range []uuid.UUID{a.ID, b.ID} {
+		if err := database.MoveDocument(ctx, docs[i].ID, &folderID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := "/api/folders/" + a.ID.String() + "/transfer"
+
+	if w := doRequest(t, r, http.MethodPost, path, target, models.TransferFolderRequest{UserID: target.ID.String()}); w.Code != http.StatusForbidden {
+		t.Errorf("transfer by non-owner: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := doRequest(t, r, http.MethodPost, path, owner, models.TransferFolderRequest{UserID: uuid.NewString()}); w.Code != http.StatusNotFound {
+		t.Errorf("transfer to unknown user: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w := doRequest(t, r, http.MethodPost, path, owner, models.TransferFolderRequest{UserID: target.ID.String()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("transfer: status %d (%s)", w.Code, w.Body.String())
+	}
+	var result struct {
+		Folders   int `json:"folders"`
+		Documents int `json:"documents"`
+	}
+	decodeBody(t, w, &result)
+	if result.Folders != 2 || result.Documents != 2 {
+		t.Errorf("transferred %d folders and %d documents, want 2 and 2", result.Folders, result.Documents)
+	}
+
+	for _, want := range []struct {
+		folder *models.Folder
+		owner  uuid.UUID
+	}{{parent, owner.ID}, {a, target.ID}, {b, target.ID}} {
+		got, err := database.GetFolder(ctx, want.folder.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.OwnerID != want.owner {
+			t.Errorf("folder %s: owner %s, want %s", got.Name, got.OwnerID, want.owner)
+		}
+	}
+	if got, _ := database.GetFolder(ctx, a.ID); got.ParentID != nil {
+		t.Errorf("A still has parent %s in the previous owner's tree", got.ParentID)
+	}
+
+	for _, doc := range docs {
+		got, err := database.GetDocument(ctx, doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.OwnerID != target.ID {
+			t.Errorf("%s: owner %s, want %s", doc.Title, got.OwnerID, target.ID)
+		}
+		for user, role := range map[*models.User]string{target: models.RoleOwner, owner: models.RoleEdit} {
+			perm, err := database.GetPermission(ctx, doc.ID, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm == nil || perm.Role != role {
+				t.Errorf("%s: %s has %+v, want %s", doc.Title, user.Name, perm, role)
+			}
+		}
+	}
+
+	var tree []*models.FolderTreeNode
+	decodeBody(t, doRequest(t, r, http.MethodGet, "/api/folders/tree", target, nil), &tree)
+	if len(tree) != 1 || tree[0].ID != a.ID || tree[0].Path != "/A" || len(tree[0].Children) != 1 || tree[0].Children[0].Path != "/A/B" {
+		t.Errorf("target's tree = %+v, want /A/B", tree)
+	}
+}
+
+func TestUniqueFolderNames(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	other := dbtest.User(t, database, "Other")
+
+	create := func(t *testing.T, user *models.User, name string, parent *models.Folder) *httptest.ResponseRecorder {
+		t.Helper()
+		req := models.CreateFolderRequest{Name: name}
+		if parent != nil {
+			req.ParentID = &parent.ID
+		}
+		return doRequest(t, r, http.MethodPost, "/api/folders", user, req)
+	}
+	mustCreate := func(t *testing.T, name string, parent *models.Folder) *models.Folder {
+		t.Helper()
+		w := create(t, owner, name, parent)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create %q: status %d (%s)", name, w.Code, w.Body.String())
+		}
+		var folder models.Folder
+		decodeBody(t, w, &folder)
+		return &folder
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		mustCreate(t, "Reports", nil)
+		mustCreate(t, "Reports", nil)
+	})
+
+	t.Run("sibling collisions", func(t *testing.T) {
+		t.Setenv("ENFORCE_UNIQUE_FOLDER_NAMES", "true")
+		drafts := mustCreate(t, "Drafts", nil)
+		archive := mustCreate(t, "Archive", nil)
+		nested := mustCreate(t, "Drafts", archive)
+
+		if w := create(t, owner, "drafts", nil); w.Code != http.StatusConflict {
+			t.Errorf("create sibling: status %d, want %d", w.Code, http.StatusConflict)
+		}
+		if w := create(t, other, "Drafts", nil); w.Code != http.StatusCreated {
+			t.Errorf("create in another user's root: status %d, want %d (%s)", w.Code, http.StatusCreated, w.Body.String())
+		}
+
+		rename := func(folder *models.Folder, name string) int {
+			path := "/api/folders/" + folder.ID.String()
+			return doRequest(t, r, http.MethodPut, path, owner, models.UpdateFolderRequest{Name: name}).Code
+		}
+		if code := rename(archive, "DRAFTS"); code != http.StatusConflict {
+			t.Errorf("rename onto a sibling: status %d, want %d", code, http.StatusConflict)
+		}
+		if code := rename(drafts, "Drafts"); code != http.StatusOK {
+			t.Errorf("rename to its own name: status %d, want %d", code, http.StatusOK)
+		}
+
+		move := doRequest(t, r, http.MethodPut, "/api/folders/"+nested.ID.String()+"/move", owner, models.MoveItemRequest{})
+		if move.Code != http.StatusConflict {
+			t.Errorf("move next to a sibling: status %d, want %d", move.Code, http.StatusConflict)
+		}
+		got, err := database.GetFolder(context.Background(), nested.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ParentID == nil || *got.ParentID != archive.ID {
+			t.Errorf("rejected move changed the parent to %v", got.ParentID)
+		}
+	})
+}
+
+func TestDeleteFolderDocuments(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+
+	// folderWithDoc creates a folder holding one document
+	folderWithDoc := func(t *testing.T, name string, parent *models.Folder) (*models.Folder, *models.Document) {
+		t.Helper()
+		var parentID *uuid.UUID
+		if parent != nil {
+			parentID = &parent.ID
+		}
+		folder, err := database.CreateFolder(ctx, name, owner.ID, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := dbtest.Document(t, database, owner.ID, "In "+name)
+		if err := database.MoveDocument(ctx, doc.ID, &folder.ID); err != nil {
+			t.Fatal(err)
+		}
+		return folder, doc
+	}
+	deleteFolder := func(t *testing.T, folder *models.Folder, query string) {
+		t.Helper()
+		w := doRequest(t, r, http.MethodDelete, "/api/folders/"+folder.ID.String()+query, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("delete %s: status %d (%s)", folder.Name, w.Code, w.Body.String())
+		}
+		if got, err := database.GetFolder(ctx, folder.ID); err != nil || got != nil {
+			t.Fatalf("folder %s still exists (%v)", folder.Name, err)
+		}
+	}
+	// check asserts where a document ended up and whether it is in the trash
+	check := func(t *testing.T, doc *models.Document, folderID *uuid.UUID, trashed bool) {
+		t.Helper()
+		got, err := database.GetDocument(ctx, doc.ID)
+		if err != nil || got == nil {
+			t.Fatalf("%s: document gone (%v)", doc.Title, err)
+		}
+		if (got.FolderID == nil) != (folderID == nil) || (folderID != nil && *got.FolderID != *folderID) {
+			t.Errorf("%s: folder %v, want %v", doc.Title, got.FolderID, folderID)
+		}
+		if (got.DeletedAt != nil) != trashed {
+			t.Errorf("%s: deleted_at %v, want trashed %v", doc.Title, got.DeletedAt, trashed)
+		}
+	}
+
+	t.Run("reparent to parent", func(t *testing.T) {
+		parent, inParent := folderWithDoc(t, "Parent", nil)
+		child, inChild := folderWithDoc(t, "Child", parent)
+		_, inGrandchild := folderWithDoc(t, "Grandchild", child)
+		deleteFolder(t, child, "")
+		check(t, inParent, &parent.ID, false)
+		check(t, inChild, &parent.ID, false)
+		check(t, inGrandchild, &parent.ID, false)
+	})
+
+	t.Run("reparent to root", func(t *testing.T) {
+		top, inTop := folderWithDoc(t, "Top", nil)
+		_, inNested := folderWithDoc(t, "Nested", top)
+		deleteFolder(t, top, "?documents=reparent")
+		check(t, inTop, nil, false)
+		check(t, inNested, nil, false)
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		t.Setenv("FOLDER_DELETE_POLICY", "trash")
+		parent, _ := folderWithDoc(t, "Old parent", nil)
+		child, inChild := folderWithDoc(t, "Old child", parent)
+		deleteFolder(t, child, "")
+		check(t, inChild, &parent.ID, true)
+
+		// The query parameter overrides the default
+		kept, inKept := folderWithDoc(t, "Kept", nil)
+		deleteFolder(t, kept, "?documents=reparent")
+		check(t, inKept, nil, false)
+	})
+
+	folder, _ := folderWithDoc(t, "Invalid", nil)
+	if w := doRequest(t, r, http.MethodDelete, "/api/folders/"+folder.ID.String()+"?documents=delete", owner, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid policy: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDuplicateFolder(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	other := dbtest.User(t, database, "Other")
+
+	// Owner has /Src/Sub/Deep with a document in Src and one in Sub
+	src, err := database.CreateFolder(ctx, "Src", owner.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := database.CreateFolder(ctx, "Sub", owner.ID, &src.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateFolder(ctx, "Deep", owner.ID, &sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	content := map[string][]string{"Readme": {`{"v":1}`, `{"v":2}`}, "Notes": {`{"notes":true}`}}
+	for title, folder := range map[string]*models.Folder{"Readme": src, "Notes": sub} {
+		doc := dbtest.Document(t, database, owner.ID, title)
+		if err := database.MoveDocument(ctx, doc.ID, &folder.ID); err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range content[title] {
+			if _, err := database.SaveSnapshot(ctx, doc.ID, []byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	path := "/api/folders/" + src.ID.String() + "/duplicate"
+
+	if w := doRequest(t, r, http.MethodPost, path, other, nil); w.Code != http.StatusForbidden {
+		t.Errorf("duplicate by non-owner: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w := doRequest(t, r, http.MethodPost, path, owner, models.DuplicateFolderRequest{Name: "Template"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("duplicate: status %d (%s)", w.Code, w.Body.String())
+	}
+	var result struct {
+		Folder    *models.Folder `json:"folder"`
+		Folders   int            `json:"folders"`
+		Documents int            `json:"documents"`
+	}
+	decodeBody(t, w, &result)
+	if result.Folders != 3 || result.Documents != 2 {
+		t.Errorf("copied %d folders and %d documents, want 3 and 2", result.Folders, result.Documents)
+	}
+	if result.Folder == nil || result.Folder.Name != "Template" || result.Folder.ParentID != nil {
+		t.Fatalf("folder = %+v, want Template at the root", result.Folder)
+	}
+
+	var tree []*models.FolderTreeNode
+	decodeBody(t, doRequest(t, r, http.MethodGet, "/api/folders/tree", owner, nil), &tree)
+	var copied *models.FolderTreeNode
+	for _, node := range tree {
+		if node.ID == result.Folder.ID {
+			copied = node
+		}
+	}
+	if copied == nil || len(copied.Children) != 1 || len(copied.Children[0].Children) != 1 {
+		t.Fatalf("tree = %+v, want Template/Sub/Deep", tree)
+	}
+	if got := copied.Children[0].Children[0]; got.Path != "/Template/Sub/Deep" {
+		t.Errorf("leaf path %q, want /Template/Sub/Deep", got.Path)
+	}
+
+	// Each copied document is a new document holding the latest snapshot of its original
+	for _, folder := range []*models.FolderTreeNode{copied, copied.Children[0]} {
+		contents, err := database.GetFolderContents(ctx, owner.ID, &folder.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contents.Documents) != 1 {
+			t.Fatalf("%s: documents = %+v, want one copy", folder.Name, contents.Documents)
+		}
+		doc := contents.Documents[0]
+		versions := content[doc.Title]
+		snapshot, err := database.GetLatestSnapshot(ctx, doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot == nil || string(snapshot.Snapshot) != versions[len(versions)-1] {
+			t.Errorf("%s: snapshot %+v, want %s", doc.Title, snapshot, versions[len(versions)-1])
+		}
+		if perm, err := database.GetPermission(ctx, doc.ID, owner.ID); err != nil || perm == nil || perm.Role != models.RoleOwner {
+			t.Errorf("%s: owner permission %+v (%v)", doc.Title, perm, err)
+		}
+	}
+
+	if _, _, _, err := database.DuplicateFolder(ctx, src.ID, owner.ID, "Too big", 4); !errors.Is(err, db.ErrSubtreeTooLarge) {
+		t.Errorf("5 nodes with a limit of 4: err %v, want %v", err, db.ErrSubtreeTooLarge)
+	}
+}
+
+func TestDeleteFolderTrashEvictsRooms(t *testing.T) {
+	database := dbtest.New(t)
+	pubsub := newTestRedis(t)
+	r := gin.New()
+	NewHandler(database, pubsub).RegisterRoutes(r)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+
+	evicted := make(chan string, 8)
+	sub, err := pubsub.PSubscribe(ctx, RoomEvictChannel, func(channel string, payload []byte) {
+		evicted <- string(payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// folderWithDoc creates a folder holding one document
+	folderWithDoc := func(name string, parent *models.Folder) (*models.Folder, *models.Document) {
+		t.Helper()
+		var parentID *uuid.UUID
+		if parent != nil {
+			parentID = &parent.ID
+		}
+		folder, err := database.CreateFolder(ctx, name, owner.ID, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := dbtest.Document(t, database, owner.ID, "In "+name)
+		if err := database.MoveDocument(ctx, doc.ID, &folder.ID); err != nil {
+			t.Fatal(err)
+		}
+		return folder, doc
+	}
+	deleteFolder := func(folder *models.Folder, policy string) {
+		t.Helper()
+		w := doRequest(t, r, http.MethodDelete, "/api/folders/"+folder.ID.String()+"?documents="+policy, owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("delete %s: status %d (%s)", folder.Name, w.Code, w.Body.String())
+		}
+	}
+
+	// Reparenting first: its document must not be evicted, and its message would
+	// arrive before the trashed ones
+	reparented, kept := folderWithDoc("Reparented", nil)
+	deleteFolder(reparented, db.FolderDeleteReparent)
+	trashed, inTrashed := folderWithDoc("Trashed", nil)
+	_, inNested := folderWithDoc("Nested", trashed)
+	deleteFolder(trashed, db.FolderDeleteTrash)
+
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		select {
+		case payload := <-evicted:
+			got[payload] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("evictions %v, want both trashed documents", got)
+		}
+	}
+	for _, doc := range []*models.Document{inTrashed, inNested} {
+		if !got[`{"room":"`+doc.ID.String()+`"}`] {
+			t.Errorf("no eviction for %s (got %v)", doc.Title, got)
+		}
+	}
+
+	var page models.DocumentPage
+	decodeBody(t, doRequest(t, r, http.MethodGet, "/api/docs", owner, nil), &page)
+	if len(page.Items) != 1 || page.Items[0].ID != kept.ID {
+		t.Errorf("documents = %+v, want only the reparented one", page.Items)
+	}
+}
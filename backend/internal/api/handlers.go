@@ -1,25 +1,268 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/collab-docs/backend/internal/auth"
 	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/email"
 	"github.com/collab-docs/backend/internal/logger"
 	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/redis"
+	"github.com/collab-docs/backend/internal/webhook"
+	"github.com/collab-docs/backend/internal/yjs"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // Handler holds the dependencies for API handlers
 type Handler struct {
-	db *db.DB
+	db       *db.DB
+	redis    *redis.PubSub       // nil when Redis is not available
+	webhooks *webhook.Dispatcher // nil when WEBHOOK_URL is not set
+	email    email.EmailSender   // logs instead of sending when SMTP_HOST is not set
 }
 
-// NewHandler creates a new API handler
-func NewHandler(database *db.DB) *Handler {
-	return &Handler{db: database}
+// NewHandler creates a new API handler; pubsub may be nil
+func NewHandler(database *db.DB, pubsub *redis.PubSub) *Handler {
+	return &Handler{db: database, redis: pubsub, webhooks: webhook.NewFromEnv(), email: email.NewFromEnv()}
+}
+
+// SetEmailSender replaces the sender used for outgoing email
+func (h *Handler) SetEmailSender(sender email.EmailSender) {
+	h.email = sender
+}
+
+// emailSendTimeout bounds one background email delivery
+const emailSendTimeout = 30 * time.Second
+
+// sendEmail delivers a message in the background so slow mail servers do not
+// hold up the request; failures are only logged
+func (h *Handler) sendEmail(to, subject, body string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		defer cancel()
+		if err := h.email.Send(ctx, to, subject, body); err != nil {
+			logger.Error("[Email] failed to send %q to %s: %v", subject, to, err)
+		}
+	}()
+}
+
+// defaultCommentBodyLimit bounds comment request bodies (content + selection)
+const defaultCommentBodyLimit = 64 << 10
+
+// maxSelectionBlockIDLen bounds the only free-form field of a comment selection
+const maxSelectionBlockIDLen = 128
+
+// commentBodyLimit returns the maximum comment request body size in bytes,
+// configurable via COMMENT_MAX_BODY_BYTES
+func commentBodyLimit() int64 {
+	if v := os.Getenv("COMMENT_MAX_BODY_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultCommentBodyLimit
+}
+
+// limitBody caps the request body size; reads beyond the limit fail and binding returns 400
+func limitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// List pagination (?limit=&offset=)
+const (
+	defaultPageLimit  = 50
+	maxPageLimit      = 100
+	maxDocumentsLimit = 200 // GET /api/docs allows larger pages
+)
+
+// parsePagination reads limit/offset query params, writing a 400 and returning false when invalid
+func parsePagination(c *gin.Context) (limit, offset int, ok bool) {
+	return parsePaginationMax(c, maxPageLimit)
+}
+
+// parsePaginationMax is parsePagination with a custom upper bound for limit
+func parsePaginationMax(c *gin.Context, maxLimit int) (limit, offset int, ok bool) {
+	limit = defaultPageLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxLimit)})
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// maxCommentsPerDoc returns the cap on open comments per document
+// (MAX_COMMENTS_PER_DOC); 0 means unlimited
+func maxCommentsPerDoc() int {
+	n, err := strconv.Atoi(os.Getenv("MAX_COMMENTS_PER_DOC"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Reply nesting limits; depth 1 means replies may only target top-level comments
+const (
+	defaultReplyDepth = 1
+	maxReplyDepthCap  = 10
+)
+
+// maxReplyDepth returns how many levels of replies a thread may have,
+// configurable via COMMENT_MAX_REPLY_DEPTH (1-10)
+func maxReplyDepth() int {
+	n, err := strconv.Atoi(os.Getenv("COMMENT_MAX_REPLY_DEPTH"))
+	if err != nil || n < 1 {
+		return defaultReplyDepth
+	}
+	if n > maxReplyDepthCap {
+		return maxReplyDepthCap
+	}
+	return n
+}
+
+// validRole reports whether role is one of the document permission roles
+func validRole(role string) bool {
+	switch role {
+	case models.RoleOwner, models.RoleEdit, models.RoleComment, models.RoleView:
+		return true
+	}
+	return false
+}
+
+// maxTagLength is the longest tag accepted, matching tags.tag
+const maxTagLength = 50
+
+// normalizeTag lowercases and trims a tag; ok is false when it is empty or too long
+func normalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(sanitizeName(tag))
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return "", false
+	}
+	return tag, true
+}
+
+// folderDeletePolicy returns the default handling of documents in a deleted
+// folder subtree (FOLDER_DELETE_POLICY=reparent|trash, default reparent)
+func folderDeletePolicy() string {
+	if strings.EqualFold(os.Getenv("FOLDER_DELETE_POLICY"), db.FolderDeleteTrash) {
+		return db.FolderDeleteTrash
+	}
+	return db.FolderDeleteReparent
+}
+
+// uniqueFolderNames reports whether sibling folders must have distinct names
+// (ENFORCE_UNIQUE_FOLDER_NAMES=true); off by default
+func uniqueFolderNames() bool {
+	v, _ := strconv.ParseBool(os.Getenv("ENFORCE_UNIQUE_FOLDER_NAMES"))
+	return v
+}
+
+// verifyCommentBlocks reports whether comment selections must reference a block
+// present in the document (COMMENT_VERIFY_BLOCK_ID=true); see selectionBlockExists
+func verifyCommentBlocks() bool {
+	v, _ := strconv.ParseBool(os.Getenv("COMMENT_VERIFY_BLOCK_ID"))
+	return v
+}
+
+// blockExists reports whether the document content contains an element with the given block ID
+func blockExists(snapshot []byte, blockID string) (bool, error) {
+	doc, err := yjs.DecodeUpdate(snapshot)
+	if err != nil {
+		return false, err
+	}
+	fragment := doc.XmlFragment("default") // TipTap's collaboration fragment
+	if fragment == nil {
+		return false, nil
+	}
+	found := false
+	fragment.Walk(func(n *yjs.XmlNode) {
+		if id, ok := n.Attrs["id"].(string); ok && id == blockID {
+			found = true
+		}
+	})
+	return found, nil
+}
+
+// selectionBlockExists reports whether a comment may anchor to blockID. While
+// the document's room is open only its live content is current (the collab
+// server persists when the last client leaves), so the collab server is asked
+// first. The latest snapshot decides once the room is known to be closed. When
+// the room state is unknown, or there is no snapshot to check against (a new
+// document), the selection is accepted rather than rejected on stale content.
+func (h *Handler) selectionBlockExists(ctx context.Context, docID uuid.UUID, blockID string) (bool, error) {
+	state, err := liveRoomBlock(ctx, docID, blockID)
+	if err != nil {
+		logger.Warn("CreateComment: live block check failed for doc %s, accepting selection: %v", docID, err)
+		return true, nil
+	}
+	switch state {
+	case roomHasBlock:
+		return true, nil
+	case roomLacksBlock:
+		return false, nil
+	case roomUnknown:
+		return true, nil
+	}
+
+	snapshot, err := h.db.GetLatestSnapshot(ctx, docID)
+	if err != nil {
+		return false, err
+	}
+	if snapshot == nil {
+		return true, nil
+	}
+	exists, err := blockExists(snapshot.Snapshot, blockID)
+	if err != nil {
+		// Don't block commenting on a snapshot we can't decode
+		logger.Error("CreateComment: failed to decode snapshot for doc %s: %v", docID, err)
+		return true, nil
+	}
+	return exists, nil
+}
+
+// validSelection checks a selection's ranges and bounds its size.
+// Unknown fields are already dropped when binding into models.Selection,
+// and the struct is re-marshaled before storage.
+func validSelection(sel *models.Selection) bool {
+	if sel == nil {
+		return true
+	}
+	return sel.Anchor >= 0 && sel.Head >= 0 && len(sel.BlockID) <= maxSelectionBlockIDLen
+}
+
+// authMiddleware authenticates protected routes. With APP_ENV=dev it is
+// DevAuthMiddleware, which also accepts the X-User-ID shortcut.
+func (h *Handler) authMiddleware() gin.HandlerFunc {
+	if auth.IsDevEnv() {
+		return auth.DevAuthMiddleware(h.db)
+	}
+	return auth.AuthMiddleware(h.db)
 }
 
 // RegisterRoutes registers all API routes
@@ -28,41 +271,63 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	r.GET("/health", h.HealthCheck)
 
 	// Public auth routes (no auth required)
-	r.POST("/api/auth/register", h.Register)
-	r.POST("/api/auth/login", h.Login)
-	r.POST("/api/auth/forgot-password", h.ForgotPassword)
+	authLimit := h.RateLimitMiddleware(authRateLimit())
+	r.POST("/api/auth/register", authLimit, h.Register)
+	r.POST("/api/auth/login", authLimit, h.Login)
+	r.POST("/api/auth/refresh", h.RefreshToken)
+	r.POST("/api/auth/validate", h.ValidateToken)
+	r.POST("/api/auth/forgot-password", authLimit, h.ForgotPassword)
+	r.POST("/api/auth/verify-email", h.VerifyEmail)
 	r.POST("/api/auth/reset-password", h.ResetPassword)
 
 	// Protected auth routes
 	authRoutes := r.Group("/api/auth")
-	authRoutes.Use(auth.AuthMiddleware(h.db))
+	authRoutes.Use(h.authMiddleware())
 	{
 		authRoutes.GET("/me", h.GetCurrentUser)
 		authRoutes.POST("/logout", h.Logout)
 		authRoutes.PUT("/password", h.ChangePassword)
+		authRoutes.POST("/resend-verification", authLimit, h.ResendVerification)
+
+		// API keys
+		authRoutes.POST("/api-keys", h.CreateAPIKey)
+		authRoutes.GET("/api-keys", h.ListAPIKeys)
+		authRoutes.DELETE("/api-keys/:id", h.DeleteAPIKey)
 	}
 
 	// Document routes
 	docs := r.Group("/api/docs")
-	docs.Use(auth.AuthMiddleware(h.db))
+	docs.Use(h.authMiddleware())
 	{
 		docs.GET("", h.ListDocuments)
-		docs.POST("", h.CreateDocument)
+		docs.POST("", h.idempotent(), h.CreateDocument)
+		docs.POST("/snapshot-versions", h.GetSnapshotVersions)
+		docs.GET("/trash", h.ListTrash)
+		docs.GET("/starred", h.ListStarredDocuments)
+		docs.GET("/search", h.SearchDocuments) // Query params: q, limit
 		docs.GET("/:id", auth.RequirePermission(h.db, models.RoleView), h.GetDocument)
+		docs.HEAD("/:id", h.CheckDocumentAccess) // Existence/access check without content
 		docs.PUT("/:id", auth.RequirePermission(h.db, models.RoleEdit), h.UpdateDocument)
-		docs.DELETE("/:id", auth.RequirePermission(h.db, models.RoleOwner), h.DeleteDocument)
+		docs.DELETE("/:id", auth.RequirePermission(h.db, models.RoleOwner), h.DeleteDocument) // Moves to trash
+		docs.POST("/:id/duplicate", auth.RequirePermission(h.db, models.RoleView), h.DuplicateDocument)
+		docs.POST("/:id/restore", auth.RequireTrashedPermission(h.db, models.RoleOwner), h.RestoreDocument)
 
 		// Permissions
 		docs.GET("/:id/permissions", auth.RequirePermission(h.db, models.RoleOwner), h.ListPermissions)
-		docs.PUT("/:id/permissions", auth.RequirePermission(h.db, models.RoleOwner), h.SetPermission)
+		docs.PUT("/:id/permissions", auth.RequireVerifiedEmail(), auth.RequirePermission(h.db, models.RoleOwner), h.SetPermission)
+		docs.PUT("/:id/permissions/bulk", auth.RequireVerifiedEmail(), auth.RequirePermission(h.db, models.RoleOwner), h.SetPermissionsBulk)
 		docs.DELETE("/:id/permissions/:userId", auth.RequirePermission(h.db, models.RoleOwner), h.RemovePermission)
+		docs.POST("/:id/transfer", auth.RequireVerifiedEmail(), auth.RequirePermission(h.db, models.RoleOwner), h.TransferOwnership)
 
 		// Comments
 		docs.GET("/:id/comments", auth.RequirePermission(h.db, models.RoleView), h.ListComments)
-		docs.POST("/:id/comments", auth.RequirePermission(h.db, models.RoleComment), h.CreateComment)
+		docs.POST("/:id/comments", limitBody(commentBodyLimit()), auth.RequirePermission(h.db, models.RoleComment), h.idempotent(), h.CreateComment)
 
 		// Snapshots
 		docs.GET("/:id/snapshots", auth.RequirePermission(h.db, models.RoleView), h.ListSnapshots)
+		docs.GET("/:id/snapshots/:version", auth.RequirePermission(h.db, models.RoleView), h.GetSnapshot)
+		docs.POST("/:id/snapshots/:version/restore", auth.RequirePermission(h.db, models.RoleEdit), h.RestoreSnapshot)
+		docs.GET("/:id/stats", auth.RequirePermission(h.db, models.RoleView), h.GetDocumentStats)
 
 		// My permission (accessible to anyone with view access)
 		docs.GET("/:id/my-permission", auth.RequirePermission(h.db, models.RoleView), h.GetMyPermission)
@@ -71,15 +336,31 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 		docs.POST("/:id/access-request", h.RequestAccess) // No permission required - user is requesting access
 		docs.GET("/:id/access-requests", auth.RequirePermission(h.db, models.RoleOwner), h.ListAccessRequests)
 
+		// Share links
+		docs.POST("/:id/share-links", auth.RequireVerifiedEmail(), auth.RequirePermission(h.db, models.RoleOwner), h.CreateShareLink)
+		docs.GET("/:id/share-links", auth.RequirePermission(h.db, models.RoleOwner), h.ListShareLinks)
+		docs.DELETE("/:id/share-links/:token", auth.RequirePermission(h.db, models.RoleOwner), h.RevokeShareLink)
+
+		// Favorites
+		docs.POST("/:id/star", auth.RequirePermission(h.db, models.RoleView), h.StarDocument)
+		docs.DELETE("/:id/star", auth.RequirePermission(h.db, models.RoleView), h.UnstarDocument)
+
+		// Tags
+		docs.POST("/:id/tags", auth.RequirePermission(h.db, models.RoleEdit), h.AddTag)
+		docs.DELETE("/:id/tags/:tag", auth.RequirePermission(h.db, models.RoleEdit), h.RemoveTag)
+
 		// Move document
 		docs.PUT("/:id/move", auth.RequirePermission(h.db, models.RoleOwner), h.MoveDocument)
 	}
 
 	// Comment routes (for update/delete)
 	comments := r.Group("/api/comments")
-	comments.Use(auth.AuthMiddleware(h.db))
+	comments.Use(h.authMiddleware())
 	{
-		comments.PUT("/:id", h.UpdateComment)
+		comments.GET("/mentions", h.ListMentions)          // Query params: limit, offset
+		comments.GET("/:id/replies", h.ListCommentReplies) // Query params: limit, offset
+		comments.PUT("/:id", limitBody(commentBodyLimit()), h.UpdateComment)
+		comments.POST("/:id/resolve", limitBody(commentBodyLimit()), h.ResolveComment)
 		comments.DELETE("/:id", h.DeleteComment)
 	}
 
@@ -87,30 +368,66 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	// These are called by y-websocket server, no auth required for internal use
 	yjs := r.Group("/api/yjs")
 	{
+		yjs.HEAD("/:docId", h.CheckYjsDocument)
 		yjs.GET("/:docId/snapshot", h.GetYjsSnapshot)
-		yjs.POST("/:docId/snapshot", h.SaveYjsSnapshot)
+		yjs.POST("/:docId/snapshot", requireContentType(snapshotContentTypes()), h.SaveYjsSnapshot)
 	}
 
 	// Access request routes (for update)
 	accessReqs := r.Group("/api/access-requests")
-	accessReqs.Use(auth.AuthMiddleware(h.db))
+	accessReqs.Use(h.authMiddleware())
 	{
 		accessReqs.GET("/pending", h.ListMyPendingAccessRequests)
+		accessReqs.GET("/mine", h.ListMyAccessRequests) // Query params: limit, offset
 		accessReqs.PUT("/:id", h.UpdateAccessRequest)
+		accessReqs.DELETE("/:id", h.CancelAccessRequest)
+	}
+
+	// Share link redemption (any signed-in user who has the link)
+	share := r.Group("/api/share")
+	share.Use(h.authMiddleware())
+	{
+		share.POST("/:token/redeem", h.RedeemShareLink)
 	}
 
 	// Folder routes
 	folders := r.Group("/api/folders")
-	folders.Use(auth.AuthMiddleware(h.db))
+	folders.Use(h.authMiddleware())
 	{
-		folders.POST("", h.CreateFolder)
-		folders.GET("", h.GetFolderContents)  // Query param: folder_id (optional)
-		folders.GET("/tree", h.GetFolderTree) // Get complete folder tree
+		folders.POST("", h.idempotent(), h.CreateFolder)
+		folders.GET("", h.GetFolderContents)                 // Query param: folder_id (optional)
+		folders.GET("/tree", h.GetFolderTree)                // Get complete folder tree
+		folders.POST("/rebuild-paths", h.RebuildFolderPaths) // Maintenance: repair materialized paths
 		folders.GET("/:id", h.GetFolderByID)
 		folders.GET("/:id/path", h.GetFolderPath) // Get full parent chain
 		folders.PUT("/:id", h.UpdateFolder)
 		folders.DELETE("/:id", h.DeleteFolder)
 		folders.PUT("/:id/move", h.MoveFolder)
+		folders.POST("/:id/transfer", auth.RequireVerifiedEmail(), h.TransferFolder)
+		folders.POST("/:id/duplicate", h.DuplicateFolder)
+	}
+
+	// User routes
+	users := r.Group("/api/users")
+	users.Use(h.authMiddleware())
+	{
+		users.POST("/lookup", h.LookupUsers)
+	}
+
+	// Notification routes
+	notifications := r.Group("/api/notifications")
+	notifications.Use(h.authMiddleware())
+	{
+		notifications.GET("", h.ListNotifications) // Query params: unread, limit
+		notifications.POST("/:id/read", h.MarkNotificationRead)
+		notifications.POST("/read-all", h.MarkAllNotificationsRead)
+	}
+
+	// Activity routes
+	activity := r.Group("/api/activity")
+	activity.Use(h.authMiddleware())
+	{
+		activity.GET("/feed", h.GetActivityFeed) // Query params: limit, offset
 	}
 }
 
@@ -159,6 +476,12 @@ func (h *Handler) Register(c *gin.Context) {
 	}
 	logger.Info("[API] Register: user created, id=%s", user.ID)
 
+	if h.redis != nil {
+		if err := h.sendVerificationEmail(c.Request.Context(), user); err != nil {
+			logger.Error("[API] Register: failed to send verification email (non-fatal): %v", err)
+		}
+	}
+
 	// Create welcome document for the new user
 	welcomeTitle := "👋 Welcome to CollabDocs, " + user.Name + "!"
 	_, err = h.db.CreateDocumentWithInitialContent(c.Request.Context(), welcomeTitle, user.ID)
@@ -176,8 +499,9 @@ func (h *Handler) Register(c *gin.Context) {
 
 	logger.Info("[API] Register: success for email=%s, userID=%s", req.Email, user.ID)
 	c.JSON(http.StatusCreated, models.LoginResponse{
-		Token: token,
-		User:  user,
+		Token:        token,
+		RefreshToken: h.newRefreshToken(c, user),
+		User:         user,
 	})
 }
 
@@ -217,17 +541,171 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	if err := h.db.UpdateLastLogin(c.Request.Context(), user.ID); err != nil {
+		logger.Error("[API] Login: failed to record last login (non-fatal): %v", err)
+	}
+
 	logger.Info("[API] Login: success for email=%s", req.Email)
 	c.JSON(http.StatusOK, models.LoginResponse{
-		Token: token,
-		User:  user,
+		Token:        token,
+		RefreshToken: h.newRefreshToken(c, user),
+		User:         user,
+	})
+}
+
+// newRefreshToken starts a refresh token chain for a login. Refresh tokens are
+// optional: without Redis (or on error) the client only gets an access token.
+func (h *Handler) newRefreshToken(c *gin.Context, user *models.User) string {
+	if h.redis == nil {
+		return ""
+	}
+	refreshToken, err := auth.GenerateRefreshToken(c.Request.Context(), user)
+	if err != nil {
+		logger.Error("[API] failed to generate refresh token (non-fatal): %v", err)
+		return ""
+	}
+	return refreshToken
+}
+
+// RefreshToken exchanges a refresh token for a new access token and rotates the refresh token
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, refreshToken, err := auth.RotateRefreshToken(c.Request.Context(), req.RefreshToken)
+	switch {
+	case errors.Is(err, auth.ErrTokenStoreUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Token refresh is not available"})
+		return
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		logger.Info("[API] RefreshToken: reuse detected, revoked chain for userID=%s", claims.UserID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	case err != nil:
+		logger.Error("RefreshToken: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	user, err := h.db.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if err := h.db.UpdateLastActive(c.Request.Context(), userID); err != nil {
+		logger.Warn("RefreshToken: failed to record activity for userID=%s: %v", userID, err)
+	}
+
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		logger.Error("[API] RefreshToken: failed to generate token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         user,
 	})
 }
 
+// ValidateToken checks a JWT, including the revocation list, and returns its
+// claims. The refresh chain ID is left out since it is only meaningful to the server.
+func (h *Handler) ValidateToken(c *gin.Context) {
+	var req models.ValidateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.ValidateToken(strings.TrimPrefix(req.Token, "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": "Invalid token: " + err.Error()})
+		return
+	}
+	switch err := auth.CheckRevocation(c.Request.Context(), claims); err {
+	case auth.ErrRevocationCheckFailed:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+		return
+	case auth.ErrTokenRevoked:
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": "Token has been revoked"})
+		return
+	}
+
+	tokenType := claims.TokenType
+	if tokenType == "" {
+		tokenType = auth.TokenTypeAccess
+	}
+	resp := gin.H{
+		"valid": true,
+		"claims": gin.H{
+			"sub":   claims.UserID,
+			"email": claims.Email,
+			"name":  claims.Name,
+			"typ":   tokenType,
+			"iss":   claims.Issuer,
+			"jti":   claims.ID,
+		},
+	}
+	if claims.IssuedAt != nil {
+		resp["issued_at"] = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		resp["expires_at"] = claims.ExpiresAt.Time
+		resp["expires_in"] = int(time.Until(claims.ExpiresAt.Time).Seconds())
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 // Logout handles user logout
 func (h *Handler) Logout(c *gin.Context) {
-	// JWT tokens are stateless, so we just return success
-	// In a production system, you might want to add the token to a blacklist in Redis
+	// The body is optional; clients using refresh tokens send theirs to end its chain
+	var req models.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RefreshToken != "" {
+		user := auth.GetUserFromContext(c)
+		err := auth.RevokeRefreshToken(c.Request.Context(), req.RefreshToken, user.ID.String())
+		switch {
+		case errors.Is(err, auth.ErrTokenStoreUnavailable), errors.Is(err, auth.ErrInvalidRefreshToken):
+			// An unusable token has no chain left to end, and without Redis none was issued
+		case err != nil:
+			logger.Error("Logout: failed to revoke refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	// Blacklist the access token until it expires; API key requests carry no claims
+	if claims := auth.GetClaimsFromContext(c); claims != nil {
+		err := auth.RevokeToken(c.Request.Context(), claims)
+		if errors.Is(err, auth.ErrTokenStoreUnavailable) {
+			logger.Info("[API] Logout: Redis unavailable, token stays valid until expiry for userID=%s", claims.UserID)
+		} else if err != nil {
+			logger.Error("Logout: failed to revoke token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
@@ -263,6 +741,9 @@ func (h *Handler) ChangePassword(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
+	if err := auth.RevokeAllRefreshTokens(c.Request.Context(), user.ID.String()); err != nil {
+		logger.Error("ChangePassword: failed to revoke refresh tokens for userID=%s: %v", user.ID, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
@@ -288,6 +769,12 @@ func (h *Handler) ForgotPassword(c *gin.Context) {
 		return
 	}
 
+	if h.redis == nil {
+		logger.Error("ForgotPassword: Redis is not available, cannot store reset token")
+		c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link will be sent"})
+		return
+	}
+
 	// Generate reset token
 	resetToken, err := auth.GenerateResetToken()
 	if err != nil {
@@ -295,14 +782,27 @@ func (h *Handler) ForgotPassword(c *gin.Context) {
 		return
 	}
 
-	// TODO: Store reset token in Redis with expiration
-	// TODO: Send email with reset link
-	// For now, just log it (development only)
-	_ = resetToken // In production, send this via email
+	// Store the token; the TTL makes it expire on its own
+	if err := h.redis.SetWithTTL(c.Request.Context(), resetTokenKey(resetToken), []byte(user.Email), auth.ResetTokenTTL()); err != nil {
+		logger.Error("ForgotPassword: failed to store reset token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reset token"})
+		return
+	}
+
+	body := "Reset your password with this code: " + resetToken
+	if link := appLink("/reset-password?token=" + resetToken); link != "" {
+		body = "Reset your password by opening " + link
+	}
+	h.sendEmail(user.Email, "Reset your CollabDocs password", body+"\n\nIf you did not ask for this, ignore this email.")
 
 	c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link will be sent"})
 }
 
+// resetTokenKey is the Redis key holding the email a reset token was issued for
+func resetTokenKey(token string) string {
+	return "reset:" + token
+}
+
 // ResetPassword handles password reset with token
 func (h *Handler) ResetPassword(c *gin.Context) {
 	var req models.ResetPasswordRequest
@@ -311,106 +811,369 @@ func (h *Handler) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	// TODO: Validate reset token from Redis and get associated user email
-	// For now, this is a placeholder that returns an error
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Password reset via email is not configured. Please contact an administrator."})
-}
+	if !auth.ValidResetTokenFormat(req.Token) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
 
-// GetCurrentUser returns the current authenticated user
-func (h *Handler) GetCurrentUser(c *gin.Context) {
-	user := auth.GetUserFromContext(c)
-	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+	if h.redis == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Password reset is not available. Please contact an administrator."})
 		return
 	}
-	c.JSON(http.StatusOK, user)
-}
 
-// ListDocuments returns all documents accessible by the user
-func (h *Handler) ListDocuments(c *gin.Context) {
-	user := auth.GetUserFromContext(c)
-	logger.Info("[API] ListDocuments: userID=%s", user.ID)
-	docs, err := h.db.ListDocuments(c.Request.Context(), user.ID)
+	// Consume the token: GetDel makes it single-use even under concurrent requests
+	email, err := h.redis.GetDel(c.Request.Context(), resetTokenKey(req.Token))
 	if err != nil {
-		logger.Error("ListDocuments: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
+		logger.Error("ResetPassword: failed to read reset token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
 		return
 	}
-	if docs == nil {
-		docs = []*models.Document{}
-	}
-	logger.Info("[API] ListDocuments: found %d documents", len(docs))
-	c.JSON(http.StatusOK, docs)
-}
-
-// CreateDocument creates a new document
-func (h *Handler) CreateDocument(c *gin.Context) {
-	user := auth.GetUserFromContext(c)
-
-	var req models.CreateDocumentRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if email == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
 		return
 	}
 
-	doc, err := h.db.CreateDocument(c.Request.Context(), req.Title, user.ID)
+	user, err := h.db.GetUserByEmail(c.Request.Context(), string(email))
 	if err != nil {
-		// Log the actual error for debugging
-		logger.Error("CreateDocument: user=%s, title=%s, error=%v", user.ID, req.Title, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create document"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, doc)
-}
-
-// GetDocument returns a single document
-func (h *Handler) GetDocument(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
-
-	logger.Info("[API] GetDocument: docID=%s", docID)
-	doc, err := h.db.GetDocument(c.Request.Context(), docID)
+	passwordHash, err := auth.HashPassword(req.NewPassword)
 	if err != nil {
-		logger.Error("GetDocument: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
-	if doc == nil {
-		logger.Info("[API] GetDocument: not found docID=%s", docID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	if err := h.db.UpdateUserPassword(c.Request.Context(), user.ID, passwordHash); err != nil {
+		logger.Error("ResetPassword: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
+	if err := auth.RevokeAllRefreshTokens(c.Request.Context(), user.ID.String()); err != nil {
+		logger.Error("ResetPassword: failed to revoke refresh tokens for userID=%s: %v", user.ID, err)
+	}
 
-	logger.Info("[API] GetDocument: success docID=%s", docID)
-	c.JSON(http.StatusOK, doc)
+	logger.Info("[API] ResetPassword: password reset for userID=%s", user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
 }
 
-// UpdateDocument updates a document
-func (h *Handler) UpdateDocument(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+// GetCurrentUser returns the current authenticated user
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
 
-	var req models.UpdateDocumentRequest
+// CreateAPIKey creates a new API key for the current user.
+// The full key is only returned in this response.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	var req models.CreateAPIKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	logger.Info("[API] UpdateDocument: docID=%s, title=%s", docID, req.Title)
-	doc, err := h.db.UpdateDocument(c.Request.Context(), docID, req.Title)
+	key, prefix, hash, err := auth.GenerateAPIKey()
 	if err != nil {
-		logger.Error("UpdateDocument: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
 		return
 	}
-	if doc == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+
+	apiKey, err := h.db.CreateAPIKey(c.Request.Context(), user.ID, req.Name, prefix, hash)
+	if err != nil {
+		logger.Error("CreateAPIKey: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
 		return
 	}
 
-	logger.Info("[API] UpdateDocument: success docID=%s", docID)
-	c.JSON(http.StatusOK, doc)
+	logger.Info("[API] CreateAPIKey: userID=%s, keyID=%s", user.ID, apiKey.ID)
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{
+		Key:    key,
+		APIKey: apiKey,
+	})
+}
+
+// ListAPIKeys returns the current user's API keys (prefix and metadata only)
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	keys, err := h.db.ListAPIKeys(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// DeleteAPIKey revokes one of the current user's API keys
+func (h *Handler) DeleteAPIKey(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	deleted, err := h.db.DeleteAPIKey(c.Request.Context(), keyID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	logger.Info("[API] DeleteAPIKey: userID=%s, keyID=%s", user.ID, keyID)
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// ListDocuments returns all documents accessible by the user
+func (h *Handler) ListDocuments(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	logger.Info("[API] ListDocuments: userID=%s", user.ID)
+	limit, offset, ok := parsePaginationMax(c, maxDocumentsLimit)
+	if !ok {
+		return
+	}
+
+	// ?role= keeps documents where the caller has exactly that role, ?min_role= that role or higher
+	var role db.RoleFilter
+	exact, minimum := c.Query("role"), c.Query("min_role")
+	switch {
+	case exact != "" && minimum != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either role or min_role, not both"})
+		return
+	case exact != "":
+		role = db.RoleFilter{Role: exact}
+	case minimum != "":
+		role = db.RoleFilter{Role: minimum, AtLeast: true}
+	}
+	if role.Role != "" && !validRole(role.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of owner, edit, comment, view"})
+		return
+	}
+
+	var docs []*models.Document
+	var total int
+	var err error
+	if tagParam, filtered := c.GetQuery("tag"); filtered {
+		tag, ok := normalizeTag(tagParam)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag"})
+			return
+		}
+		docs, total, err = h.db.ListDocumentsByTag(c.Request.Context(), user.ID, tag, role, limit, offset)
+	} else {
+		docs, total, err = h.db.ListDocuments(c.Request.Context(), user.ID, role, limit, offset)
+	}
+	if err != nil {
+		logger.Error("ListDocuments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
+		return
+	}
+	if docs == nil {
+		docs = []*models.Document{}
+	}
+	logger.Info("[API] ListDocuments: found %d of %d documents", len(docs), total)
+	c.JSON(http.StatusOK, models.DocumentPage{
+		Items:   docs,
+		Total:   total,
+		HasMore: offset+len(docs) < total,
+	})
+}
+
+// SearchDocuments searches the titles of documents the user can access
+func (h *Handler) SearchDocuments(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	limit, _, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	docs, err := h.db.SearchDocuments(c.Request.Context(), user.ID, query, limit)
+	if err != nil {
+		logger.Error("SearchDocuments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
+		return
+	}
+	if docs == nil {
+		docs = []*models.Document{}
+	}
+	c.JSON(http.StatusOK, docs)
+}
+
+// CreateDocument creates a new document
+func (h *Handler) CreateDocument(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	var req models.CreateDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title = sanitizeName(req.Title); req.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+		return
+	}
+
+	doc, err := h.db.CreateDocument(c.Request.Context(), req.Title, user.ID)
+	if err != nil {
+		// Log the actual error for debugging
+		logger.Error("CreateDocument: user=%s, title=%s, error=%v", user.ID, req.Title, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create document"})
+		return
+	}
+
+	h.webhooks.Send(webhook.EventDocumentCreated, gin.H{"doc_id": doc.ID, "title": doc.Title, "owner_id": doc.OwnerID})
+	c.JSON(http.StatusCreated, doc)
+}
+
+// DocumentRoleHeader carries the caller's role in HEAD /api/docs/:id responses
+const DocumentRoleHeader = "X-Document-Role"
+
+// CheckDocumentAccess answers HEAD requests with just a status code:
+// 200 (role in X-Document-Role), 403 without access, 404 if the document doesn't exist
+func (h *Handler) CheckDocumentAccess(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	exists, role, err := h.db.GetDocumentAccess(c.Request.Context(), docID, user.ID)
+	if err != nil {
+		logger.Error("CheckDocumentAccess: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if role == "" {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	c.Header(DocumentRoleHeader, role)
+	c.Status(http.StatusOK)
+}
+
+// GetDocument returns a single document
+func (h *Handler) GetDocument(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	logger.Info("[API] GetDocument: docID=%s", docID)
+	doc, err := h.db.GetDocument(c.Request.Context(), docID)
+	if err != nil {
+		logger.Error("GetDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+	if doc == nil {
+		logger.Info("[API] GetDocument: not found docID=%s", docID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if perm := auth.GetPermissionFromContext(c); perm != nil {
+		doc.Permission = perm.Role
+	}
+
+	logger.Info("[API] GetDocument: success docID=%s", docID)
+	c.JSON(http.StatusOK, doc)
+}
+
+// DuplicateDocument copies a document's content into a new document owned by the current user
+func (h *Handler) DuplicateDocument(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	var req models.DuplicateDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		// An empty body uses the default title
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src, err := h.db.GetDocument(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if src == nil || src.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	title := sanitizeName(req.Title)
+	if title == "" {
+		title = "Copy of " + src.Title
+	}
+
+	doc, err := h.db.DuplicateDocument(c.Request.Context(), docID, user.ID, title)
+	if err != nil {
+		logger.Error("DuplicateDocument: src=%s, user=%s, error=%v", docID, user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to duplicate document"})
+		return
+	}
+
+	logger.Info("[API] DuplicateDocument: src=%s, new=%s", docID, doc.ID)
+	h.webhooks.Send(webhook.EventDocumentCreated, gin.H{"doc_id": doc.ID, "title": doc.Title, "owner_id": doc.OwnerID})
+	c.JSON(http.StatusCreated, doc)
+}
+
+// UpdateDocument updates a document
+func (h *Handler) UpdateDocument(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	var req models.UpdateDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title = sanitizeName(req.Title); req.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+		return
+	}
+
+	logger.Info("[API] UpdateDocument: docID=%s, title=%s", docID, req.Title)
+	doc, err := h.db.UpdateDocument(c.Request.Context(), docID, req.Title)
+	if err != nil {
+		logger.Error("UpdateDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+	if doc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if perm := auth.GetPermissionFromContext(c); perm != nil {
+		doc.Permission = perm.Role
+	}
+
+	logger.Info("[API] UpdateDocument: success docID=%s", docID)
+	c.JSON(http.StatusOK, doc)
 }
 
 // DeleteDocument deletes a document
@@ -418,297 +1181,1127 @@ func (h *Handler) DeleteDocument(c *gin.Context) {
 	docIDStr := c.Param("id")
 	docID, _ := uuid.Parse(docIDStr)
 
-	logger.Info("[API] DeleteDocument: docID=%s", docID)
-	if err := h.db.DeleteDocument(c.Request.Context(), docID); err != nil {
-		logger.Error("DeleteDocument: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+	logger.Info("[API] DeleteDocument: docID=%s", docID)
+	if err := h.db.SoftDeleteDocument(c.Request.Context(), docID); err != nil {
+		logger.Error("DeleteDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	if err := h.publishRoomEviction(c.Request.Context(), docID); err != nil {
+		// Clients still get rejected on their next connect
+		logger.Warn("DeleteDocument: failed to publish room eviction for %s: %v", docID, err)
+	}
+
+	logger.Info("[API] DeleteDocument: moved to trash docID=%s", docID)
+	c.JSON(http.StatusOK, gin.H{"message": "Document moved to trash"})
+}
+
+// RestoreDocument takes a document out of the trash (owner only)
+func (h *Handler) RestoreDocument(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	restored, err := h.db.RestoreDocument(c.Request.Context(), docID)
+	if err != nil {
+		logger.Error("RestoreDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore document"})
+		return
+	}
+	if !restored {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Document is not in the trash"})
+		return
+	}
+
+	logger.Info("[API] RestoreDocument: success docID=%s", docID)
+	c.JSON(http.StatusOK, gin.H{"message": "Document restored"})
+}
+
+// ListTrash returns the current user's trashed documents that can still be restored
+func (h *Handler) ListTrash(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docs, err := h.db.ListTrashedDocuments(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error("ListTrash: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+	if docs == nil {
+		docs = []*models.Document{}
+	}
+	c.JSON(http.StatusOK, docs)
+}
+
+// StarDocument adds a document to the current user's favorites
+func (h *Handler) StarDocument(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	if err := h.db.StarDocument(c.Request.Context(), user.ID, docID); err != nil {
+		logger.Error("StarDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to star document"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"starred": true})
+}
+
+// UnstarDocument removes a document from the current user's favorites
+func (h *Handler) UnstarDocument(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	if err := h.db.UnstarDocument(c.Request.Context(), user.ID, docID); err != nil {
+		logger.Error("UnstarDocument: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unstar document"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"starred": false})
+}
+
+// ListStarredDocuments returns the current user's starred documents
+func (h *Handler) ListStarredDocuments(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docs, err := h.db.ListStarredDocuments(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error("ListStarredDocuments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list starred documents"})
+		return
+	}
+	if docs == nil {
+		docs = []*models.Document{}
+	}
+	c.JSON(http.StatusOK, docs)
+}
+
+// AddTag tags a document and returns its tags
+func (h *Handler) AddTag(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	var req models.AddTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tag, ok := normalizeTag(req.Tag)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tag must be 1-%d characters", maxTagLength)})
+		return
+	}
+
+	if err := h.db.AddTag(c.Request.Context(), docID, tag); err != nil {
+		logger.Error("AddTag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add tag"})
+		return
+	}
+	h.respondTags(c, docID)
+}
+
+// RemoveTag removes a tag from a document and returns the remaining tags
+func (h *Handler) RemoveTag(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	tag, ok := normalizeTag(c.Param("tag"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag"})
+		return
+	}
+
+	removed, err := h.db.RemoveTag(c.Request.Context(), docID, tag)
+	if err != nil {
+		logger.Error("RemoveTag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove tag"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+	h.respondTags(c, docID)
+}
+
+func (h *Handler) respondTags(c *gin.Context, docID uuid.UUID) {
+	tags, err := h.db.ListTags(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tags"})
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// ListPermissions returns all permissions for a document
+func (h *Handler) ListPermissions(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	perms, err := h.db.ListPermissions(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list permissions"})
+		return
+	}
+	if perms == nil {
+		perms = []*models.DocumentPermission{}
+	}
+	c.JSON(http.StatusOK, perms)
+}
+
+// SetPermission sets a user's permission for a document
+func (h *Handler) SetPermission(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	var req models.SetPermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.db.SetPermission(c.Request.Context(), docID, userID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set permission"})
+		return
+	}
+	h.webhooks.Send(webhook.EventAccessGranted, gin.H{"doc_id": docID, "user_id": userID, "role": req.Role})
+	h.notifyAccessGranted(c.Request.Context(), docID, auth.GetUserFromContext(c), map[uuid.UUID]string{userID: req.Role})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission set"})
+}
+
+// maxBulkPermissions caps the entries of one bulk permission update
+const maxBulkPermissions = 100
+
+// SetPermissionsBulk sets several users' permissions at once. Every entry is
+// validated before any is applied, and the resulting permission list is returned.
+func (h *Handler) SetPermissionsBulk(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	var req []models.BulkPermissionEntry
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req) == 0 || len(req) > maxBulkPermissions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Between 1 and %d permissions are required", maxBulkPermissions)})
+		return
+	}
+
+	grants := make([]db.PermissionGrant, len(req))
+	for i, entry := range req {
+		userID, err := uuid.Parse(entry.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid user ID: %s", entry.UserID)})
+			return
+		}
+		grants[i] = db.PermissionGrant{UserID: userID, Role: entry.Role}
+	}
+
+	err := h.db.SetPermissionsBulk(c.Request.Context(), docID, grants)
+	if errors.Is(err, db.ErrUnknownUser) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("SetPermissionsBulk: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set permissions"})
+		return
+	}
+	granted := make(map[uuid.UUID]string, len(grants))
+	for _, g := range grants {
+		h.webhooks.Send(webhook.EventAccessGranted, gin.H{"doc_id": docID, "user_id": g.UserID, "role": g.Role})
+		granted[g.UserID] = g.Role
+	}
+	h.notifyAccessGranted(c.Request.Context(), docID, auth.GetUserFromContext(c), granted)
+
+	perms, err := h.db.ListPermissions(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list permissions"})
+		return
+	}
+	if perms == nil {
+		perms = []*models.DocumentPermission{}
+	}
+	c.JSON(http.StatusOK, perms)
+}
+
+// RemovePermission removes a user's permission for a document
+func (h *Handler) RemovePermission(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.db.RemovePermission(c.Request.Context(), docID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove permission"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission removed"})
+}
+
+// TransferOwnership hands a document to another user; the current owner keeps edit access
+func (h *Handler) TransferOwnership(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newOwnerID, err := uuid.Parse(req.NewOwnerID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	newOwner, err := h.db.GetUser(c.Request.Context(), newOwnerID)
+	if err != nil {
+		logger.Error("TransferOwnership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+	if newOwner == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	err = h.db.TransferOwnership(c.Request.Context(), docID, newOwnerID)
+	if errors.Is(err, db.ErrAlreadyOwner) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User already owns this document"})
+		return
+	}
+	if err != nil {
+		logger.Error("TransferOwnership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	logger.Info("[API] TransferOwnership: docID=%s newOwner=%s", docID, newOwnerID)
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred"})
+}
+
+// ListComments returns a page of a document's top-level comments, open ones
+// only unless ?resolved=all|resolved, with their replies nested unless
+// ?replies=none. The open and resolved totals are sent in the X-Open-Count and
+// X-Resolved-Count headers for badges.
+func (h *Handler) ListComments(c *gin.Context) {
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+	opts := db.CommentListOptions{State: db.CommentsOpen, Limit: limit, Offset: offset}
+	switch state := c.Query("resolved"); state {
+	case "":
+	case db.CommentsAll, db.CommentsOpen, db.CommentsResolved:
+		opts.State = state
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resolved must be one of all, open, resolved"})
+		return
+	}
+
+	var comments []*models.Comment
+	var err error
+	switch c.Query("replies") {
+	case "", "tree":
+		// Nested replies up to the configured depth, in one recursive query
+		comments, err = h.db.ListCommentTree(c.Request.Context(), docID, opts, maxReplyDepth())
+	case "none":
+		// Thread summaries only (reply_count and latest_reply)
+		comments, err = h.db.ListComments(c.Request.Context(), docID, opts)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "replies must be 'tree' or 'none'"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list comments"})
+		return
+	}
+
+	open, resolved, err := h.db.CountCommentsByState(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list comments"})
+		return
+	}
+	c.Header("X-Open-Count", strconv.Itoa(open))
+	c.Header("X-Resolved-Count", strconv.Itoa(resolved))
+
+	if comments == nil {
+		comments = []*models.Comment{}
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// CreateComment creates a new comment
+func (h *Handler) CreateComment(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	var req models.CreateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Content = sanitizeText(req.Content); req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment content is required"})
+		return
+	}
+	if !validSelection(req.Selection) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid selection"})
+		return
+	}
+
+	if limit := maxCommentsPerDoc(); limit > 0 {
+		count, err := h.db.CountOpenComments(c.Request.Context(), docID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count >= limit {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "This document has reached the maximum number of open comments"})
+			return
+		}
+	}
+
+	if req.Selection != nil && req.Selection.BlockID != "" && verifyCommentBlocks() {
+		exists, err := h.selectionBlockExists(c.Request.Context(), docID, req.Selection.BlockID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Selection block not found"})
+			return
+		}
+	}
+
+	var parentID *uuid.UUID
+	if req.ParentID != nil {
+		id, err := uuid.Parse(*req.ParentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent ID"})
+			return
+		}
+
+		// Replies must target a comment on the same document, nested at most maxReplyDepth levels
+		parent, err := h.db.GetComment(c.Request.Context(), id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if parent == nil || parent.DocID != docID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent comment not found"})
+			return
+		}
+		if parent.ParentID != nil {
+			maxDepth := maxReplyDepth()
+			if maxDepth == 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot reply to a reply"})
+				return
+			}
+			depth, err := h.db.GetCommentDepth(c.Request.Context(), id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if depth >= maxDepth {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Reply is nested too deeply"})
+				return
+			}
+		}
+		parentID = &id
+	}
+
+	logger.Info("[API] CreateComment: docID=%s, userID=%s, content=%q", docID, user.ID, req.Content)
+	mentions, ok := h.resolveMentions(c, docID, req.Mentions, req.Content)
+	if !ok {
+		return
+	}
+
+	comment, err := h.db.CreateComment(c.Request.Context(), docID, user.ID, req.Content, req.Selection, parentID, mentions)
+	if err != nil {
+		logger.Error("CreateComment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		return
+	}
+
+	for _, mentioned := range comment.Mentions {
+		if mentioned.ID == user.ID {
+			continue
+		}
+		h.notify(c.Request.Context(), mentioned.ID, models.NotificationMention, gin.H{
+			"comment_id": comment.ID,
+			"doc_id":     docID,
+			"author":     gin.H{"id": user.ID, "name": user.Name, "email": user.Email},
+			"excerpt":    truncateRunes(comment.Content, mentionExcerptLen),
+		})
+	}
+
+	logger.Info("[API] CreateComment: success, commentID=%s", comment.ID)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// UpdateComment updates a comment
+func (h *Handler) UpdateComment(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	commentIDStr := c.Param("id")
+	commentID, err := uuid.Parse(commentIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	// Check ownership
+	existing, err := h.db.GetComment(c.Request.Context(), commentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	if existing.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot edit other's comment"})
+		return
+	}
+
+	var req models.UpdateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Content != nil {
+		content := sanitizeText(*req.Content)
+		if content == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Comment content is required"})
+			return
+		}
+		req.Content = &content
+	}
+	// Authorship is not enough to resolve or reopen: access may have been lost since
+	if req.Resolved != nil && !h.canResolve(c, existing.DocID, user.ID) {
+		return
+	}
+
+	comment, err := h.db.UpdateComment(c.Request.Context(), commentID, user.ID, req.Content, req.Resolved)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// DeleteComment deletes a comment
+func (h *Handler) DeleteComment(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	commentIDStr := c.Param("id")
+	commentID, err := uuid.Parse(commentIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	// Check ownership
+	existing, err := h.db.GetComment(c.Request.Context(), commentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	if existing.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete other's comment"})
+		return
+	}
+
+	if err := h.db.DeleteComment(c.Request.Context(), commentID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+}
+
+// canResolve checks that a user may resolve or reopen comments on a document:
+// the same access as commenting, on a document that is not in the trash. It
+// responds with the error and returns false otherwise.
+func (h *Handler) canResolve(c *gin.Context, docID, userID uuid.UUID) bool {
+	perm, err := h.db.ResolveEffectivePermission(c.Request.Context(), docID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if perm == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this document"})
+		return false
+	}
+	if perm.Role == models.RoleView {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return false
+	}
+	if perm.Trashed {
+		c.JSON(http.StatusGone, gin.H{"error": "Document is in the trash"})
+		return false
+	}
+	return true
+}
+
+// ResolveComment resolves a thread, optionally leaving a closing note as a reply
+func (h *Handler) ResolveComment(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	commentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	var req models.ResolveCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		// An empty body resolves without a note
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Note = sanitizeText(req.Note)
+
+	comment, err := h.db.GetComment(c.Request.Context(), commentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if comment == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	if comment.ParentID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only top-level comments can be resolved"})
+		return
+	}
+	if comment.Resolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment is already resolved"})
+		return
+	}
+
+	if !h.canResolve(c, comment.DocID, user.ID) {
+		return
+	}
+
+	resolved, note, err := h.db.ResolveComment(c.Request.Context(), commentID, user.ID, req.Note)
+	if errors.Is(err, db.ErrCommentResolved) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment is already resolved"})
+		return
+	}
+	if err != nil {
+		logger.Error("ResolveComment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve comment"})
+		return
+	}
+	if note != nil {
+		note.User = user
+	}
+
+	logger.Info("[API] ResolveComment: commentID=%s, userID=%s, withNote=%t", commentID, user.ID, note != nil)
+	c.JSON(http.StatusOK, gin.H{"comment": resolved, "note": note})
+}
+
+// ListMentions returns the comments that mention the current user
+func (h *Handler) ListMentions(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	comments, err := h.db.ListMentionsForUser(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		logger.Error("ListMentions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list mentions"})
+		return
+	}
+	if comments == nil {
+		comments = []*models.Comment{}
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// ListCommentReplies returns the replies of a comment thread, oldest first, paginated
+func (h *Handler) ListCommentReplies(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	commentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
 		return
 	}
 
-	logger.Info("[API] DeleteDocument: success docID=%s", docID)
-	c.JSON(http.StatusOK, gin.H{"message": "Document deleted"})
-}
+	parent, err := h.db.GetComment(c.Request.Context(), commentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if parent == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
 
-// ListPermissions returns all permissions for a document
-func (h *Handler) ListPermissions(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+	// Same access as listing the document's comments
+	perm, err := h.db.ResolveEffectivePermission(c.Request.Context(), parent.DocID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if perm == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No access to this document"})
+		return
+	}
+	if perm.Trashed {
+		c.JSON(http.StatusGone, gin.H{"error": "Document is in the trash"})
+		return
+	}
 
-	perms, err := h.db.ListPermissions(c.Request.Context(), docID)
+	replies, err := h.db.ListCommentReplies(c.Request.Context(), commentID, limit, offset)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list permissions"})
+		logger.Error("ListCommentReplies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list replies"})
 		return
 	}
-	if perms == nil {
-		perms = []*models.DocumentPermission{}
+	if replies == nil {
+		replies = []*models.Comment{}
 	}
-	c.JSON(http.StatusOK, perms)
+	c.JSON(http.StatusOK, replies)
 }
 
-// SetPermission sets a user's permission for a document
-func (h *Handler) SetPermission(c *gin.Context) {
+// ListSnapshots returns all snapshots for a document
+func (h *Handler) ListSnapshots(c *gin.Context) {
 	docIDStr := c.Param("id")
 	docID, _ := uuid.Parse(docIDStr)
 
-	var req models.SetPermissionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	snapshots, err := h.db.ListSnapshots(c.Request.Context(), docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snapshots"})
 		return
 	}
+	if snapshots == nil {
+		snapshots = []*models.DocSnapshot{}
+	}
+	c.JSON(http.StatusOK, snapshots)
+}
 
-	userID, err := uuid.Parse(req.UserID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+// GetSnapshot returns the raw Yjs state of one snapshot version, base64 encoded
+// like GetYjsSnapshot
+func (h *Handler) GetSnapshot(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid snapshot version"})
 		return
 	}
 
-	if err := h.db.SetPermission(c.Request.Context(), docID, userID, req.Role); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set permission"})
+	snapshot, err := h.db.GetSnapshotByVersion(c.Request.Context(), docID, version)
+	if errors.Is(err, db.ErrSnapshotCorrupt) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Snapshot is corrupted"})
+		return
+	}
+	if err != nil {
+		logger.Error("GetSnapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Permission set"})
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot":   base64.StdEncoding.EncodeToString(snapshot.Snapshot),
+		"version":    snapshot.Version,
+		"created_at": snapshot.CreatedAt,
+	})
 }
 
-// RemovePermission removes a user's permission for a document
-func (h *Handler) RemovePermission(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+// RestoreSnapshot rolls a document back to an earlier snapshot version by saving
+// its content as a new version, then reloads the live collab room if there is one
+func (h *Handler) RestoreSnapshot(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid snapshot version"})
+		return
+	}
 
-	userIDStr := c.Param("userId")
-	userID, err := uuid.Parse(userIDStr)
+	snapshot, err := h.db.RestoreSnapshot(c.Request.Context(), docID, version)
+	if errors.Is(err, db.ErrSnapshotCorrupt) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Snapshot is corrupted"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		logger.Error("RestoreSnapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore snapshot"})
 		return
 	}
-
-	if err := h.db.RemovePermission(c.Request.Context(), docID, userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove permission"})
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
 		return
 	}
+	h.webhooks.Send(webhook.EventSnapshotSaved, gin.H{"doc_id": docID, "version": snapshot.Version})
 
-	c.JSON(http.StatusOK, gin.H{"message": "Permission removed"})
+	// Without the reload, a room that is open would write its pre-restore state
+	// back as the next snapshot once its last client leaves
+	reloaded, err := h.reloadRoom(c.Request.Context(), docID)
+	if err != nil {
+		logger.Error("RestoreSnapshot: failed to reload collab room docID=%s: %v", docID, err)
+	}
+
+	logger.Info("[API] RestoreSnapshot: docID=%s, from version=%d, new version=%d, roomReloaded=%v", docID, version, snapshot.Version, reloaded)
+	c.JSON(http.StatusOK, gin.H{
+		"doc_id":        docID,
+		"version":       snapshot.Version,
+		"restored_from": version,
+		"created_at":    snapshot.CreatedAt,
+		"room_reloaded": reloaded,
+	})
 }
 
-// ListComments returns all comments for a document
-func (h *Handler) ListComments(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+// GetActivityFeed returns recent snapshot saves, comments and permission changes
+// across all documents the user owns or collaborates on, newest first
+func (h *Handler) GetActivityFeed(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
 
-	comments, err := h.db.ListComments(c.Request.Context(), docID)
+	events, err := h.db.ListActivityFeed(c.Request.Context(), user.ID, limit, offset)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list comments"})
+		logger.Error("GetActivityFeed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load activity feed"})
 		return
 	}
-	if comments == nil {
-		comments = []*models.Comment{}
+	if events == nil {
+		events = []*models.ActivityEvent{}
 	}
-	c.JSON(http.StatusOK, comments)
+	c.JSON(http.StatusOK, events)
 }
 
-// CreateComment creates a new comment
-func (h *Handler) CreateComment(c *gin.Context) {
+// GetSnapshotVersions returns the latest snapshot version for each requested document
+// the user can access, keyed by document ID. Inaccessible documents are omitted.
+func (h *Handler) GetSnapshotVersions(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
 
-	var req models.CreateCommentRequest
+	var req models.SnapshotVersionsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var parentID *uuid.UUID
-	if req.ParentID != nil {
-		id, err := uuid.Parse(*req.ParentID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent ID"})
-			return
-		}
-		parentID = &id
-	}
-
-	logger.Info("[API] CreateComment: docID=%s, userID=%s, content=%s", docID, user.ID, req.Content)
-	comment, err := h.db.CreateComment(c.Request.Context(), docID, user.ID, req.Content, req.Selection, parentID)
+	versions, err := h.db.GetLatestSnapshotVersions(c.Request.Context(), user.ID, req.DocIDs)
 	if err != nil {
-		logger.Error("CreateComment: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		logger.Error("GetSnapshotVersions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot versions"})
 		return
 	}
 
-	logger.Info("[API] CreateComment: success, commentID=%s", comment.ID)
-	c.JSON(http.StatusCreated, comment)
+	c.JSON(http.StatusOK, versions)
 }
 
-// UpdateComment updates a comment
-func (h *Handler) UpdateComment(c *gin.Context) {
+// LookupUsers resolves a batch of user IDs to profiles; users the caller shares
+// no document with are left out rather than reported
+func (h *Handler) LookupUsers(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
-	commentIDStr := c.Param("id")
-	commentID, err := uuid.Parse(commentIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+
+	var req models.UserLookupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Check ownership
-	existing, err := h.db.GetComment(c.Request.Context(), commentID)
+	users, err := h.db.LookupVisibleUsers(c.Request.Context(), user.ID, req.UserIDs)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		logger.Error("LookupUsers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up users"})
 		return
 	}
-	if existing == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
-		return
+	if users == nil {
+		users = []*models.User{}
 	}
-	if existing.UserID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot edit other's comment"})
+	c.JSON(http.StatusOK, users)
+}
+
+// GetMyPermission returns the current user's permission for a document
+func (h *Handler) GetMyPermission(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	docIDStr := c.Param("id")
+	docID, _ := uuid.Parse(docIDStr)
+
+	perm, err := h.db.ResolveEffectivePermission(c.Request.Context(), docID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permission"})
 		return
 	}
 
-	var req models.UpdateCommentRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	role := "view"
+	if perm != nil {
+		role = perm.Role
 	}
 
-	comment, err := h.db.UpdateComment(c.Request.Context(), commentID, req.Content, req.Resolved)
+	c.JSON(http.StatusOK, gin.H{"role": role})
+}
+
+const mimeOctetStream = "application/octet-stream"
+
+// SnapshotVersionHeader carries the snapshot version in binary snapshot responses
+const SnapshotVersionHeader = "X-Snapshot-Version"
+
+// CheckYjsDocument tells the collab server whether a document may be opened:
+// 200 if it exists, 404 if unknown and 410 if it is in the trash
+func (h *Handler) CheckYjsDocument(c *gin.Context) {
+	docID, err := uuid.Parse(c.Param("docId"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		c.Status(http.StatusBadRequest)
 		return
 	}
 
-	c.JSON(http.StatusOK, comment)
-}
-
-// DeleteComment deletes a comment
-func (h *Handler) DeleteComment(c *gin.Context) {
-	user := auth.GetUserFromContext(c)
-	commentIDStr := c.Param("id")
-	commentID, err := uuid.Parse(commentIDStr)
+	doc, err := h.db.GetDocument(c.Request.Context(), docID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		logger.Error("CheckYjsDocument: %v", err)
+		c.Status(http.StatusInternalServerError)
 		return
 	}
+	switch {
+	case doc == nil:
+		c.Status(http.StatusNotFound)
+	case doc.DeletedAt != nil:
+		c.Status(http.StatusGone)
+	default:
+		c.Status(http.StatusOK)
+	}
+}
 
-	// Check ownership
-	existing, err := h.db.GetComment(c.Request.Context(), commentID)
+// GetYjsSnapshot returns the latest Yjs snapshot for a document
+func (h *Handler) GetYjsSnapshot(c *gin.Context) {
+	docIDStr := c.Param("docId")
+	docID, err := uuid.Parse(docIDStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
 		return
 	}
-	if existing == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+
+	// Clients sending Accept: application/octet-stream get raw bytes instead of base64 JSON
+	binary := c.NegotiateFormat(gin.MIMEJSON, mimeOctetStream) == mimeOctetStream
+
+	logger.Info("[API] GetYjsSnapshot: docID=%s, binary=%v", docID, binary)
+	snapshot, err := h.db.GetLatestSnapshot(c.Request.Context(), docID)
+	if err != nil {
+		logger.Error("GetYjsSnapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
 		return
 	}
-	if existing.UserID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete other's comment"})
+
+	if snapshot == nil {
+		logger.Info("[API] GetYjsSnapshot: no snapshot found for docID=%s", docID)
+		if binary {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"snapshot": nil})
 		return
 	}
 
-	if err := h.db.DeleteComment(c.Request.Context(), commentID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+	if binary {
+		logger.Info("[API] GetYjsSnapshot: success docID=%s, version=%d, size=%d bytes (binary)", docID, snapshot.Version, len(snapshot.Snapshot))
+		c.Header(SnapshotVersionHeader, strconv.Itoa(snapshot.Version))
+		c.Data(http.StatusOK, mimeOctetStream, snapshot.Snapshot)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+	// Encode snapshot to base64 for transmission
+	snapshotBase64 := base64.StdEncoding.EncodeToString(snapshot.Snapshot)
+	logger.Info("[API] GetYjsSnapshot: success docID=%s, version=%d, size=%d bytes", docID, snapshot.Version, len(snapshot.Snapshot))
+	c.JSON(http.StatusOK, gin.H{
+		"snapshot": snapshotBase64,
+		"version":  snapshot.Version,
+	})
 }
 
-// ListSnapshots returns all snapshots for a document
-func (h *Handler) ListSnapshots(c *gin.Context) {
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+// SaveYjsSnapshot saves a Yjs snapshot for a document
+func (h *Handler) SaveYjsSnapshot(c *gin.Context) {
+	docIDStr := c.Param("docId")
+	docID, err := uuid.Parse(docIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
 
-	snapshots, err := h.db.ListSnapshots(c.Request.Context(), docID)
+	var req struct {
+		Snapshot string `json:"snapshot" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(req.Snapshot)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snapshots"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Snapshot must be base64 encoded"})
 		return
 	}
-	if snapshots == nil {
-		snapshots = []*models.DocSnapshot{}
+	if detected, foreign := sniffForeignFile(raw); foreign {
+		logger.Info("[API] SaveYjsSnapshot: rejected docID=%s, payload looks like %s", docID, detected)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Snapshot is not a Yjs update"})
+		return
 	}
-	c.JSON(http.StatusOK, snapshots)
+
+	logger.Info("[API] SaveYjsSnapshot: docID=%s, size=%d chars", docID, len(req.Snapshot))
+	// Save snapshot (base64 encoded)
+	snapshot, err := h.db.SaveSnapshotBase64(c.Request.Context(), docID, req.Snapshot)
+	if errors.Is(err, db.ErrSnapshotUnchanged) {
+		logger.Info("[API] SaveYjsSnapshot: unchanged, skipped docID=%s", docID)
+		c.JSON(http.StatusOK, gin.H{"message": "Snapshot unchanged"})
+		return
+	}
+	if errors.Is(err, db.ErrDocumentTrashed) {
+		logger.Info("[API] SaveYjsSnapshot: rejected docID=%s, document is in the trash", docID)
+		c.JSON(http.StatusGone, gin.H{"error": "Document is in the trash"})
+		return
+	}
+	if err != nil {
+		logger.Error("SaveYjsSnapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot"})
+		return
+	}
+	h.webhooks.Send(webhook.EventSnapshotSaved, gin.H{"doc_id": docID, "version": snapshot.Version})
+
+	logger.Info("[API] SaveYjsSnapshot: success docID=%s", docID)
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot saved"})
 }
 
-// GetMyPermission returns the current user's permission for a document
-func (h *Handler) GetMyPermission(c *gin.Context) {
+// CreateShareLink creates a link that grants a role on the document (owner only)
+func (h *Handler) CreateShareLink(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
-	docIDStr := c.Param("id")
-	docID, _ := uuid.Parse(docIDStr)
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	var req models.CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
 
-	perm, err := h.db.GetPermission(c.Request.Context(), docID, user.ID)
+	token, err := auth.GenerateShareLinkToken()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permission"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate share link"})
 		return
 	}
 
-	role := "view"
-	if perm != nil {
-		role = perm.Role
+	link, err := h.db.CreateShareLink(c.Request.Context(), token, docID, user.ID, req.Role, req.ExpiresAt, req.SingleUse)
+	if err != nil {
+		logger.Error("CreateShareLink: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"role": role})
+	logger.Info("[API] CreateShareLink: docID=%s, role=%s, singleUse=%t", docID, link.Role, link.SingleUse)
+	c.JSON(http.StatusCreated, link)
 }
 
-// GetYjsSnapshot returns the latest Yjs snapshot for a document
-func (h *Handler) GetYjsSnapshot(c *gin.Context) {
-	docIDStr := c.Param("docId")
-	docID, err := uuid.Parse(docIDStr)
+// ListShareLinks returns the document's share links (owner only)
+func (h *Handler) ListShareLinks(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	links, err := h.db.ListShareLinks(c.Request.Context(), docID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list share links"})
 		return
 	}
+	if links == nil {
+		links = []*models.ShareLink{}
+	}
+	c.JSON(http.StatusOK, links)
+}
 
-	logger.Info("[API] GetYjsSnapshot: docID=%s", docID)
-	snapshot, err := h.db.GetLatestSnapshot(c.Request.Context(), docID)
+// RevokeShareLink deletes a share link (owner only); access already granted through it is kept
+func (h *Handler) RevokeShareLink(c *gin.Context) {
+	docID, _ := uuid.Parse(c.Param("id"))
+
+	revoked, err := h.db.RevokeShareLink(c.Request.Context(), docID, c.Param("token"))
 	if err != nil {
-		logger.Error("GetYjsSnapshot: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
 		return
 	}
-
-	if snapshot == nil {
-		logger.Info("[API] GetYjsSnapshot: no snapshot found for docID=%s", docID)
-		c.JSON(http.StatusOK, gin.H{"snapshot": nil})
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
 		return
 	}
-
-	// Encode snapshot to base64 for transmission
-	snapshotBase64 := base64.StdEncoding.EncodeToString(snapshot.Snapshot)
-	logger.Info("[API] GetYjsSnapshot: success docID=%s, version=%d, size=%d bytes", docID, snapshot.Version, len(snapshot.Snapshot))
-	c.JSON(http.StatusOK, gin.H{
-		"snapshot": snapshotBase64,
-		"version":  snapshot.Version,
-	})
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
 }
 
-// SaveYjsSnapshot saves a Yjs snapshot for a document
-func (h *Handler) SaveYjsSnapshot(c *gin.Context) {
-	docIDStr := c.Param("docId")
-	docID, err := uuid.Parse(docIDStr)
+// RedeemShareLink grants the current user the role of a share link
+func (h *Handler) RedeemShareLink(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	link, err := h.db.GetShareLink(c.Request.Context(), c.Param("token"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if link == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
 		return
 	}
 
-	var req struct {
-		Snapshot string `json:"snapshot" binding:"required"`
+	doc, err := h.db.GetDocument(c.Request.Context(), link.DocID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if doc == nil || doc.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
 
-	logger.Info("[API] SaveYjsSnapshot: docID=%s, size=%d chars", docID, len(req.Snapshot))
-	// Save snapshot (base64 encoded)
-	_, err = h.db.SaveSnapshotBase64(c.Request.Context(), docID, req.Snapshot)
+	redeemed, err := h.db.RedeemShareLink(c.Request.Context(), link, user.ID)
 	if err != nil {
-		logger.Error("SaveYjsSnapshot: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save snapshot"})
+		logger.Error("RedeemShareLink: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem share link"})
+		return
+	}
+	if !redeemed {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has expired or was already used"})
 		return
 	}
+	h.webhooks.Send(webhook.EventAccessGranted, gin.H{"doc_id": link.DocID, "user_id": user.ID, "role": link.Role})
 
-	logger.Info("[API] SaveYjsSnapshot: success docID=%s", docID)
-	c.JSON(http.StatusOK, gin.H{"message": "Snapshot saved"})
+	logger.Info("[API] RedeemShareLink: docID=%s, userID=%s, role=%s", link.DocID, user.ID, link.Role)
+	c.JSON(http.StatusOK, gin.H{"doc_id": link.DocID, "role": link.Role})
 }
 
 // RequestAccess handles access request from users without permission
@@ -732,13 +2325,14 @@ func (h *Handler) RequestAccess(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	if doc == nil {
+	if doc == nil || doc.DeletedAt != nil {
+		// Trashed documents are gone for everyone but their owner's trash view
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
 
 	// Check if user already has access - allow upgrade requests (view -> edit)
-	perm, err := h.db.GetPermission(c.Request.Context(), docID, user.ID)
+	perm, err := h.db.ResolveEffectivePermission(c.Request.Context(), docID, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -771,21 +2365,44 @@ func (h *Handler) RequestAccess(c *gin.Context) {
 		// Allow the upgrade request
 	}
 
-	accessReq, err := h.db.CreateAccessRequest(c.Request.Context(), docID, user.ID, req.RequestedRole, req.Message)
+	accessReq, err := h.db.CreateAccessRequest(c.Request.Context(), docID, user.ID, req.RequestedRole, req.Message, time.Now().Add(AccessRequestTTL()))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access request"})
 		return
 	}
 
+	// Let the owner's open sessions show the request without polling
+	notification := *accessReq
+	notification.Requester = &models.User{ID: user.ID, Email: user.Email, Name: user.Name, AvatarURL: user.AvatarURL}
+	notification.Document = doc
+	h.notify(c.Request.Context(), doc.OwnerID, models.NotificationAccessRequest, notification)
+
 	c.JSON(http.StatusCreated, accessReq)
 }
 
-// ListAccessRequests returns all access requests for a document (owner only)
+// ListAccessRequests returns access requests for a document (owner only),
+// filtered by ?status=all|pending|approved|rejected|cancelled|expired (default all)
 func (h *Handler) ListAccessRequests(c *gin.Context) {
 	docIDStr := c.Param("id")
 	docID, _ := uuid.Parse(docIDStr)
 
-	requests, err := h.db.ListAccessRequestsByDoc(c.Request.Context(), docID)
+	status := c.DefaultQuery("status", "all")
+	switch status {
+	case "all":
+		status = ""
+	case models.AccessRequestPending, models.AccessRequestApproved, models.AccessRequestRejected,
+		models.AccessRequestCancelled, models.AccessRequestExpired:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of all, pending, approved, rejected, cancelled, expired"})
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	requests, err := h.db.ListAccessRequestsByDoc(c.Request.Context(), docID, status, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list access requests"})
 		return
@@ -818,7 +2435,7 @@ func (h *Handler) UpdateAccessRequest(c *gin.Context) {
 	}
 
 	// Check if user is the document owner
-	perm, err := h.db.GetPermission(c.Request.Context(), accessReq.DocID, user.ID)
+	perm, err := h.db.ResolveEffectivePermission(c.Request.Context(), accessReq.DocID, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -827,6 +2444,10 @@ func (h *Handler) UpdateAccessRequest(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Only document owner can manage access requests"})
 		return
 	}
+	if perm.Trashed {
+		c.JSON(http.StatusGone, gin.H{"error": "Document is in the trash"})
+		return
+	}
 
 	var req models.UpdateAccessRequestRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -834,17 +2455,30 @@ func (h *Handler) UpdateAccessRequest(c *gin.Context) {
 		return
 	}
 
+	// Expired requests may not have been swept yet; the requester must ask again
+	if accessReq.Status == models.AccessRequestExpired ||
+		(accessReq.Status == models.AccessRequestPending && time.Now().After(accessReq.ExpiresAt)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request has expired"})
+		return
+	}
+
 	// Update the request status
 	updated, err := h.db.UpdateAccessRequestStatus(c.Request.Context(), reqID, req.Status)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update access request"})
 		return
 	}
+	if updated == nil {
+		// Already approved, rejected or cancelled, possibly by a concurrent request
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is no longer pending"})
+		return
+	}
 
 	// If approved, grant permission
+	var role string
 	if req.Status == models.AccessRequestApproved {
 		// Use granted_role if provided, otherwise use the originally requested role
-		role := req.GrantedRole
+		role = req.GrantedRole
 		if role == "" {
 			role = accessReq.RequestedRole
 		}
@@ -855,11 +2489,43 @@ func (h *Handler) UpdateAccessRequest(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant permission"})
 			return
 		}
+		h.webhooks.Send(webhook.EventAccessGranted, gin.H{"doc_id": accessReq.DocID, "user_id": accessReq.RequesterID, "role": role})
 	}
+	h.notifyAccessDecision(c.Request.Context(), accessReq, req.Status, role)
 
 	c.JSON(http.StatusOK, updated)
 }
 
+// notifyAccessDecision tells the requester, in their inbox and by email, that
+// their access request was approved (with the granted role) or rejected
+func (h *Handler) notifyAccessDecision(ctx context.Context, accessReq *models.AccessRequest, status, role string) {
+	requester, err := h.db.GetUser(ctx, accessReq.RequesterID)
+	if err != nil || requester == nil {
+		logger.Error("notifyAccessDecision: requester %s not found: %v", accessReq.RequesterID, err)
+		return
+	}
+	title := "a document"
+	var docTitle string
+	if doc, err := h.db.GetDocument(ctx, accessReq.DocID); err == nil && doc != nil {
+		docTitle = doc.Title
+		title = fmt.Sprintf("%q", doc.Title)
+	}
+
+	h.notify(ctx, requester.ID, models.NotificationAccessDecision, gin.H{
+		"request_id": accessReq.ID,
+		"doc_id":     accessReq.DocID,
+		"doc_title":  docTitle,
+		"status":     status,
+		"role":       role,
+	})
+
+	body := fmt.Sprintf("Your request to access %s was %s.", title, status)
+	if link := appLink("/doc/" + accessReq.DocID.String()); link != "" && status == models.AccessRequestApproved {
+		body += "\n\nOpen it at " + link
+	}
+	h.sendEmail(requester.Email, "Access request "+status, body)
+}
+
 // ListMyPendingAccessRequests returns all pending access requests for documents owned by the current user
 func (h *Handler) ListMyPendingAccessRequests(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
@@ -879,6 +2545,65 @@ func (h *Handler) ListMyPendingAccessRequests(c *gin.Context) {
 	c.JSON(http.StatusOK, requests)
 }
 
+// ListMyAccessRequests returns the access requests the current user has made, with their status
+func (h *Handler) ListMyAccessRequests(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	requests, err := h.db.ListMyAccessRequests(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		logger.Error("ListMyAccessRequests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list access requests"})
+		return
+	}
+	if requests == nil {
+		requests = []*models.AccessRequest{}
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// CancelAccessRequest withdraws one of the current user's pending access requests
+func (h *Handler) CancelAccessRequest(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	reqID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+
+	accessReq, err := h.db.GetAccessRequest(c.Request.Context(), reqID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if accessReq == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return
+	}
+	if accessReq.RequesterID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can cancel an access request"})
+		return
+	}
+
+	cancelled, err := h.db.CancelAccessRequest(c.Request.Context(), reqID)
+	if err != nil {
+		logger.Error("CancelAccessRequest: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel access request"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending access requests can be cancelled"})
+		return
+	}
+
+	logger.Info("[API] CancelAccessRequest: requestID=%s", reqID)
+	c.JSON(http.StatusOK, gin.H{"message": "Access request cancelled"})
+}
+
 // ========== Folder Handlers ==========
 
 // CreateFolder creates a new folder
@@ -894,6 +2619,14 @@ func (h *Handler) CreateFolder(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Name = sanitizeName(req.Name); req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Folder name is required"})
+		return
+	}
+
+	if h.folderNameTaken(c, user.ID, req.ParentID, req.Name, nil) {
+		return
+	}
 
 	folder, err := h.db.CreateFolder(c.Request.Context(), req.Name, user.ID, req.ParentID)
 	if err != nil {
@@ -904,6 +2637,24 @@ func (h *Handler) CreateFolder(c *gin.Context) {
 	c.JSON(http.StatusCreated, folder)
 }
 
+// folderNameTaken enforces ENFORCE_UNIQUE_FOLDER_NAMES: it responds 409 (or 500)
+// and returns true when a sibling under parentID already uses name
+func (h *Handler) folderNameTaken(c *gin.Context, ownerID uuid.UUID, parentID *uuid.UUID, name string, excludeID *uuid.UUID) bool {
+	if !uniqueFolderNames() {
+		return false
+	}
+	exists, err := h.db.FolderNameExists(c.Request.Context(), ownerID, parentID, name, excludeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return true
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "A folder with this name already exists here"})
+		return true
+	}
+	return false
+}
+
 // GetFolderContents returns folders and documents in a folder (or root)
 func (h *Handler) GetFolderContents(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
@@ -1023,6 +2774,14 @@ func (h *Handler) UpdateFolder(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Name = sanitizeName(req.Name); req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Folder name is required"})
+		return
+	}
+
+	if h.folderNameTaken(c, user.ID, folder.ParentID, req.Name, &folderID) {
+		return
+	}
 
 	updated, err := h.db.UpdateFolder(c.Request.Context(), folderID, req.Name)
 	if err != nil {
@@ -1033,6 +2792,126 @@ func (h *Handler) UpdateFolder(c *gin.Context) {
 	c.JSON(http.StatusOK, updated)
 }
 
+// TransferFolder hands a folder subtree and its documents to another user (owner only)
+func (h *Handler) TransferFolder(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	// Check ownership
+	folder, err := h.db.GetFolder(c.Request.Context(), folderID)
+	if err != nil || folder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if folder.OwnerID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized"})
+		return
+	}
+
+	var req models.TransferFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	targetID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if targetID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Folder already belongs to this user"})
+		return
+	}
+	target, err := h.db.GetUser(c.Request.Context(), targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	folders, docs, err := h.db.TransferFolder(c.Request.Context(), folderID, user.ID, targetID)
+	if err != nil {
+		logger.Error("TransferFolder: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer folder"})
+		return
+	}
+
+	logger.Info("[API] TransferFolder: folderID=%s, from=%s, to=%s, folders=%d, documents=%d", folderID, user.ID, targetID, folders, docs)
+	c.JSON(http.StatusOK, gin.H{"message": "Folder transferred", "folders": folders, "documents": docs})
+}
+
+// maxFolderCopyNodes bounds the folders plus documents copied by DuplicateFolder
+const maxFolderCopyNodes = 500
+
+// DuplicateFolder copies a folder subtree and its documents into a new folder
+// next to the original (owner only)
+func (h *Handler) DuplicateFolder(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	folderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	var req models.DuplicateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		// An empty body uses the default name
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check ownership
+	folder, err := h.db.GetFolder(c.Request.Context(), folderID)
+	if err != nil || folder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if folder.OwnerID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized"})
+		return
+	}
+
+	name := sanitizeName(req.Name)
+	if name == "" {
+		name = "Copy of " + folder.Name
+	}
+	if h.folderNameTaken(c, user.ID, folder.ParentID, name, nil) {
+		return
+	}
+
+	copied, folders, docs, err := h.db.DuplicateFolder(c.Request.Context(), folderID, user.ID, name, maxFolderCopyNodes)
+	if errors.Is(err, db.ErrSubtreeTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Folder is too large to duplicate (max %d folders and documents)", maxFolderCopyNodes)})
+		return
+	}
+	if err != nil {
+		logger.Error("DuplicateFolder: src=%s, user=%s, error=%v", folderID, user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to duplicate folder"})
+		return
+	}
+
+	logger.Info("[API] DuplicateFolder: src=%s, new=%s, folders=%d, documents=%d", folderID, copied.ID, folders, docs)
+	c.JSON(http.StatusCreated, gin.H{"folder": copied, "folders": folders, "documents": docs})
+}
+
 // DeleteFolder deletes a folder
 func (h *Handler) DeleteFolder(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
@@ -1058,12 +2937,30 @@ func (h *Handler) DeleteFolder(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.DeleteFolder(c.Request.Context(), folderID); err != nil {
+	policy := c.DefaultQuery("documents", folderDeletePolicy())
+	if policy != db.FolderDeleteReparent && policy != db.FolderDeleteTrash {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "documents must be reparent or trash"})
+		return
+	}
+
+	docIDs, err := h.db.DeleteFolder(c.Request.Context(), folderID, policy)
+	if err != nil {
+		logger.Error("DeleteFolder: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted"})
+	if policy == db.FolderDeleteTrash {
+		// Disconnect editors like DeleteDocument does for each trashed document
+		for _, docID := range docIDs {
+			if err := h.publishRoomEviction(c.Request.Context(), docID); err != nil {
+				logger.Warn("DeleteFolder: failed to publish room eviction for %s: %v", docID, err)
+			}
+		}
+	}
+
+	logger.Info("[API] DeleteFolder: folderID=%s, policy=%s, documents=%d", folderID, policy, len(docIDs))
+	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted", "documents": len(docIDs), "policy": policy})
 }
 
 // MoveFolder moves a folder to a new parent
@@ -1097,7 +2994,15 @@ func (h *Handler) MoveFolder(c *gin.Context) {
 		return
 	}
 
+	if h.folderNameTaken(c, user.ID, req.FolderID, folder.Name, &folderID) {
+		return
+	}
+
 	if err := h.db.MoveFolder(c.Request.Context(), folderID, req.FolderID); err != nil {
+		if errors.Is(err, db.ErrFolderCycle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot move a folder into itself or its own descendant"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move folder"})
 		return
 	}
@@ -1127,7 +3032,27 @@ func (h *Handler) MoveDocument(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Document moved"})
 }
 
-// GetFolderTree returns the complete folder tree for the current user
+// RebuildFolderPaths recomputes the stored paths of all of the user's folders
+func (h *Handler) RebuildFolderPaths(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	updated, err := h.db.RebuildFolderPaths(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error("RebuildFolderPaths: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild folder paths"})
+		return
+	}
+
+	logger.Info("[API] RebuildFolderPaths: userID=%s, updated=%d", user.ID, updated)
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// GetFolderTree returns the complete folder tree for the current user.
+// With ?depth=N it returns only N levels below ?folder_id= (or root) for lazy expansion.
 func (h *Handler) GetFolderTree(c *gin.Context) {
 	user := auth.GetUserFromContext(c)
 	if user == nil {
@@ -1135,6 +3060,43 @@ func (h *Handler) GetFolderTree(c *gin.Context) {
 		return
 	}
 
+	if depthStr := c.Query("depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid depth"})
+			return
+		}
+
+		var folderID *uuid.UUID
+		if folderIDStr := c.Query("folder_id"); folderIDStr != "" {
+			id, err := uuid.Parse(folderIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+				return
+			}
+			folder, err := h.db.GetFolder(c.Request.Context(), id)
+			if err != nil || folder == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+				return
+			}
+			if folder.OwnerID != user.ID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized"})
+				return
+			}
+			folderID = &id
+		}
+
+		level, err := h.db.GetFolderTreeLevel(c.Request.Context(), user.ID, folderID, depth)
+		if err != nil {
+			logger.Error("GetFolderTree: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get folder tree"})
+			return
+		}
+
+		c.JSON(http.StatusOK, level)
+		return
+	}
+
 	tree, err := h.db.GetFolderTree(c.Request.Context(), user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get folder tree"})
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Security header defaults; an env variable set to "off" disables its header
+const (
+	defaultFrameOptions   = "DENY"
+	defaultReferrerPolicy = "strict-origin-when-cross-origin"
+	defaultSkipPaths      = "/health"
+)
+
+// headerSetting reads a header value from env, falling back to def when unset
+func headerSetting(key, def string) string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		v = def
+	}
+	if strings.EqualFold(strings.TrimSpace(v), "off") {
+		return ""
+	}
+	return strings.TrimSpace(v)
+}
+
+// SecurityHeaders sets X-Content-Type-Options, X-Frame-Options, Referrer-Policy
+// and, when SECURITY_CSP is set, Content-Security-Policy on every response except
+// the paths in SECURITY_HEADERS_SKIP_PATHS (comma-separated, default /health).
+// Values come from SECURITY_CONTENT_TYPE_OPTIONS, SECURITY_FRAME_OPTIONS and
+// SECURITY_REFERRER_POLICY.
+func SecurityHeaders() gin.HandlerFunc {
+	headers := map[string]string{
+		"X-Content-Type-Options":  headerSetting("SECURITY_CONTENT_TYPE_OPTIONS", "nosniff"),
+		"X-Frame-Options":         headerSetting("SECURITY_FRAME_OPTIONS", defaultFrameOptions),
+		"Referrer-Policy":         headerSetting("SECURITY_REFERRER_POLICY", defaultReferrerPolicy),
+		"Content-Security-Policy": headerSetting("SECURITY_CSP", ""),
+	}
+	for name, value := range headers {
+		if value == "" {
+			delete(headers, name)
+		}
+	}
+
+	skip := make(map[string]bool)
+	for _, p := range strings.Split(headerSetting("SECURITY_HEADERS_SKIP_PATHS", defaultSkipPaths), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			skip[p] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if !skip[c.Request.URL.Path] {
+			for name, value := range headers {
+				c.Header(name, value)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	// get serves path through SecurityHeaders built from the current environment
+	get := func(t *testing.T, path string) http.Header {
+		t.Helper()
+		r := gin.New()
+		r.Use(SecurityHeaders())
+		r.GET("/api/docs", func(c *gin.Context) { c.Status(http.StatusOK) })
+		r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Header()
+	}
+	defaults := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		header := get(t, "/api/docs")
+		for name, want := range defaults {
+			if got := header.Get(name); got != want {
+				t.Errorf("%s = %q, want %q", name, got, want)
+			}
+		}
+		if got := header.Get("Content-Security-Policy"); got != "" {
+			t.Errorf("Content-Security-Policy = %q without SECURITY_CSP", got)
+		}
+	})
+
+	t.Run("skipped paths", func(t *testing.T) {
+		for name := range defaults {
+			if got := get(t, "/health").Get(name); got != "" {
+				t.Errorf("/health: %s = %q, want none", name, got)
+			}
+		}
+
+		t.Setenv("SECURITY_HEADERS_SKIP_PATHS", "/api/docs")
+		if got := get(t, "/api/docs").Get("X-Frame-Options"); got != "" {
+			t.Errorf("/api/docs skipped: X-Frame-Options = %q, want none", got)
+		}
+		if got := get(t, "/health").Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("/health no longer skipped: X-Frame-Options = %q, want DENY", got)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("SECURITY_FRAME_OPTIONS", "off")
+		t.Setenv("SECURITY_REFERRER_POLICY", "no-referrer")
+		t.Setenv("SECURITY_CSP", "default-src 'self'")
+		header := get(t, "/api/docs")
+		if _, ok := header["X-Frame-Options"]; ok {
+			t.Errorf("X-Frame-Options = %q, want it disabled", header.Get("X-Frame-Options"))
+		}
+		if got := header.Get("Referrer-Policy"); got != "no-referrer" {
+			t.Errorf("Referrer-Policy = %q, want no-referrer", got)
+		}
+		if got := header.Get("Content-Security-Policy"); got != "default-src 'self'" {
+			t.Errorf("Content-Security-Policy = %q, want default-src 'self'", got)
+		}
+	})
+}
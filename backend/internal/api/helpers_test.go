@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/redis"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestAPI registers every route on a router backed by a throwaway database
+// (skipping the test without TEST_DATABASE_URL); Redis is not available
+func newTestAPI(t *testing.T) (*gin.Engine, *db.DB) {
+	t.Helper()
+	database := dbtest.New(t)
+	r := gin.New()
+	NewHandler(database, nil).RegisterRoutes(r)
+	return r, database
+}
+
+// newTestRedis connects to TEST_REDIS_URL, skipping the test when it is not set
+func newTestRedis(t *testing.T) *redis.PubSub {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	t.Setenv("REDIS_URL", url)
+	pubsub, err := redis.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pubsub.Close() })
+	return pubsub
+}
+
+// doRequest sends a request authenticated as user (anonymous when nil) with an
+// optional JSON body
+func doRequest(t *testing.T, r http.Handler, method, path string, user *models.User, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		token, err := auth.GenerateToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody unmarshals a JSON response body
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body.String(), err)
+	}
+}
+
+// sentEmail is a message recorded by fakeEmailSender
+type sentEmail struct {
+	to, subject, body string
+}
+
+// fakeEmailSender records outgoing email instead of delivering it
+type fakeEmailSender struct {
+	sent chan sentEmail
+}
+
+func newFakeEmailSender() *fakeEmailSender {
+	return &fakeEmailSender{sent: make(chan sentEmail, 10)}
+}
+
+func (s *fakeEmailSender) Send(ctx context.Context, to, subject, body string) error {
+	s.sent <- sentEmail{to: to, subject: subject, body: body}
+	return nil
+}
+
+// next waits for the next email (they are sent in the background)
+func (s *fakeEmailSender) next(t *testing.T) sentEmail {
+	t.Helper()
+	select {
+	case m := <-s.sent:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+		return sentEmail{}
+	}
+}
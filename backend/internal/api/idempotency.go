@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader lets clients safely retry create requests
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// idempotencyTTL is how long a key and its stored response are kept
+	idempotencyTTL = 10 * time.Minute
+	// maxIdempotencyKeyLen bounds client-supplied keys
+	maxIdempotencyKeyLen = 255
+)
+
+// idempotentResponse is the stored result of a completed request
+type idempotentResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// captureWriter records the response body while still writing it to the client
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent replays the original response when a create request is retried
+// with the same Idempotency-Key. Keys are scoped per user and request path.
+// Requires Redis; without it (or if Redis errors) requests run normally.
+func (h *Handler) idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || h.redis == nil {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		user := auth.GetUserFromContext(c)
+		if user == nil {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		baseKey := "idempotency:" + user.ID.String() + ":" + c.Request.URL.Path + ":" + key
+		resultKey := baseKey + ":result"
+		lockKey := baseKey + ":lock"
+
+		// Replay a completed request
+		stored, err := h.redis.Get(ctx, resultKey)
+		if err != nil {
+			logger.Error("Idempotency: failed to read key: %v", err)
+			c.Next()
+			return
+		}
+		if stored != nil {
+			var resp idempotentResponse
+			if err := json.Unmarshal(stored, &resp); err == nil {
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(resp.Status, "application/json; charset=utf-8", resp.Body)
+				c.Abort()
+				return
+			}
+		}
+
+		// Only one request per key may run at a time
+		acquired, err := h.redis.SetNX(ctx, lockKey, []byte("1"), idempotencyTTL)
+		if err != nil {
+			logger.Error("Idempotency: failed to lock key: %v", err)
+			c.Next()
+			return
+		}
+		if !acquired {
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+			c.Abort()
+			return
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status < 200 || status >= 300 {
+			// Let the client retry failed requests with the same key
+			if err := h.redis.Delete(ctx, lockKey); err != nil {
+				logger.Error("Idempotency: failed to release key: %v", err)
+			}
+			return
+		}
+
+		data, err := json.Marshal(idempotentResponse{Status: status, Body: writer.body.Bytes()})
+		if err != nil {
+			return
+		}
+		if _, err := h.redis.SetNX(ctx, resultKey, data, idempotencyTTL); err != nil {
+			logger.Error("Idempotency: failed to store response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestIdempotencyKeyReplaysCreates(t *testing.T) {
+	database := dbtest.New(t)
+	r := gin.New()
+	NewHandler(database, newTestRedis(t)).RegisterRoutes(r)
+
+	owner := dbtest.User(t, database, "Owner")
+	other := dbtest.User(t, database, "Other")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, other.ID, models.RoleComment)
+
+	// create sends a JSON POST as user with an Idempotency-Key and returns the
+	// created resource ID
+	create := func(t *testing.T, path string, user *models.User, body interface{}, key string) (uuid.UUID, *httptest.ResponseRecorder) {
+		t.Helper()
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		token, err := auth.GenerateToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var created struct {
+			ID uuid.UUID `json:"id"`
+		}
+		if w.Code == http.StatusCreated {
+			decodeBody(t, w, &created)
+		}
+		return created.ID, w
+	}
+	count := func(t *testing.T, table string, ownerColumn string, userID uuid.UUID) int {
+		t.Helper()
+		var n int
+		if err := dbtest.QueryRow(t, `SELECT COUNT(*) FROM `+table+` WHERE `+ownerColumn+` = $1`, userID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		body        interface{}
+		table       string
+		ownerColumn string
+	}{
+		{"document", "/api/docs", models.CreateDocumentRequest{Title: "Retried"}, "documents", "owner_id"},
+		{"folder", "/api/folders", models.CreateFolderRequest{Name: "Retried"}, "folders", "owner_id"},
+		{"comment", "/api/docs/" + doc.ID.String() + "/comments", models.CreateCommentRequest{Content: "Retried"}, "comments", "user_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := count(t, tt.table, tt.ownerColumn, owner.ID)
+
+			first, w := create(t, tt.path, owner, tt.body, "key-1")
+			if w.Code != http.StatusCreated {
+				t.Fatalf("first: status %d (%s)", w.Code, w.Body.String())
+			}
+			second, w := create(t, tt.path, owner, tt.body, "key-1")
+			if w.Code != http.StatusCreated || second != first {
+				t.Errorf("retry: status %d id %s, want %d id %s", w.Code, second, http.StatusCreated, first)
+			}
+			if w.Header().Get("Idempotent-Replayed") != "true" {
+				t.Error("retry: response is not marked as replayed")
+			}
+			if n := count(t, tt.table, tt.ownerColumn, owner.ID); n != before+1 {
+				t.Errorf("%d rows created, want 1", n-before)
+			}
+
+			if id, _ := create(t, tt.path, owner, tt.body, "key-2"); id == first {
+				t.Error("a new key replayed the first response")
+			}
+			if id, _ := create(t, tt.path, other, tt.body, "key-1"); id == first {
+				t.Error("another user's request with the same key replayed the first response")
+			}
+		})
+	}
+
+	t.Run("failed request is not stored", func(t *testing.T) {
+		if _, w := create(t, "/api/docs", owner, map[string]string{}, "key-failed"); w.Code != http.StatusBadRequest {
+			t.Fatalf("invalid request: status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		id, w := create(t, "/api/docs", owner, models.CreateDocumentRequest{Title: "Fixed"}, "key-failed")
+		if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" || id == uuid.Nil {
+			t.Errorf("retry after failure: status %d replayed %q, want a new document", w.Code, w.Header().Get("Idempotent-Replayed"))
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxMentions caps the users one comment can mention
+	maxMentions = 20
+	// mentionExcerptLen is the number of characters of the comment sent with a mention notification
+	mentionExcerptLen = 140
+)
+
+// emailMentionPattern matches "@alice@example.com" at the start of the text or after whitespace
+var emailMentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@]+@[^\s@]+\.[^\s@]+)`)
+
+// mentionedEmails returns the distinct @email mentions in a comment, in order
+func mentionedEmails(content string) []string {
+	var emails []string
+	seen := make(map[string]bool)
+	for _, m := range emailMentionPattern.FindAllStringSubmatch(content, -1) {
+		email := strings.TrimRight(m[1], ".,;:!?)")
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// resolveMentions combines the explicit mention IDs of a new comment with the
+// @email mentions in its content. Every explicit ID must belong to a user with
+// access to the document, or a 400 is written and ok is false. Emails that
+// match no such user are left as plain text.
+func (h *Handler) resolveMentions(c *gin.Context, docID uuid.UUID, ids []string, content string) (mentions []uuid.UUID, ok bool) {
+	ctx := c.Request.Context()
+	seen := make(map[uuid.UUID]bool)
+
+	for _, idStr := range ids {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mention ID"})
+			return nil, false
+		}
+		if seen[id] {
+			continue
+		}
+		perm, err := h.db.ResolveEffectivePermission(ctx, docID, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		}
+		if perm == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Mentioned user " + idStr + " has no access to this document"})
+			return nil, false
+		}
+		seen[id] = true
+		mentions = append(mentions, id)
+	}
+
+	for _, email := range mentionedEmails(content) {
+		if len(mentions) >= maxMentions {
+			break
+		}
+		user, err := h.db.GetUserByEmail(ctx, email)
+		if err != nil {
+			logger.Error("CreateComment: failed to resolve mention %s: %v", email, err)
+			continue
+		}
+		if user == nil || seen[user.ID] {
+			continue
+		}
+		perm, err := h.db.ResolveEffectivePermission(ctx, docID, user.ID)
+		if err != nil || perm == nil {
+			continue
+		}
+		seen[user.ID] = true
+		mentions = append(mentions, user.ID)
+	}
+	return mentions, true
+}
+
+// truncateRunes shortens s to at most n characters
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
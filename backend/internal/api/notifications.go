@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// notify stores a notification in a user's inbox and publishes it to their
+// Redis channel, from where the collab server relays it to their open
+// sessions. Failures are logged; they never fail the request that caused them.
+func (h *Handler) notify(ctx context.Context, userID uuid.UUID, notificationType string, payload interface{}) {
+	n, err := h.db.CreateNotification(ctx, userID, notificationType, payload)
+	if err != nil {
+		logger.Error("notify: failed to store %s notification for %s: %v", notificationType, userID, err)
+		return
+	}
+	if h.redis == nil {
+		return
+	}
+	msg, err := json.Marshal(n)
+	if err == nil {
+		err = h.redis.Publish(ctx, redis.GetUserChannel(userID), msg)
+	}
+	if err != nil {
+		logger.Warn("notify: failed to publish %s notification for %s: %v", notificationType, userID, err)
+	}
+}
+
+// notifyAccessGranted tells users they were given access to a document by its owner
+func (h *Handler) notifyAccessGranted(ctx context.Context, docID uuid.UUID, grantedBy *models.User, grants map[uuid.UUID]string) {
+	doc, err := h.db.GetDocument(ctx, docID)
+	if err != nil || doc == nil {
+		logger.Error("notifyAccessGranted: document %s not found: %v", docID, err)
+		return
+	}
+	for userID, role := range grants {
+		if userID == grantedBy.ID {
+			continue
+		}
+		h.notify(ctx, userID, models.NotificationAccessGranted, gin.H{
+			"doc_id":     doc.ID,
+			"doc_title":  doc.Title,
+			"role":       role,
+			"granted_by": gin.H{"id": grantedBy.ID, "name": grantedBy.Name, "email": grantedBy.Email},
+		})
+	}
+}
+
+// maxNotificationsLimit caps GET /api/notifications?limit=
+const maxNotificationsLimit = 100
+
+// ListNotifications returns the current user's notifications, newest first
+// (?unread=true for unread only, ?limit= up to 100, default 50)
+func (h *Handler) ListNotifications(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	limit := defaultPageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxNotificationsLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread"))
+
+	notifications, err := h.db.ListNotifications(c.Request.Context(), user.ID, unreadOnly, limit)
+	if err != nil {
+		logger.Error("ListNotifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list notifications"})
+		return
+	}
+	if notifications == nil {
+		notifications = []*models.Notification{}
+	}
+	c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationRead marks one of the current user's notifications read
+func (h *Handler) MarkNotificationRead(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	found, err := h.db.MarkNotificationRead(c.Request.Context(), id, user.ID)
+	if err != nil {
+		logger.Error("MarkNotificationRead: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// MarkAllNotificationsRead marks all of the current user's notifications read
+func (h *Handler) MarkAllNotificationsRead(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+
+	updated, err := h.db.MarkAllNotificationsRead(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error("MarkAllNotificationsRead: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func TestResetTokenLifetime(t *testing.T) {
+	database := dbtest.New(t)
+	pubsub := newTestRedis(t)
+	t.Setenv("RESET_TOKEN_TTL", "10m")
+	t.Setenv("APP_URL", "")
+	sender := newFakeEmailSender()
+	h := NewHandler(database, pubsub)
+	h.SetEmailSender(sender)
+	r := gin.New()
+	h.RegisterRoutes(r)
+	ctx := context.Background()
+	user := dbtest.User(t, database, "User")
+
+	w := doRequest(t, r, http.MethodPost, "/api/auth/forgot-password", nil, models.ForgotPasswordRequest{Email: user.Email})
+	if w.Code != http.StatusOK {
+		t.Fatalf("forgot-password: status %d (%s)", w.Code, w.Body.String())
+	}
+	mail := sender.next(t)
+	token := regexp.MustCompile(`[0-9a-f]{32,128}`).FindString(mail.body)
+	if mail.to != user.Email || token == "" {
+		t.Fatalf("reset email to %s without a token: %q", mail.to, mail.body)
+	}
+
+	opts, err := goredis.ParseURL(os.Getenv("TEST_REDIS_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := goredis.NewClient(opts)
+	defer client.Close()
+	ttl, err := client.TTL(ctx, resetTokenKey(token)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("token TTL = %v, want RESET_TOKEN_TTL (10m)", ttl)
+	}
+
+	reset := func(token string) int {
+		return doRequest(t, r, http.MethodPost, "/api/auth/reset-password", nil,
+			models.ResetPasswordRequest{Token: token, NewPassword: "new-password"}).Code
+	}
+	if status := reset(token); status != http.StatusOK {
+		t.Errorf("reset: status %d, want %d", status, http.StatusOK)
+	}
+	if status := reset(token); status != http.StatusBadRequest {
+		t.Errorf("reused token: status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := reset("not-a-token"); status != http.StatusBadRequest {
+		t.Errorf("malformed token: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// A token whose TTL has run out is gone from Redis and rejected
+	expired := strings.Repeat("ab", 32)
+	if err := pubsub.SetWithTTL(ctx, resetTokenKey(expired), []byte(user.Email), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if status := reset(expired); status != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
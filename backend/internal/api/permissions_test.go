@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+)
+
+// Handlers that load a comment or access request first and check permission on
+// its document must resolve access the same way RequirePermission does
+func TestCommentAndAccessRequestHandlersUseEffectivePermission(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+
+	owner := dbtest.User(t, database, "Owner")
+	linkUser := dbtest.User(t, database, "LinkUser")
+	viewer := dbtest.User(t, database, "Viewer")
+	stranger := dbtest.User(t, database, "Stranger")
+	requester := dbtest.User(t, database, "Requester")
+
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	// The owner's permission row is missing; ownership alone must be enough
+	dbtest.Exec(t, `DELETE FROM document_permissions WHERE doc_id = $1`, doc.ID)
+	dbtest.Grant(t, database, doc.ID, viewer.ID, models.RoleView)
+	link, err := database.CreateShareLink(ctx, "token-"+doc.ID.String(), doc.ID, owner.ID, models.RoleComment, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.RedeemShareLink(ctx, link, linkUser.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	newThread := func() string {
+		t.Helper()
+		comment, err := database.CreateComment(ctx, doc.ID, owner.ID, "Thread", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.CreateComment(ctx, doc.ID, owner.ID, "Reply", nil, &comment.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		return "/api/comments/" + comment.ID.String()
+	}
+
+	t.Run("ListCommentReplies", func(t *testing.T) {
+		path := newThread() + "/replies"
+		for _, tt := range []struct {
+			user *models.User
+			want int
+		}{
+			{owner, http.StatusOK},
+			{linkUser, http.StatusOK},
+			{viewer, http.StatusOK},
+			{stranger, http.StatusForbidden},
+		} {
+			if w := doRequest(t, r, http.MethodGet, path, tt.user, nil); w.Code != tt.want {
+				t.Errorf("%s: status %d, want %d (%s)", tt.user.Name, w.Code, tt.want, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("ResolveComment", func(t *testing.T) {
+		for _, tt := range []struct {
+			user *models.User
+			want int
+		}{
+			{viewer, http.StatusForbidden},
+			{stranger, http.StatusForbidden},
+			{owner, http.StatusOK},
+			{linkUser, http.StatusOK},
+		} {
+			path := newThread() + "/resolve"
+			if w := doRequest(t, r, http.MethodPost, path, tt.user, nil); w.Code != tt.want {
+				t.Errorf("%s: status %d, want %d (%s)", tt.user.Name, w.Code, tt.want, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("UpdateAccessRequest", func(t *testing.T) {
+		req, err := database.CreateAccessRequest(ctx, doc.ID, requester.ID, models.RoleView, "", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/api/access-requests/" + req.ID.String()
+		body := models.UpdateAccessRequestRequest{Status: models.AccessRequestApproved}
+		if w := doRequest(t, r, http.MethodPut, path, linkUser, body); w.Code != http.StatusForbidden {
+			t.Errorf("non-owner: status %d, want %d", w.Code, http.StatusForbidden)
+		}
+		if w := doRequest(t, r, http.MethodPut, path, owner, body); w.Code != http.StatusOK {
+			t.Errorf("owner: status %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+		}
+	})
+
+	t.Run("trashed document", func(t *testing.T) {
+		path := newThread()
+		if err := database.SoftDeleteDocument(ctx, doc.ID); err != nil {
+			t.Fatal(err)
+		}
+		if w := doRequest(t, r, http.MethodGet, path+"/replies", owner, nil); w.Code != http.StatusGone {
+			t.Errorf("replies: status %d, want %d", w.Code, http.StatusGone)
+		}
+		if w := doRequest(t, r, http.MethodPost, path+"/resolve", owner, nil); w.Code != http.StatusGone {
+			t.Errorf("resolve: status %d, want %d", w.Code, http.StatusGone)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Auth endpoint rate limit defaults, per client IP and endpoint
+const (
+	defaultAuthRateLimit  = 20
+	defaultAuthRateWindow = time.Minute
+)
+
+// authRateLimit reads AUTH_RATE_LIMIT (requests per window, 0 disables) and
+// AUTH_RATE_WINDOW (a duration)
+func authRateLimit() (int, time.Duration) {
+	limit := defaultAuthRateLimit
+	if n, err := strconv.Atoi(os.Getenv("AUTH_RATE_LIMIT")); err == nil && n >= 0 {
+		limit = n
+	}
+	window := defaultAuthRateWindow
+	if d, err := time.ParseDuration(os.Getenv("AUTH_RATE_WINDOW")); err == nil && d >= time.Second {
+		window = d
+	}
+	return limit, window
+}
+
+// RateLimitMiddleware allows each client IP limit requests per window on the
+// route, answering 429 with Retry-After beyond that. Counters are fixed windows
+// in Redis, shared by all instances; without Redis (or if it errors) requests
+// are not limited. The client IP honors X-Forwarded-For only from the proxies
+// trusted via TRUSTED_PROXIES.
+func (h *Handler) RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 || h.redis == nil {
+			c.Next()
+			return
+		}
+
+		key := fmt.Sprintf("ratelimit:%s:%s", c.FullPath(), c.ClientIP())
+		count, resetIn, err := h.redis.IncrWindow(c.Request.Context(), key, window)
+		if err != nil {
+			logger.Warn("[RateLimit] counter unavailable, allowing request: %v", err)
+			c.Next()
+			return
+		}
+		if count > int64(limit) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(resetIn.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
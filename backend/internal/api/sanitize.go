@@ -0,0 +1,48 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sanitizeName cleans a single-line name (document title, folder name):
+// control characters are dropped, runs of whitespace including newlines and
+// tabs collapse to one space, and the result is trimmed
+func sanitizeName(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	space := false
+	for _, r := range strings.ToValidUTF8(s, "") {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+		case unicode.IsControl(r):
+			// dropped
+		default:
+			if space && sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			space = false
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// sanitizeText cleans multi-line text (comment content): line breaks and tabs
+// are kept with CRLF normalized to LF, other control characters are dropped
+func sanitizeText(s string) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\r\n", "\n")
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if r == '\r' {
+			return '\n'
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s))
+}
@@ -0,0 +1,42 @@
+package api
+
+import "testing"
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Plan", "Plan"},
+		{"Q1\nplan", "Q1 plan"},
+		{"Q1\r\n\tplan", "Q1 plan"},
+		{"nul\x00byte", "nulbyte"},
+		{"bell\x07 and \x1b[31mescape", "bell and [31mescape"},
+		{"  padded   title  ", "padded title"},
+		{"bad \xff utf-8", "bad utf-8"},
+		{"\x00\n\t", ""},
+		{"Überblick — 2024", "Überblick — 2024"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeName(tt.in); got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Looks good", "Looks good"},
+		{"line one\r\nline two\rline three", "line one\nline two\nline three"},
+		{"keep\ttabs\nand lines", "keep\ttabs\nand lines"},
+		{"nul\x00 and \x1b escape", "nul and  escape"},
+		{"\n  trimmed \n", "trimmed"},
+		{"\x00\x01", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.in); got != tt.want {
+			t.Errorf("sanitizeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
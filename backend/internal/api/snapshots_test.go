@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+)
+
+func TestGetYjsSnapshotEncoding(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Saved")
+	empty := dbtest.Document(t, database, owner.ID, "Empty")
+
+	snapshot := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "Hello"})
+	saved, err := database.SaveSnapshot(ctx, doc.ID, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(docID, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/yjs/"+docID+"/snapshot", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		w := get(doc.ID.String(), "application/octet-stream")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" {
+			t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if !bytes.Equal(w.Body.Bytes(), snapshot) {
+			t.Errorf("body is not the raw snapshot (%d bytes, want %d)", w.Body.Len(), len(snapshot))
+		}
+		if got := w.Header().Get(SnapshotVersionHeader); got != strconv.Itoa(saved.Version) {
+			t.Errorf("%s = %q, want %d", SnapshotVersionHeader, got, saved.Version)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json"} {
+			w := get(doc.ID.String(), accept)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Accept %q: status %d", accept, w.Code)
+			}
+			var body struct {
+				Snapshot string `json:"snapshot"`
+				Version  int    `json:"version"`
+			}
+			decodeBody(t, w, &body)
+			raw, err := base64.StdEncoding.DecodeString(body.Snapshot)
+			if err != nil || !bytes.Equal(raw, snapshot) || body.Version != saved.Version {
+				t.Errorf("Accept %q: got version %d and %d snapshot bytes (err %v)", accept, body.Version, len(raw), err)
+			}
+		}
+	})
+
+	t.Run("no snapshot", func(t *testing.T) {
+		if w := get(empty.ID.String(), "application/octet-stream"); w.Code != http.StatusNoContent {
+			t.Errorf("binary: status %d, want %d", w.Code, http.StatusNoContent)
+		}
+		w := get(empty.ID.String(), "")
+		var body map[string]interface{}
+		decodeBody(t, w, &body)
+		if v, ok := body["snapshot"]; w.Code != http.StatusOK || !ok || v != nil {
+			t.Errorf("json: status %d body %v, want a null snapshot", w.Code, body)
+		}
+	})
+}
+
+func TestSaveYjsSnapshotRejectsForeignPayloads(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	path := "/api/yjs/" + doc.ID.String() + "/snapshot"
+
+	post := func(contentType string, data []byte) int {
+		body := `{"snapshot":"` + base64.StdEncoding.EncodeToString(data) + `"}`
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	update := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "Hi"})
+
+	if status := post("application/json", update); status != http.StatusOK {
+		t.Errorf("valid snapshot: status %d, want %d", status, http.StatusOK)
+	}
+	if status := post("text/plain", update); status != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: status %d, want %d", status, http.StatusUnsupportedMediaType)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if status := post("application/json", png); status != http.StatusUnsupportedMediaType {
+		t.Errorf("image posted as snapshot: status %d, want %d", status, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// docStatsTTL is how long computed stats stay cached; a snapshot version never
+// changes, so this only bounds how long unused entries are kept
+const docStatsTTL = 24 * time.Hour
+
+// computeDocStats counts the words, characters and non-empty paragraphs of a snapshot's text
+func computeDocStats(snapshot []byte) (models.DocumentStats, error) {
+	var stats models.DocumentStats
+	doc, err := yjs.DecodeUpdate(snapshot)
+	if err != nil {
+		return stats, err
+	}
+	fragment := doc.XmlFragment("default") // TipTap's collaboration fragment
+	if fragment == nil {
+		return stats, nil
+	}
+	for _, block := range fragment.TextBlocks() {
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+		stats.Paragraphs++
+		stats.Words += len(strings.Fields(block))
+		stats.Characters += utf8.RuneCountInString(block)
+	}
+	return stats, nil
+}
+
+// GetDocumentStats returns word, character and paragraph counts of the latest
+// snapshot. Results are cached in Redis per document version.
+func (h *Handler) GetDocumentStats(c *gin.Context) {
+	docID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	snapshot, err := h.db.GetLatestSnapshot(ctx, docID)
+	if err != nil {
+		logger.Error("GetDocumentStats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get snapshot"})
+		return
+	}
+	if snapshot == nil {
+		c.JSON(http.StatusOK, models.DocumentStats{})
+		return
+	}
+
+	cacheKey := fmt.Sprintf("doc-stats:%s:%d", docID, snapshot.Version)
+	if h.redis != nil {
+		cached, err := h.redis.Get(ctx, cacheKey)
+		if err != nil {
+			logger.Warn("GetDocumentStats: failed to read cache: %v", err)
+		} else if cached != nil {
+			var stats models.DocumentStats
+			if err := json.Unmarshal(cached, &stats); err == nil {
+				c.JSON(http.StatusOK, stats)
+				return
+			}
+		}
+	}
+
+	stats, err := computeDocStats(snapshot.Snapshot)
+	if err != nil {
+		logger.Error("GetDocumentStats: failed to decode snapshot for doc %s: %v", docID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode document"})
+		return
+	}
+	stats.Version = snapshot.Version
+
+	if h.redis != nil {
+		if data, err := json.Marshal(stats); err == nil {
+			if err := h.redis.SetWithTTL(ctx, cacheKey, data, docStatsTTL); err != nil {
+				logger.Warn("GetDocumentStats: failed to cache stats: %v", err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+)
+
+func TestComputeDocStats(t *testing.T) {
+	snapshot := yjstest.Document(
+		yjstest.Block{Name: "heading", Text: "Meeting notes"},
+		yjstest.Block{Name: "paragraph", Text: "  Ship the  release on Friday. "},
+		yjstest.Block{Name: "paragraph", Text: "   "},
+		yjstest.Block{Name: "paragraph"},
+		yjstest.Block{Name: "paragraph", Text: "Größe ✓"},
+	)
+
+	stats, err := computeDocStats(snapshot)
+	if err != nil {
+		t.Fatalf("computeDocStats: %v", err)
+	}
+	want := models.DocumentStats{Words: 9, Characters: 13 + 31 + 7, Paragraphs: 3}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestComputeDocStatsEmpty(t *testing.T) {
+	for name, snapshot := range map[string][]byte{
+		"empty update":    {0, 0},
+		"no text":         yjstest.Document(yjstest.Block{Name: "paragraph"}),
+		"other root type": append([]byte{1, 1, 1, 0, 4, 1, 4, 't', 'e', 'x', 't', 2, 'h', 'i'}, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			stats, err := computeDocStats(snapshot)
+			if err != nil {
+				t.Fatalf("computeDocStats: %v", err)
+			}
+			if stats != (models.DocumentStats{}) {
+				t.Errorf("stats = %+v, want zeros", stats)
+			}
+		})
+	}
+}
+
+func TestComputeDocStatsMalformed(t *testing.T) {
+	// A string whose length overflows the read position
+	snapshot := []byte{1, 1, 1, 0, 4, 1, 1, 'x', 0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	if _, err := computeDocStats(snapshot); err == nil {
+		t.Error("expected an error for a malformed snapshot")
+	}
+}
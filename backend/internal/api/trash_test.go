@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+)
+
+func TestTrashedDocumentRejectsWrites(t *testing.T) {
+	r, database := newTestAPI(t)
+	owner := dbtest.User(t, database, "Owner")
+	editor := dbtest.User(t, database, "Editor")
+	doc := dbtest.Document(t, database, owner.ID, "Trashed")
+	dbtest.Grant(t, database, doc.ID, editor.ID, models.RoleEdit)
+	docPath := "/api/docs/" + doc.ID.String()
+	snapshot := map[string]string{
+		"snapshot": base64.StdEncoding.EncodeToString(yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "Hi"})),
+	}
+
+	if w := doRequest(t, r, http.MethodDelete, docPath, owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("trash: status %d (%s)", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   *models.User
+		body   interface{}
+	}{
+		{"read", http.MethodGet, docPath, editor, nil},
+		{"comment", http.MethodPost, docPath + "/comments", editor, models.CreateCommentRequest{Content: "Hi"}},
+		{"tag", http.MethodPost, docPath + "/tags", editor, models.AddTagRequest{Tag: "draft"}},
+		{"share", http.MethodGet, docPath + "/share-links", owner, nil},
+		{"collab snapshot", http.MethodPost, "/api/yjs/" + doc.ID.String() + "/snapshot", nil, snapshot},
+		{"collab check", http.MethodHead, "/api/yjs/" + doc.ID.String(), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, r, tt.method, tt.path, tt.user, tt.body)
+			if w.Code != http.StatusGone {
+				t.Errorf("status = %d, want %d (%s)", w.Code, http.StatusGone, w.Body.String())
+			}
+		})
+	}
+
+	var trash []models.Document
+	w := doRequest(t, r, http.MethodGet, "/api/docs/trash", owner, nil)
+	decodeBody(t, w, &trash)
+	if len(trash) != 1 || trash[0].ID != doc.ID {
+		t.Fatalf("trash = %+v, want the trashed document", trash)
+	}
+
+	if w := doRequest(t, r, http.MethodPost, docPath+"/restore", editor, nil); w.Code != http.StatusForbidden {
+		t.Errorf("restore by editor: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := doRequest(t, r, http.MethodPost, docPath+"/restore", owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d (%s)", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodGet, docPath, editor, nil); w.Code != http.StatusOK {
+		t.Errorf("read after restore: status %d (%s)", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, "/api/yjs/"+doc.ID.String()+"/snapshot", nil, snapshot); w.Code != http.StatusOK {
+		t.Errorf("collab snapshot after restore: status %d (%s)", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestAccessToTrashedDocument(t *testing.T) {
+	r, database := newTestAPI(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	outsider := dbtest.User(t, database, "Outsider")
+	doc := dbtest.Document(t, database, owner.ID, "Trashed")
+	path := "/api/docs/" + doc.ID.String() + "/access-request"
+	body := models.CreateAccessRequestRequest{RequestedRole: models.RoleView}
+
+	if err := database.SoftDeleteDocument(ctx, doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := doRequest(t, r, http.MethodPost, path, outsider, body); w.Code != http.StatusNotFound {
+		t.Errorf("request on a trashed document: status %d, want %d (%s)", w.Code, http.StatusNotFound, w.Body.String())
+	}
+	if pending, err := database.GetPendingAccessRequest(ctx, doc.ID, outsider.ID); err != nil || pending != nil {
+		t.Errorf("pending request %+v (%v), want none", pending, err)
+	}
+
+	if w := doRequest(t, r, http.MethodPost, "/api/docs/"+doc.ID.String()+"/restore", owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d (%s)", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, r, http.MethodPost, path, outsider, body); w.Code != http.StatusCreated {
+		t.Errorf("request after restore: status %d, want %d (%s)", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
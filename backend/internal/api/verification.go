@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/collab-docs/backend/internal/auth"
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// verificationTokenTTL is how long an email verification link stays valid
+const verificationTokenTTL = 24 * time.Hour
+
+// verificationTokenKey is the Redis key holding the user ID a verification token was issued for
+func verificationTokenKey(token string) string {
+	return "verify:" + token
+}
+
+// appLink builds a frontend URL from APP_URL, or returns "" when it is not set
+func appLink(path string) string {
+	base := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		return ""
+	}
+	return base + path
+}
+
+// sendVerificationEmail issues a verification token for user and emails it.
+// Requires Redis to store the token.
+func (h *Handler) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	if h.redis == nil {
+		return fmt.Errorf("redis is not available")
+	}
+	token, err := auth.GenerateResetToken()
+	if err != nil {
+		return err
+	}
+	if err := h.redis.SetWithTTL(ctx, verificationTokenKey(token), []byte(user.ID.String()), verificationTokenTTL); err != nil {
+		return err
+	}
+
+	body := "Confirm your email address with this code: " + token
+	if link := appLink("/verify-email?token=" + token); link != "" {
+		body = "Confirm your email address by opening " + link
+	}
+	h.sendEmail(user.Email, "Verify your CollabDocs email address", body)
+	return nil
+}
+
+// VerifyEmail consumes a verification token and marks its user's email verified
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !auth.ValidResetTokenFormat(req.Token) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if h.redis == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email verification is not available"})
+		return
+	}
+
+	val, err := h.redis.GetDel(c.Request.Context(), verificationTokenKey(req.Token))
+	if err != nil {
+		logger.Error("VerifyEmail: failed to read verification token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	userID, err := uuid.ParseBytes(val)
+	if val == nil || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	if err := h.db.MarkEmailVerified(c.Request.Context(), userID); err != nil {
+		logger.Error("VerifyEmail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	logger.Info("[API] VerifyEmail: verified userID=%s", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification sends the current user a new verification email
+func (h *Handler) ResendVerification(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if auth.EmailVerified(user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+	if h.redis == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email verification is not available"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		logger.Error("ResendVerification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
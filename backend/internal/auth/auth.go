@@ -3,10 +3,13 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,28 +29,38 @@ const (
 	UserContextKey ContextKey = "user"
 	// PermissionContextKey is the key for storing permission in context
 	PermissionContextKey ContextKey = "permission"
+	// ClaimsContextKey is the key for storing the validated JWT claims in context
+	ClaimsContextKey ContextKey = "claims"
 )
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID string `json:"sub"`
-	Email  string `json:"email"`
-	Name   string `json:"name"`
+	UserID    string `json:"sub"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	TokenType string `json:"typ,omitempty"`   // TokenTypeAccess or TokenTypeRefresh; empty in older access tokens
+	ChainID   string `json:"chain,omitempty"` // Refresh tokens only: the rotation chain the token belongs to
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a JWT token for a user
-func GenerateToken(user *models.User) (string, error) {
+// jwtSecret returns the HMAC key for signing tokens
+func jwtSecret() []byte {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "local-dev-secret-change-in-production"
 	}
+	return []byte(secret)
+}
 
+// GenerateToken generates a JWT token for a user
+func GenerateToken(user *models.User) (string, error) {
 	claims := Claims{
-		UserID: user.ID.String(),
-		Email:  user.Email,
-		Name:   user.Name,
+		UserID:    user.ID.String(),
+		Email:     user.Email,
+		Name:      user.Name,
+		TokenType: TokenTypeAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "collab-docs",
@@ -55,21 +68,16 @@ func GenerateToken(user *models.User) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return token.SignedString(jwtSecret())
 }
 
 // ValidateToken validates a JWT token and returns claims
 func ValidateToken(tokenString string) (*Claims, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "local-dev-secret-change-in-production"
-	}
-
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("invalid signing method")
 		}
-		return []byte(secret), nil
+		return jwtSecret(), nil
 	})
 
 	if err != nil {
@@ -95,15 +103,106 @@ func CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateResetToken generates a random reset token
+// Password reset token limits
+const (
+	defaultResetTokenBytes = 32
+	minResetTokenBytes     = 16 // 128 bits of entropy
+	maxResetTokenBytes     = 64
+
+	defaultResetTokenTTL = time.Hour
+	minResetTokenTTL     = 5 * time.Minute
+	maxResetTokenTTL     = 24 * time.Hour
+)
+
+// ResetTokenBytes returns the number of random bytes in a reset token,
+// configurable via RESET_TOKEN_BYTES and clamped to a safe range
+func ResetTokenBytes() int {
+	n, err := strconv.Atoi(os.Getenv("RESET_TOKEN_BYTES"))
+	if err != nil {
+		return defaultResetTokenBytes
+	}
+	if n < minResetTokenBytes {
+		return minResetTokenBytes
+	}
+	if n > maxResetTokenBytes {
+		return maxResetTokenBytes
+	}
+	return n
+}
+
+// ResetTokenTTL returns how long a reset token stays valid, configurable via
+// RESET_TOKEN_TTL (e.g. "30m") and clamped to a safe range
+func ResetTokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("RESET_TOKEN_TTL"))
+	if err != nil {
+		return defaultResetTokenTTL
+	}
+	if ttl < minResetTokenTTL {
+		return minResetTokenTTL
+	}
+	if ttl > maxResetTokenTTL {
+		return maxResetTokenTTL
+	}
+	return ttl
+}
+
+// GenerateResetToken generates a random hex reset token of ResetTokenBytes bytes
 func GenerateResetToken() (string, error) {
-	bytes := make([]byte, 32)
+	bytes := make([]byte, ResetTokenBytes())
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(bytes), nil
 }
 
+// ValidResetTokenFormat reports whether a token could have been issued by
+// GenerateResetToken: lowercase hex carrying at least the minimum entropy
+func ValidResetTokenFormat(token string) bool {
+	if len(token) < 2*minResetTokenBytes || len(token) > 2*maxResetTokenBytes || len(token)%2 != 0 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateShareLinkToken generates a random URL-safe token for a document share link
+func GenerateShareLinkToken() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// APIKeyScheme is the Authorization scheme used for API keys ("ApiKey <key>")
+const APIKeyScheme = "ApiKey"
+
+const (
+	apiKeyTag       = "cdk_"
+	apiKeyPrefixLen = len(apiKeyTag) + 8
+)
+
+// GenerateAPIKey generates a random API key and returns the key, its display prefix and its hash
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyTag + hex.EncodeToString(bytes)
+	return key, key[:apiKeyPrefixLen], HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes an API key for storage and lookup.
+// Keys are high-entropy random values, so a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 // AuthMiddleware validates JWT tokens and sets user in context
 func AuthMiddleware(database *db.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -115,6 +214,25 @@ func AuthMiddleware(database *db.DB) gin.HandlerFunc {
 		}
 
 		parts := strings.Split(authHeader, " ")
+
+		// Long-lived API keys for programmatic access
+		if len(parts) == 2 && parts[0] == APIKeyScheme {
+			user, err := database.GetUserByAPIKeyHash(c.Request.Context(), HashAPIKey(parts[1]))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				c.Abort()
+				return
+			}
+			if user == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				c.Abort()
+				return
+			}
+			c.Set(string(UserContextKey), user)
+			c.Next()
+			return
+		}
+
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
 			c.Abort()
@@ -127,6 +245,22 @@ func AuthMiddleware(database *db.DB) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if claims.TokenType == TokenTypeRefresh {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh tokens cannot be used for API access"})
+			c.Abort()
+			return
+		}
+
+		switch err := CheckRevocation(c.Request.Context(), claims); err {
+		case ErrRevocationCheckFailed:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+			c.Abort()
+			return
+		case ErrTokenRevoked:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
 
 		userID, err := uuid.Parse(claims.UserID)
 		if err != nil {
@@ -149,22 +283,31 @@ func AuthMiddleware(database *db.DB) gin.HandlerFunc {
 		}
 
 		c.Set(string(UserContextKey), user)
+		c.Set(string(ClaimsContextKey), claims)
 		c.Next()
 	}
 }
 
+// IsDevEnv reports whether the server runs in development mode (APP_ENV=dev).
+// Development auth shortcuts are disabled in every other environment, including when APP_ENV is unset.
+func IsDevEnv() bool {
+	return strings.EqualFold(os.Getenv("APP_ENV"), "dev")
+}
+
 // DevAuthMiddleware is a simplified auth for local development
-// It accepts a user ID header for testing
+// It accepts a user ID header for testing (only when APP_ENV=dev)
 func DevAuthMiddleware(database *db.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// First try normal JWT auth
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" {
 			AuthMiddleware(database)(c)
-			if c.IsAborted() {
-				return
-			}
-			c.Next()
+			return
+		}
+
+		// Outside dev, X-User-ID is ignored and there is no default user
+		if !IsDevEnv() {
+			AuthMiddleware(database)(c)
 			return
 		}
 
@@ -209,6 +352,25 @@ func GetUserFromContext(c *gin.Context) *models.User {
 	return user.(*models.User)
 }
 
+// GetClaimsFromContext retrieves the JWT claims of the current request,
+// or nil when it was authenticated another way (API key, dev header)
+func GetClaimsFromContext(c *gin.Context) *Claims {
+	claims, exists := c.Get(string(ClaimsContextKey))
+	if !exists {
+		return nil
+	}
+	return claims.(*Claims)
+}
+
+// GetPermissionFromContext retrieves the document permission set by RequirePermission
+func GetPermissionFromContext(c *gin.Context) *models.DocumentPermission {
+	perm, exists := c.Get(string(PermissionContextKey))
+	if !exists {
+		return nil
+	}
+	return perm.(*models.DocumentPermission)
+}
+
 // GetUserFromStdContext retrieves user from standard context
 func GetUserFromStdContext(ctx context.Context) *models.User {
 	user := ctx.Value(UserContextKey)
@@ -218,8 +380,38 @@ func GetUserFromStdContext(ctx context.Context) *models.User {
 	return user.(*models.User)
 }
 
-// RequirePermission middleware checks if user has permission for a document
+// EmailVerified reports whether a user has confirmed their email address
+func EmailVerified(user *models.User) bool {
+	return user != nil && user.EmailVerified != nil && *user.EmailVerified
+}
+
+// RequireVerifiedEmail rejects users who have not verified their email address
+// with 403 when REQUIRE_EMAIL_VERIFICATION=true; otherwise it allows everyone
+func RequireVerifiedEmail() gin.HandlerFunc {
+	required, _ := strconv.ParseBool(os.Getenv("REQUIRE_EMAIL_VERIFICATION"))
+	return func(c *gin.Context) {
+		if required && !EmailVerified(GetUserFromContext(c)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address to do this"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission middleware checks if user has permission for a document.
+// Documents in the trash are rejected with 410.
 func RequirePermission(database *db.DB, minRole string) gin.HandlerFunc {
+	return requirePermission(database, minRole, false)
+}
+
+// RequireTrashedPermission is RequirePermission for routes that act on the
+// trash itself (restore): it also admits documents in the trash
+func RequireTrashedPermission(database *db.DB, minRole string) gin.HandlerFunc {
+	return requirePermission(database, minRole, true)
+}
+
+func requirePermission(database *db.DB, minRole string, allowTrashed bool) gin.HandlerFunc {
 	roleHierarchy := map[string]int{
 		models.RoleView:    1,
 		models.RoleComment: 2,
@@ -243,7 +435,7 @@ func RequirePermission(database *db.DB, minRole string) gin.HandlerFunc {
 			return
 		}
 
-		perm, err := database.GetPermission(c.Request.Context(), docID, user.ID)
+		perm, err := database.ResolveEffectivePermission(c.Request.Context(), docID, user.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			c.Abort()
@@ -262,6 +454,12 @@ func RequirePermission(database *db.DB, minRole string) gin.HandlerFunc {
 			return
 		}
 
+		if perm.Trashed && !allowTrashed {
+			c.JSON(http.StatusGone, gin.H{"error": "Document is in the trash"})
+			c.Abort()
+			return
+		}
+
 		c.Set(string(PermissionContextKey), perm)
 		c.Next()
 	}
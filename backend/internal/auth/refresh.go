@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/redis"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Token types carried in Claims.TokenType
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// refreshTokenTTL is how long a refresh token chain stays valid without being used
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrTokenStoreUnavailable is returned when refresh tokens are used without Redis
+	ErrTokenStoreUnavailable = errors.New("token store unavailable")
+	// ErrInvalidRefreshToken is returned for malformed, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented;
+	// the whole chain is revoked
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// tokenStore keeps refresh token state; nil when Redis is not available
+var tokenStore *redis.PubSub
+
+// SetTokenStore sets the Redis client used for refresh tokens; store may be nil
+func SetTokenStore(store *redis.PubSub) {
+	tokenStore = store
+}
+
+// refreshChainKey holds the ID of the only valid refresh token of a chain.
+// Every login starts a new chain; each refresh replaces the ID.
+func refreshChainKey(chainID string) string {
+	return "refresh:" + chainID
+}
+
+// userChainsKey indexes the refresh token chains of a user, so all of them can
+// be revoked when the password changes. It expires with the newest chain.
+func userChainsKey(userID string) string {
+	return "refresh_chains:" + userID
+}
+
+// GenerateRefreshToken starts a new refresh token chain for a user and returns its first token
+func GenerateRefreshToken(ctx context.Context, user *models.User) (string, error) {
+	return issueRefreshToken(ctx, user.ID.String(), user.Email, user.Name, uuid.NewString())
+}
+
+func issueRefreshToken(ctx context.Context, userID, email, name, chainID string) (string, error) {
+	if tokenStore == nil {
+		return "", ErrTokenStoreUnavailable
+	}
+
+	now := time.Now()
+	claims := Claims{
+		UserID:    userID,
+		Email:     email,
+		Name:      name,
+		TokenType: TokenTypeRefresh,
+		ChainID:   chainID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(refreshTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "collab-docs",
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret())
+	if err != nil {
+		return "", err
+	}
+	if err := tokenStore.SetWithTTL(ctx, refreshChainKey(chainID), []byte(claims.ID), refreshTokenTTL); err != nil {
+		return "", err
+	}
+	if err := tokenStore.AddToSet(ctx, userChainsKey(userID), chainID, refreshTokenTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken validates a refresh token, invalidates it and returns its
+// claims together with the next refresh token of the chain. Presenting a token
+// that was already rotated revokes the chain, so a stolen token can be used at
+// most once before both parties are logged out. On ErrRefreshTokenReused the
+// claims of the replayed token are still returned for auditing.
+func RotateRefreshToken(ctx context.Context, tokenString string) (*Claims, string, error) {
+	if tokenStore == nil {
+		return nil, "", ErrTokenStoreUnavailable
+	}
+
+	claims, err := ValidateToken(tokenString)
+	if err != nil || claims.TokenType != TokenTypeRefresh || claims.ChainID == "" || claims.ID == "" {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	// GetDel makes rotation atomic: concurrent refreshes with the same token
+	// cannot both succeed
+	current, err := tokenStore.GetDel(ctx, refreshChainKey(claims.ChainID))
+	if err != nil {
+		return nil, "", err
+	}
+	if current == nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if string(current) != claims.ID {
+		// The chain key is now deleted, which revokes every token in it
+		return claims, "", ErrRefreshTokenReused
+	}
+
+	next, err := issueRefreshToken(ctx, claims.UserID, claims.Email, claims.Name, claims.ChainID)
+	if err != nil {
+		return nil, "", err
+	}
+	return claims, next, nil
+}
+
+// RevokeRefreshToken ends the chain of a refresh token belonging to userID (on
+// logout), so neither it nor any other token of the chain can be used again.
+// Returns ErrInvalidRefreshToken for a token that is not a refresh token of that user.
+func RevokeRefreshToken(ctx context.Context, tokenString, userID string) error {
+	if tokenStore == nil {
+		return ErrTokenStoreUnavailable
+	}
+
+	claims, err := ValidateToken(tokenString)
+	if err != nil || claims.TokenType != TokenTypeRefresh || claims.ChainID == "" || claims.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+	return tokenStore.Delete(ctx, refreshChainKey(claims.ChainID))
+}
+
+// RevokeAllRefreshTokens ends every refresh token chain of a user, logging out
+// all sessions once their access tokens expire. Without Redis no refresh
+// tokens can have been issued, so there is nothing to revoke.
+func RevokeAllRefreshTokens(ctx context.Context, userID string) error {
+	if tokenStore == nil {
+		return nil
+	}
+
+	chains, err := tokenStore.SetMembers(ctx, userChainsKey(userID))
+	if err != nil {
+		return err
+	}
+	keys := []string{userChainsKey(userID)}
+	for _, chainID := range chains {
+		keys = append(keys, refreshChainKey(chainID))
+	}
+	return tokenStore.Delete(ctx, keys...)
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/redis"
+	"github.com/google/uuid"
+)
+
+// useTokenStore points the token store at TEST_REDIS_URL for one test,
+// skipping it when that is not set
+func useTokenStore(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	t.Setenv("REDIS_URL", url)
+	store, err := redis.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTokenStore(store)
+	t.Cleanup(func() {
+		SetTokenStore(nil)
+		store.Close()
+	})
+}
+
+func testUser(name string) *models.User {
+	return &models.User{ID: uuid.New(), Email: name + "@example.com", Name: name}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	useTokenStore(t)
+	ctx := context.Background()
+	alice, bob := testUser("alice"), testUser("bob")
+	t.Cleanup(func() {
+		RevokeAllRefreshTokens(context.Background(), alice.ID.String())
+		RevokeAllRefreshTokens(context.Background(), bob.ID.String())
+	})
+
+	session, err := GenerateRefreshToken(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSession, err := GenerateRefreshToken(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, session, err = RotateRefreshToken(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Somebody else cannot end the session, and an access token is no refresh token
+	if err := RevokeRefreshToken(ctx, session, bob.ID.String()); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("revoke as another user: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	access, err := GenerateToken(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeRefreshToken(ctx, access, alice.ID.String()); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("revoke an access token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	if err := RevokeRefreshToken(ctx, session, alice.ID.String()); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	if _, _, err := RotateRefreshToken(ctx, session); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh after logout: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	// Logging out ends only that session
+	if _, _, err := RotateRefreshToken(ctx, otherSession); err != nil {
+		t.Errorf("refresh of another session: %v", err)
+	}
+}
+
+func TestRevokeAllRefreshTokens(t *testing.T) {
+	useTokenStore(t)
+	ctx := context.Background()
+	alice, bob := testUser("alice"), testUser("bob")
+	t.Cleanup(func() { RevokeAllRefreshTokens(context.Background(), bob.ID.String()) })
+
+	var sessions []string
+	for i := 0; i < 3; i++ {
+		token, err := GenerateRefreshToken(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, token)
+	}
+	// A rotated chain is revoked too
+	_, rotated, err := RotateRefreshToken(ctx, sessions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions[0] = rotated
+	bobSession, err := GenerateRefreshToken(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeAllRefreshTokens(ctx, alice.ID.String()); err != nil {
+		t.Fatalf("RevokeAllRefreshTokens: %v", err)
+	}
+	for i, token := range sessions {
+		if _, _, err := RotateRefreshToken(ctx, token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("session %d: err = %v, want ErrInvalidRefreshToken", i, err)
+		}
+	}
+	if _, _, err := RotateRefreshToken(ctx, bobSession); err != nil {
+		t.Errorf("another user's session: %v", err)
+	}
+}
+
+func TestRevokeAllRefreshTokensWithoutRedis(t *testing.T) {
+	if err := RevokeAllRefreshTokens(context.Background(), uuid.NewString()); err != nil {
+		t.Errorf("RevokeAllRefreshTokens without a token store: %v", err)
+	}
+	if err := RevokeRefreshToken(context.Background(), "token", uuid.NewString()); !errors.Is(err, ErrTokenStoreUnavailable) {
+		t.Errorf("RevokeRefreshToken without a token store: err = %v, want ErrTokenStoreUnavailable", err)
+	}
+}
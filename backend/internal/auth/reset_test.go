@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateResetToken(t *testing.T) {
+	tests := []struct {
+		setting string
+		bytes   int
+	}{
+		{"", defaultResetTokenBytes},
+		{"20", 20},
+		{"8", minResetTokenBytes},
+		{"1000", maxResetTokenBytes},
+		{"many", defaultResetTokenBytes},
+	}
+	for _, tt := range tests {
+		t.Setenv("RESET_TOKEN_BYTES", tt.setting)
+		token, err := GenerateResetToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 2*tt.bytes {
+			t.Errorf("RESET_TOKEN_BYTES=%q: token length %d, want %d", tt.setting, len(token), 2*tt.bytes)
+		}
+		if strings.Trim(token, "0123456789abcdef") != "" {
+			t.Errorf("RESET_TOKEN_BYTES=%q: token %q is not lowercase hex", tt.setting, token)
+		}
+		if !ValidResetTokenFormat(token) {
+			t.Errorf("RESET_TOKEN_BYTES=%q: generated token fails ValidResetTokenFormat", tt.setting)
+		}
+	}
+
+	t.Setenv("RESET_TOKEN_BYTES", "")
+	a, _ := GenerateResetToken()
+	b, _ := GenerateResetToken()
+	if a == b {
+		t.Error("two generated tokens are equal")
+	}
+}
+
+func TestValidResetTokenFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"minimum length", strings.Repeat("a1", minResetTokenBytes), true},
+		{"maximum length", strings.Repeat("0f", maxResetTokenBytes), true},
+		{"too short", strings.Repeat("a1", minResetTokenBytes-1), false},
+		{"too long", strings.Repeat("a1", maxResetTokenBytes+1), false},
+		{"odd length", strings.Repeat("a", 2*minResetTokenBytes+1), false},
+		{"uppercase", strings.Repeat("A1", minResetTokenBytes), false},
+		{"not hex", strings.Repeat("zz", minResetTokenBytes), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		if got := ValidResetTokenFormat(tt.token); got != tt.want {
+			t.Errorf("%s: ValidResetTokenFormat = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResetTokenTTL(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    time.Duration
+	}{
+		{"", defaultResetTokenTTL},
+		{"30m", 30 * time.Minute},
+		{"1s", minResetTokenTTL},
+		{"720h", maxResetTokenTTL},
+		{"soon", defaultResetTokenTTL},
+	}
+	for _, tt := range tests {
+		t.Setenv("RESET_TOKEN_TTL", tt.setting)
+		if got := ResetTokenTTL(); got != tt.want {
+			t.Errorf("RESET_TOKEN_TTL=%q: ResetTokenTTL = %v, want %v", tt.setting, got, tt.want)
+		}
+	}
+}
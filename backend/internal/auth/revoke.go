@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/collab-docs/backend/internal/logger"
+)
+
+var (
+	// ErrTokenRevoked is returned for tokens on the revocation list
+	ErrTokenRevoked = errors.New("token has been revoked")
+	// ErrRevocationCheckFailed is returned when the revocation list cannot be
+	// read and TOKEN_REVOCATION_FAIL_MODE=closed
+	ErrRevocationCheckFailed = errors.New("unable to verify token")
+)
+
+// revokedTokenKey marks an access token ID (jti) as revoked until the token expires
+func revokedTokenKey(jti string) string {
+	return "revoked:" + jti
+}
+
+// revocationFailClosed reports whether requests are rejected when the revocation
+// list cannot be checked (TOKEN_REVOCATION_FAIL_MODE=closed). The default is to
+// fail open and accept the token.
+func revocationFailClosed() bool {
+	return strings.EqualFold(os.Getenv("TOKEN_REVOCATION_FAIL_MODE"), "closed")
+}
+
+// RevokeToken blacklists a token's jti for the rest of its lifetime, so it is
+// rejected by AuthMiddleware even though its signature is still valid
+func RevokeToken(ctx context.Context, claims *Claims) error {
+	if tokenStore == nil {
+		return ErrTokenStoreUnavailable
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		// Tokens issued before jti was added cannot be revoked individually
+		return nil
+	}
+
+	remaining := time.Until(claims.ExpiresAt.Time)
+	if remaining <= 0 {
+		return nil
+	}
+	return tokenStore.SetWithTTL(ctx, revokedTokenKey(claims.ID), []byte("1"), remaining)
+}
+
+// CheckRevocation returns ErrTokenRevoked if the token was revoked. When the
+// revocation list is unreachable it returns ErrRevocationCheckFailed in closed
+// fail mode and nil otherwise.
+func CheckRevocation(ctx context.Context, claims *Claims) error {
+	revoked, err := IsTokenRevoked(ctx, claims)
+	if err != nil {
+		if revocationFailClosed() {
+			return ErrRevocationCheckFailed
+		}
+		logger.Warn("[Auth] token revocation check failed, allowing request: %v", err)
+		return nil
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether a token's jti is on the revocation list.
+// Without Redis nothing can have been revoked, so it returns false.
+func IsTokenRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if tokenStore == nil || claims.ID == "" {
+		return false, nil
+	}
+	val, err := tokenStore.Get(ctx, revokedTokenKey(claims.ID))
+	if err != nil {
+		return false, err
+	}
+	return val != nil, nil
+}
@@ -0,0 +1,77 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+	"github.com/google/uuid"
+)
+
+func TestListActivityFeed(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+
+	user := dbtest.User(t, database, "User")
+	other := dbtest.User(t, database, "Other")
+	collaborator := dbtest.User(t, database, "Collaborator")
+
+	owned := dbtest.Document(t, database, user.ID, "Owned")
+	dbtest.Exec(t, `DELETE FROM document_permissions WHERE doc_id = $1`, owned.ID)
+	shared := dbtest.Document(t, database, other.ID, "Shared")
+	dbtest.Grant(t, database, shared.ID, user.ID, models.RoleView)
+	private := dbtest.Document(t, database, other.ID, "Private")
+	trashed := dbtest.Document(t, database, user.ID, "Trashed")
+
+	snapshot := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "Hi"})
+	for _, doc := range []*models.Document{owned, shared, private, trashed} {
+		if _, err := database.SaveSnapshot(ctx, doc.ID, snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.CreateComment(ctx, doc.ID, other.ID, "Note", nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.SoftDeleteDocument(ctx, trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A role change is dated by the change, not by the original grant
+	dbtest.Grant(t, database, owned.ID, collaborator.ID, models.RoleView)
+	dbtest.Exec(t, `UPDATE document_permissions SET created_at = NOW() - INTERVAL '1 hour', updated_at = NOW() - INTERVAL '1 hour' WHERE doc_id = $1`, owned.ID)
+	dbtest.Grant(t, database, owned.ID, collaborator.ID, models.RoleEdit)
+
+	events, err := database.ListActivityFeed(ctx, user.ID, 50, 0)
+	if err != nil {
+		t.Fatalf("ListActivityFeed: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatal("empty feed")
+	}
+	first := events[0]
+	if first.Type != models.ActivityPermission || first.DocID != owned.ID || first.Detail != models.RoleEdit {
+		t.Errorf("newest event = %+v, want the role change to edit on %s", first, owned.ID)
+	}
+
+	counts := make(map[uuid.UUID]map[string]int)
+	for _, e := range events {
+		if counts[e.DocID] == nil {
+			counts[e.DocID] = make(map[string]int)
+		}
+		counts[e.DocID][e.Type]++
+	}
+	for _, doc := range []*models.Document{private, trashed} {
+		if len(counts[doc.ID]) != 0 {
+			t.Errorf("feed has events %v for inaccessible document %q", counts[doc.ID], doc.Title)
+		}
+	}
+	for _, doc := range []*models.Document{owned, shared} {
+		for _, typ := range []string{models.ActivitySnapshot, models.ActivityComment, models.ActivityPermission} {
+			if counts[doc.ID][typ] == 0 {
+				t.Errorf("feed has no %s event for %q", typ, doc.Title)
+			}
+		}
+	}
+}
@@ -1,15 +1,23 @@
 package db
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/collab-docs/backend/internal/logger"
 	"github.com/collab-docs/backend/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -54,15 +62,60 @@ func (db *DB) Close() {
 	db.pool.Close()
 }
 
+// Read retries: behind PgBouncer a pooled connection can be dropped between
+// queries, so idempotent reads get a couple of quick retries
+const (
+	readRetryAttempts = 3
+	readRetryBackoff  = 50 * time.Millisecond
+)
+
+// isTransientError reports whether err is a connection-level failure that is safe to retry
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 = connection exception, 57P01 = admin shutdown (e.g. pooler restart)
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01"
+	}
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// withReadRetry runs an idempotent read query, retrying on transient errors.
+// Must not be used for writes.
+func withReadRetry(ctx context.Context, query func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = query()
+		if attempt >= readRetryAttempts || !isTransientError(err) {
+			return err
+		}
+		logger.Info("[DB] transient error on read (attempt %d/%d), retrying: %v", attempt, readRetryAttempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * readRetryBackoff):
+		}
+	}
+}
+
 // User operations
 
 // GetUser retrieves a user by ID
 func (db *DB) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	var user models.User
-	err := db.pool.QueryRow(ctx, `
-		SELECT id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), created_at, updated_at
-		FROM users WHERE id = $1
-	`, id).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+	err := withReadRetry(ctx, func() error {
+		return db.pool.QueryRow(ctx, `
+			SELECT id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), email_verified, created_at, updated_at
+			FROM users WHERE id = $1
+		`, id).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+	})
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -72,14 +125,57 @@ func (db *DB) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	return &user, nil
 }
 
+// LookupVisibleUsers returns the profiles of the given users that viewerID may
+// see: the viewer, and anyone who owns, has a permission on or commented on a
+// document the viewer can access. Unknown and unrelated IDs are omitted.
+func (db *DB) LookupVisibleUsers(ctx context.Context, viewerID uuid.UUID, userIDs []uuid.UUID) ([]*models.User, error) {
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		WITH my_docs AS (
+			SELECT doc_id FROM document_permissions WHERE user_id = $1
+			UNION
+			SELECT id FROM documents WHERE owner_id = $1
+		)
+		SELECT u.id, u.email, u.name, COALESCE(u.avatar_url, ''), u.created_at, u.updated_at
+		FROM users u
+		WHERE u.id = ANY($2::uuid[])
+		  AND (
+			u.id = $1
+			OR EXISTS (SELECT 1 FROM document_permissions dp JOIN my_docs m ON m.doc_id = dp.doc_id WHERE dp.user_id = u.id)
+			OR EXISTS (SELECT 1 FROM documents d JOIN my_docs m ON m.doc_id = d.id WHERE d.owner_id = u.id)
+			OR EXISTS (SELECT 1 FROM comments c JOIN my_docs m ON m.doc_id = c.doc_id WHERE c.user_id = u.id)
+		  )
+	`, viewerID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.AvatarURL, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	return users, nil
+}
+
 // GetUserByEmail retrieves a user by email
 func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	logger.Info("[DB] GetUserByEmail: querying email=%s", email)
 	var user models.User
-	err := db.pool.QueryRow(ctx, `
-		SELECT id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), created_at, updated_at
-		FROM users WHERE email = $1
-	`, email).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+	err := withReadRetry(ctx, func() error {
+		return db.pool.QueryRow(ctx, `
+			SELECT id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), email_verified, created_at, updated_at
+			FROM users WHERE email = $1
+		`, email).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+	})
 	if err == pgx.ErrNoRows {
 		logger.Info("[DB] GetUserByEmail: no user found for email=%s", email)
 		return nil, nil
@@ -98,8 +194,8 @@ func (db *DB) CreateUser(ctx context.Context, email, name string) (*models.User,
 	err := db.pool.QueryRow(ctx, `
 		INSERT INTO users (email, name)
 		VALUES ($1, $2)
-		RETURNING id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), created_at, updated_at
-	`, email, name).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+		RETURNING id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), email_verified, created_at, updated_at
+	`, email, name).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -112,14 +208,23 @@ func (db *DB) CreateUserWithPassword(ctx context.Context, email, name, passwordH
 	err := db.pool.QueryRow(ctx, `
 		INSERT INTO users (email, name, password_hash)
 		VALUES ($1, $2, $3)
-		RETURNING id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), created_at, updated_at
-	`, email, name, passwordHash).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+		RETURNING id, email, COALESCE(password_hash, ''), name, COALESCE(avatar_url, ''), email_verified, created_at, updated_at
+	`, email, name, passwordHash).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// MarkEmailVerified records that a user confirmed their email address
+func (db *DB) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE users SET email_verified = TRUE, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	return err
+}
+
 // UpdateUserPassword updates a user's password
 func (db *DB) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
 	_, err := db.pool.Exec(ctx, `
@@ -129,20 +234,240 @@ func (db *DB) UpdateUserPassword(ctx context.Context, userID uuid.UUID, password
 	return err
 }
 
+// UpdateLastLogin records the time of a user's latest successful login
+func (db *DB) UpdateLastLogin(ctx context.Context, userID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE users SET last_login_at = NOW()
+		WHERE id = $1
+	`, userID)
+	return err
+}
+
+// UpdateLastActive records that a user's session was refreshed, which counts as
+// activity for ListInactiveUsers without being a login
+func (db *DB) UpdateLastActive(ctx context.Context, userID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE users SET last_active_at = NOW()
+		WHERE id = $1
+	`, userID)
+	return err
+}
+
+// ListInactiveUsers returns users with no activity within the given period:
+// no login, token refresh or API key use, and no owned document updated. Users
+// without any of these are measured from their registration time. Owners of
+// documents shared with other users are never listed, since deleting them
+// would delete those documents for everyone.
+func (db *DB) ListInactiveUsers(ctx context.Context, olderThan time.Duration) ([]*models.User, error) {
+	cutoff := time.Now().Add(-olderThan)
+	rows, err := db.pool.Query(ctx, `
+		SELECT u.id, u.email, u.name, COALESCE(u.avatar_url, ''), u.last_login_at, u.created_at, u.updated_at
+		FROM users u
+		CROSS JOIN LATERAL (
+			SELECT GREATEST(
+				u.created_at, u.last_login_at, u.last_active_at,
+				(SELECT MAX(k.last_used_at) FROM api_keys k WHERE k.user_id = u.id)
+			) AS at
+		) last_active
+		WHERE last_active.at < $1
+		  AND NOT EXISTS (
+			SELECT 1 FROM documents d
+			WHERE d.owner_id = u.id AND d.updated_at >= $1
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM documents d
+			JOIN document_permissions dp ON dp.doc_id = d.id AND dp.user_id <> u.id
+			WHERE d.owner_id = u.id
+		  )
+		ORDER BY last_active.at ASC
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+// DeleteUser permanently deletes a user (cascades to owned documents and folders)
+func (db *DB) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	return err
+}
+
+// API key operations
+
+// CreateAPIKey stores a new API key hash for a user
+func (db *DB) CreateAPIKey(ctx context.Context, userID uuid.UUID, name, prefix, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := db.pool.QueryRow(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, name, prefix, last_used_at, created_at
+	`, userID, name, prefix, keyHash).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.LastUsedAt, &key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns all API keys of a user (without hashes)
+func (db *DB) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, name, prefix, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.LastUsedAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey revokes one of a user's API keys. Returns false if no such key exists.
+func (db *DB) DeleteAPIKey(ctx context.Context, id, userID uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetUserByAPIKeyHash resolves an API key hash to its owning user and records its use
+func (db *DB) GetUserByAPIKeyHash(ctx context.Context, keyHash string) (*models.User, error) {
+	var user models.User
+	err := db.pool.QueryRow(ctx, `
+		UPDATE api_keys k SET last_used_at = NOW()
+		FROM users u
+		WHERE k.key_hash = $1 AND u.id = k.user_id
+		RETURNING u.id, u.email, COALESCE(u.password_hash, ''), u.name, COALESCE(u.avatar_url, ''), u.email_verified, u.created_at, u.updated_at
+	`, keyHash).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // Document operations
 
-// ListDocuments returns documents accessible by a user
-func (db *DB) ListDocuments(ctx context.Context, userID uuid.UUID) ([]*models.Document, error) {
+// ListDocuments returns a page of the documents accessible by a user, most
+// recently updated first, and the total number of accessible documents
+func (db *DB) ListDocuments(ctx context.Context, userID uuid.UUID, role RoleFilter, limit, offset int) ([]*models.Document, int, error) {
+	var total int
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM documents d
+		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+		WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		  AND `+roleFilterSQL(2, 3)+`
+	`, userID, role.Role, role.AtLeast).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	rows, err := db.pool.Query(ctx, `
 		SELECT d.id, d.title, d.owner_id, d.created_at, d.updated_at,
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
+		       COALESCE(dp.role, 'view') as permission,
+		       f.doc_id IS NOT NULL as starred
+		FROM documents d
+		JOIN users u ON d.owner_id = u.id
+		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+		LEFT JOIN favorites f ON d.id = f.doc_id AND f.user_id = $1
+		WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		  AND `+roleFilterSQL(4, 5)+`
+		ORDER BY d.updated_at DESC, d.id
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset, role.Role, role.AtLeast)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var docs []*models.Document
+	for rows.Next() {
+		var doc models.Document
+		var owner models.User
+		err := rows.Scan(
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.CreatedAt, &doc.UpdatedAt,
+			&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
+			&doc.Permission, &doc.Starred,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		doc.Owner = &owner
+		docs = append(docs, &doc)
+	}
+	return docs, total, nil
+}
+
+// RoleFilter restricts document listings to the caller's permission role:
+// exactly Role, or Role and above when AtLeast is set. An empty Role matches all.
+type RoleFilter struct {
+	Role    string
+	AtLeast bool
+}
+
+// roleRankSQL ranks a role expression like auth.RequirePermission does
+func roleRankSQL(expr string) string {
+	return fmt.Sprintf("(CASE %s WHEN 'owner' THEN 4 WHEN 'edit' THEN 3 WHEN 'comment' THEN 2 ELSE 1 END)", expr)
+}
+
+// roleFilterSQL is the listing condition for a RoleFilter whose Role and
+// AtLeast are bound to the given parameter numbers
+func roleFilterSQL(roleArg, atLeastArg int) string {
+	role := fmt.Sprintf("$%d::text", roleArg)
+	permission := "COALESCE(dp.role, 'view')"
+	return fmt.Sprintf("(%s = '' OR CASE WHEN $%d::boolean THEN %s >= %s ELSE %s = %s END)",
+		role, atLeastArg, roleRankSQL(permission), roleRankSQL(role), permission, role)
+}
+
+// SearchDocuments finds accessible documents whose title contains the query
+// (case-insensitive) or matches it as words, best matches first
+func (db *DB) SearchDocuments(ctx context.Context, userID uuid.UUID, query string, limit int) ([]*models.Document, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := db.pool.Query(ctx, `
+		SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at,
 		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
 		       COALESCE(dp.role, 'view') as permission
 		FROM documents d
 		JOIN users u ON d.owner_id = u.id
 		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
-		WHERE d.owner_id = $1 OR dp.user_id = $1
-		ORDER BY d.updated_at DESC
-	`, userID)
+		WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		  AND (d.title ILIKE $2 OR to_tsvector('simple', d.title) @@ plainto_tsquery('simple', $3))
+		ORDER BY ts_rank(to_tsvector('simple', d.title), plainto_tsquery('simple', $3)) DESC,
+		         d.updated_at DESC, d.id
+		LIMIT $4
+	`, userID, pattern, query, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -153,7 +478,7 @@ func (db *DB) ListDocuments(ctx context.Context, userID uuid.UUID) ([]*models.Do
 		var doc models.Document
 		var owner models.User
 		err := rows.Scan(
-			&doc.ID, &doc.Title, &doc.OwnerID, &doc.CreatedAt, &doc.UpdatedAt,
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.CreatedAt, &doc.UpdatedAt,
 			&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
 			&doc.Permission,
 		)
@@ -166,20 +491,25 @@ func (db *DB) ListDocuments(ctx context.Context, userID uuid.UUID) ([]*models.Do
 	return docs, nil
 }
 
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 // GetDocument retrieves a document by ID
 func (db *DB) GetDocument(ctx context.Context, id uuid.UUID) (*models.Document, error) {
 	var doc models.Document
 	var owner models.User
-	err := db.pool.QueryRow(ctx, `
-		SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at,
-		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
-		FROM documents d
-		JOIN users u ON d.owner_id = u.id
-		WHERE d.id = $1
-	`, id).Scan(
-		&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.CreatedAt, &doc.UpdatedAt,
-		&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
-	)
+	err := withReadRetry(ctx, func() error {
+		return db.pool.QueryRow(ctx, `
+			SELECT d.id, d.title, d.owner_id, d.folder_id, d.deleted_at, d.created_at, d.updated_at,
+			       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
+			FROM documents d
+			JOIN users u ON d.owner_id = u.id
+			WHERE d.id = $1
+		`, id).Scan(
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.DeletedAt, &doc.CreatedAt, &doc.UpdatedAt,
+			&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
+		)
+	})
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -190,6 +520,28 @@ func (db *DB) GetDocument(ctx context.Context, id uuid.UUID) (*models.Document,
 	return &doc, nil
 }
 
+// GetDocumentAccess reports whether a document exists and the user's role on it
+// ("" if the user has no permission), without loading any document data
+func (db *DB) GetDocumentAccess(ctx context.Context, docID, userID uuid.UUID) (bool, string, error) {
+	var role *string
+	err := db.pool.QueryRow(ctx, `
+		SELECT dp.role
+		FROM documents d
+		LEFT JOIN document_permissions dp ON dp.doc_id = d.id AND dp.user_id = $2
+		WHERE d.id = $1 AND d.deleted_at IS NULL
+	`, docID, userID).Scan(&role)
+	if err == pgx.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if role == nil {
+		return true, "", nil
+	}
+	return true, *role, nil
+}
+
 // CreateDocument creates a new document
 func (db *DB) CreateDocument(ctx context.Context, title string, ownerID uuid.UUID) (*models.Document, error) {
 	logger.Info("[DB] CreateDocument: starting, title=%s, ownerID=%s", title, ownerID)
@@ -230,6 +582,7 @@ func (db *DB) CreateDocument(ctx context.Context, title string, ownerID uuid.UUI
 	}
 
 	logger.Info("[DB] CreateDocument: success, docID=%s", doc.ID)
+	doc.Permission = models.RoleOwner
 	return &doc, nil
 }
 
@@ -269,8 +622,8 @@ func (db *DB) CreateDocumentWithInitialContent(ctx context.Context, title string
 
 	if len(welcomeSnapshot) > 0 {
 		_, err = tx.Exec(ctx, `
-			INSERT INTO doc_snapshots (doc_id, version, snapshot)
-			VALUES ($1, 1, $2)
+			INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+			VALUES ($1, 1, $2, sha256($2))
 		`, doc.ID, welcomeSnapshot)
 		if err != nil {
 			// Log error but don't fail - document is still created
@@ -282,6 +635,55 @@ func (db *DB) CreateDocumentWithInitialContent(ctx context.Context, title string
 		return nil, err
 	}
 
+	doc.Permission = models.RoleOwner
+	return &doc, nil
+}
+
+// DuplicateDocument copies a document's latest content into a new document at
+// the root of newOwnerID's tree, with the snapshot restarting at version 1.
+// Comments, permissions and snapshot history are not copied.
+func (db *DB) DuplicateDocument(ctx context.Context, srcDocID, newOwnerID uuid.UUID, newTitle string) (*models.Document, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var doc models.Document
+	err = tx.QueryRow(ctx, `
+		INSERT INTO documents (title, owner_id)
+		VALUES ($1, $2)
+		RETURNING id, title, owner_id, created_at, updated_at
+	`, newTitle, newOwnerID).Scan(&doc.ID, &doc.Title, &doc.OwnerID, &doc.CreatedAt, &doc.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO document_permissions (doc_id, user_id, role)
+		VALUES ($1, $2, 'owner')
+	`, doc.ID, newOwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+		SELECT $1, 1, snapshot, checksum
+		FROM doc_snapshots
+		WHERE doc_id = $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, doc.ID, srcDocID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	doc.Permission = models.RoleOwner
 	return &doc, nil
 }
 
@@ -314,22 +716,115 @@ func (db *DB) UpdateDocument(ctx context.Context, id uuid.UUID, title string) (*
 	return &doc, nil
 }
 
-// DeleteDocument deletes a document
+// DeleteDocument permanently deletes a document (cascades to snapshots and comments)
 func (db *DB) DeleteDocument(ctx context.Context, id uuid.UUID) error {
 	_, err := db.pool.Exec(ctx, `DELETE FROM documents WHERE id = $1`, id)
 	return err
 }
 
+// TrashRetention is how long trashed documents stay restorable before PurgeTrash may remove them
+const TrashRetention = 30 * 24 * time.Hour
+
+// SoftDeleteDocument moves a document to the trash
+func (db *DB) SoftDeleteDocument(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE documents SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	return err
+}
+
+// RestoreDocument takes a document out of the trash. Returns false if it was not trashed.
+func (db *DB) RestoreDocument(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE documents SET deleted_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListTrashedDocuments returns the user's trashed documents that are still within
+// the retention period, most recently deleted first
+func (db *DB) ListTrashedDocuments(ctx context.Context, userID uuid.UUID) ([]*models.Document, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, title, owner_id, folder_id, deleted_at, created_at, updated_at
+		FROM documents
+		WHERE owner_id = $1 AND deleted_at IS NOT NULL AND deleted_at > $2
+		ORDER BY deleted_at DESC
+	`, userID, time.Now().Add(-TrashRetention))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*models.Document
+	for rows.Next() {
+		var doc models.Document
+		err := rows.Scan(
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.DeletedAt, &doc.CreatedAt, &doc.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+	return docs, nil
+}
+
+// PurgeTrash permanently deletes documents that have been in the trash longer than olderThan
+func (db *DB) PurgeTrash(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		DELETE FROM documents
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+	`, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 // Permission operations
 
 // GetPermission retrieves a user's permission for a document
 func (db *DB) GetPermission(ctx context.Context, docID, userID uuid.UUID) (*models.DocumentPermission, error) {
 	var perm models.DocumentPermission
-	err := db.pool.QueryRow(ctx, `
-		SELECT doc_id, user_id, role, created_at
-		FROM document_permissions
-		WHERE doc_id = $1 AND user_id = $2
-	`, docID, userID).Scan(&perm.DocID, &perm.UserID, &perm.Role, &perm.CreatedAt)
+	err := withReadRetry(ctx, func() error {
+		return db.pool.QueryRow(ctx, `
+			SELECT doc_id, user_id, role, created_at
+			FROM document_permissions
+			WHERE doc_id = $1 AND user_id = $2
+		`, docID, userID).Scan(&perm.DocID, &perm.UserID, &perm.Role, &perm.CreatedAt)
+	})
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &perm, nil
+}
+
+// ResolveEffectivePermission returns the highest role a user holds on a
+// document, or nil if none. Sources are the direct permission row (which is
+// also where redeemed share links are granted) and document ownership, so an
+// owner whose owner row is missing still resolves to owner. There is no
+// anonymous guest access to consider. Trashed documents resolve as well, with
+// Trashed set.
+func (db *DB) ResolveEffectivePermission(ctx context.Context, docID, userID uuid.UUID) (*models.DocumentPermission, error) {
+	var perm models.DocumentPermission
+	err := withReadRetry(ctx, func() error {
+		return db.pool.QueryRow(ctx, `
+			SELECT d.id, $2::uuid,
+			       CASE WHEN d.owner_id = $2 THEN 'owner' ELSE dp.role END,
+			       COALESCE(dp.created_at, d.created_at), d.deleted_at IS NOT NULL
+			FROM documents d
+			LEFT JOIN document_permissions dp ON dp.doc_id = d.id AND dp.user_id = $2
+			WHERE d.id = $1 AND (d.owner_id = $2 OR dp.user_id IS NOT NULL)
+		`, docID, userID).Scan(&perm.DocID, &perm.UserID, &perm.Role, &perm.CreatedAt, &perm.Trashed)
+	})
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -390,27 +885,239 @@ func (db *DB) RemovePermission(ctx context.Context, docID, userID uuid.UUID) err
 	return err
 }
 
-// Snapshot operations
+// PermissionGrant is one entry of a bulk permission update
+type PermissionGrant struct {
+	UserID uuid.UUID
+	Role   string
+}
 
-// GetLatestSnapshot retrieves the latest snapshot for a document
-func (db *DB) GetLatestSnapshot(ctx context.Context, docID uuid.UUID) (*models.DocSnapshot, error) {
-	var snapshot models.DocSnapshot
-	err := db.pool.QueryRow(ctx, `
-		SELECT doc_id, version, snapshot, created_at
-		FROM doc_snapshots
-		WHERE doc_id = $1
-		ORDER BY version DESC
-		LIMIT 1
-	`, docID).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &snapshot.CreatedAt)
+// ErrUnknownUser is returned when a permission is granted to a user that does not exist
+var ErrUnknownUser = errors.New("user does not exist")
+
+// SetPermissionsBulk upserts several permissions in one transaction; if any
+// entry fails none are applied. The owner's own permission is never changed.
+// A user listed more than once gets the last role given.
+func (db *DB) SetPermissionsBulk(ctx context.Context, docID uuid.UUID, grants []PermissionGrant) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, g := range grants {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO document_permissions (doc_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (doc_id, user_id) DO UPDATE SET role = EXCLUDED.role
+			WHERE document_permissions.role != 'owner'
+		`, docID, g.UserID, g.Role)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation
+			return fmt.Errorf("%w: %s", ErrUnknownUser, g.UserID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ErrAlreadyOwner is returned when ownership is transferred to the current owner
+var ErrAlreadyOwner = errors.New("user already owns the document")
+
+// TransferOwnership makes newOwnerID the owner of a document. The previous
+// owner is demoted to edit. The document leaves the previous owner's folder,
+// since folders are private to their owner.
+func (db *DB) TransferOwnership(ctx context.Context, docID, newOwnerID uuid.UUID) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldOwnerID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT owner_id FROM documents WHERE id = $1 FOR UPDATE
+	`, docID).Scan(&oldOwnerID)
+	if err != nil {
+		return err
+	}
+	if oldOwnerID == newOwnerID {
+		return ErrAlreadyOwner
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE documents SET owner_id = $2, folder_id = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, docID, newOwnerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO document_permissions (doc_id, user_id, role)
+		VALUES ($1, $2, 'edit'), ($1, $3, 'owner')
+		ON CONFLICT (doc_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, docID, oldOwnerID, newOwnerID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Snapshot operations
+
+// snapshotFallbackDepth is how many versions GetLatestSnapshot inspects for one
+// whose checksum still matches
+const snapshotFallbackDepth = 10
+
+// ErrSnapshotCorrupt is returned when a requested snapshot, or every recent
+// snapshot of a document, does not match its checksum
+var ErrSnapshotCorrupt = errors.New("no snapshot with a valid checksum")
+
+// GetLatestSnapshot retrieves the latest snapshot for a document whose SHA-256
+// checksum matches its bytes. A corrupted version is logged and skipped in
+// favor of the one before it. Rows saved before checksums were added have none
+// and are trusted.
+func (db *DB) GetLatestSnapshot(ctx context.Context, docID uuid.UUID) (*models.DocSnapshot, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT doc_id, version, snapshot, checksum, created_at
+		FROM doc_snapshots
+		WHERE doc_id = $1
+		ORDER BY version DESC
+		LIMIT $2
+	`, docID, snapshotFallbackDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		found = true
+		var snapshot models.DocSnapshot
+		var checksum []byte
+		if err := rows.Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &checksum, &snapshot.CreatedAt); err != nil {
+			return nil, err
+		}
+		if sum := sha256.Sum256(snapshot.Snapshot); checksum != nil && !bytes.Equal(sum[:], checksum) {
+			logger.Error("[DB] snapshot checksum mismatch docID=%s, version=%d, falling back to the previous version", docID, snapshot.Version)
+			continue
+		}
+		return &snapshot, nil
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, ErrSnapshotCorrupt
+	}
+	return nil, nil
+}
+
+// GetSnapshotByVersion retrieves one snapshot of a document including its bytes.
+// Unlike GetLatestSnapshot there is no other version to fall back to, so a
+// checksum mismatch fails with ErrSnapshotCorrupt.
+func (db *DB) GetSnapshotByVersion(ctx context.Context, docID uuid.UUID, version int) (*models.DocSnapshot, error) {
+	var snapshot models.DocSnapshot
+	var checksum []byte
+	err := db.pool.QueryRow(ctx, `
+		SELECT doc_id, version, snapshot, checksum, created_at
+		FROM doc_snapshots
+		WHERE doc_id = $1 AND version = $2
+	`, docID, version).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &checksum, &snapshot.CreatedAt)
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	if sum := sha256.Sum256(snapshot.Snapshot); checksum != nil && !bytes.Equal(sum[:], checksum) {
+		logger.Error("[DB] snapshot checksum mismatch docID=%s, version=%d", docID, version)
+		return nil, ErrSnapshotCorrupt
+	}
 	return &snapshot, nil
 }
 
+// GetLatestSnapshotVersions returns the latest snapshot version of each given
+// document the user owns or has a permission on. Accessible documents without
+// snapshots map to version 0; inaccessible and trashed documents are omitted.
+func (db *DB) GetLatestSnapshotVersions(ctx context.Context, userID uuid.UUID, docIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	ids := make([]string, len(docIDs))
+	for i, id := range docIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT d.id, COALESCE((SELECT MAX(s.version) FROM doc_snapshots s WHERE s.doc_id = d.id), 0)
+		FROM documents d
+		LEFT JOIN document_permissions dp ON dp.doc_id = d.id AND dp.user_id = $1
+		WHERE d.id = ANY($2::uuid[]) AND (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+	`, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var docID uuid.UUID
+		var version int
+		if err := rows.Scan(&docID, &version); err != nil {
+			return nil, err
+		}
+		versions[docID] = version
+	}
+	return versions, nil
+}
+
+// ErrSnapshotUnchanged is returned by SaveSnapshot and SaveSnapshotBase64 when
+// the data is identical to the latest version, in which case nothing is written
+var ErrSnapshotUnchanged = errors.New("snapshot unchanged")
+
+// ErrDocumentTrashed is returned by SaveSnapshot and SaveSnapshotBase64 when the
+// document is in the trash
+var ErrDocumentTrashed = errors.New("document is in the trash")
+
+// lockUntrashedDocument locks the document row for the rest of tx so it cannot
+// be trashed concurrently, and fails with ErrDocumentTrashed if it already is
+func lockUntrashedDocument(ctx context.Context, tx pgx.Tx, docID uuid.UUID) error {
+	var trashed bool
+	err := tx.QueryRow(ctx, `
+		SELECT deleted_at IS NOT NULL FROM documents WHERE id = $1 FOR UPDATE
+	`, docID).Scan(&trashed)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if trashed {
+		return ErrDocumentTrashed
+	}
+	return nil
+}
+
+// latestChecksumMatches reports whether the latest snapshot of docID has the
+// checksum that sumSQL computes from data ($2)
+func latestChecksumMatches(ctx context.Context, tx pgx.Tx, docID uuid.UUID, sumSQL string, data interface{}) (bool, error) {
+	var match bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM (
+				SELECT checksum FROM doc_snapshots
+				WHERE doc_id = $1
+				ORDER BY version DESC
+				LIMIT 1
+			) latest
+			WHERE latest.checksum = `+sumSQL+`
+		)
+	`, docID, data).Scan(&match)
+	return match, err
+}
+
 // SaveSnapshot saves a new snapshot for a document and updates document's updated_at
 func (db *DB) SaveSnapshot(ctx context.Context, docID uuid.UUID, data []byte) (*models.DocSnapshot, error) {
 	// Start a transaction to update both snapshot and document
@@ -420,247 +1127,1116 @@ func (db *DB) SaveSnapshot(ctx context.Context, docID uuid.UUID, data []byte) (*
 	}
 	defer tx.Rollback(ctx)
 
+	if err := lockUntrashedDocument(ctx, tx, docID); err != nil {
+		return nil, err
+	}
+
+	unchanged, err := latestChecksumMatches(ctx, tx, docID, "sha256($2)", data)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		return nil, ErrSnapshotUnchanged
+	}
+
 	var snapshot models.DocSnapshot
 	err = tx.QueryRow(ctx, `
-		INSERT INTO doc_snapshots (doc_id, version, snapshot)
-		SELECT $1, COALESCE(MAX(version), 0) + 1, $2
+		INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, sha256($2)
 		FROM doc_snapshots WHERE doc_id = $1
 		RETURNING doc_id, version, snapshot, created_at
 	`, docID, data).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &snapshot.CreatedAt)
 	if err != nil {
-		return nil, err
+		return nil, err
+	}
+
+	// Update document's updated_at timestamp
+	_, err = tx.Exec(ctx, `UPDATE documents SET updated_at = NOW() WHERE id = $1`, docID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// RestoreSnapshot copies the content of an earlier snapshot into a new latest
+// version, keeping every version in between. Returns nil if the version does not
+// exist and ErrSnapshotCorrupt if it does not match its checksum.
+func (db *DB) RestoreSnapshot(ctx context.Context, docID uuid.UUID, version int) (*models.DocSnapshot, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var intact bool
+	err = tx.QueryRow(ctx, `
+		SELECT checksum IS NULL OR checksum = sha256(snapshot)
+		FROM doc_snapshots
+		WHERE doc_id = $1 AND version = $2
+	`, docID, version).Scan(&intact)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !intact {
+		logger.Error("[DB] snapshot checksum mismatch docID=%s, version=%d, not restoring it", docID, version)
+		return nil, ErrSnapshotCorrupt
+	}
+
+	var snapshot models.DocSnapshot
+	err = tx.QueryRow(ctx, `
+		INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+		SELECT s.doc_id, (SELECT MAX(version) + 1 FROM doc_snapshots WHERE doc_id = $1), s.snapshot, s.checksum
+		FROM doc_snapshots s
+		WHERE s.doc_id = $1 AND s.version = $2
+		RETURNING doc_id, version, created_at
+	`, docID, version).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE documents SET updated_at = NOW() WHERE id = $1`, docID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ListSnapshots returns all snapshots for a document
+func (db *DB) ListSnapshots(ctx context.Context, docID uuid.UUID) ([]*models.DocSnapshot, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT doc_id, version, created_at
+		FROM doc_snapshots
+		WHERE doc_id = $1
+		ORDER BY version DESC
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*models.DocSnapshot
+	for rows.Next() {
+		var s models.DocSnapshot
+		err := rows.Scan(&s.DocID, &s.Version, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, &s)
+	}
+	return snapshots, nil
+}
+
+// ListActivityFeed returns recent activity across all documents the user owns
+// or has a permission on, newest first. Permission events are dated by their
+// latest change, so a role change shows up like a new grant.
+func (db *DB) ListActivityFeed(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.ActivityEvent, error) {
+	// Each branch is restricted to the accessible documents before the union,
+	// so the sort only sees events the user may read
+	rows, err := db.pool.Query(ctx, `
+		WITH accessible AS (
+			SELECT d.id, d.title
+			FROM documents d
+			LEFT JOIN document_permissions dp ON dp.doc_id = d.id AND dp.user_id = $1
+			WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		)
+		SELECT e.type, e.doc_id, a.title, e.user_id, COALESCE(u.name, ''), e.detail, e.created_at
+		FROM (
+			SELECT $2::text AS type, s.doc_id, NULL::uuid AS user_id, s.version::text AS detail, s.created_at
+			FROM doc_snapshots s
+			WHERE s.doc_id IN (SELECT id FROM accessible)
+			UNION ALL
+			SELECT $3::text, c.doc_id, c.user_id, c.id::text, c.created_at
+			FROM comments c
+			WHERE c.doc_id IN (SELECT id FROM accessible)
+			UNION ALL
+			SELECT $4::text, p.doc_id, p.user_id, p.role, p.updated_at
+			FROM document_permissions p
+			WHERE p.doc_id IN (SELECT id FROM accessible)
+		) e
+		JOIN accessible a ON a.id = e.doc_id
+		LEFT JOIN users u ON u.id = e.user_id
+		ORDER BY e.created_at DESC
+		LIMIT $5 OFFSET $6
+	`, userID, models.ActivitySnapshot, models.ActivityComment, models.ActivityPermission, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.ActivityEvent
+	for rows.Next() {
+		var e models.ActivityEvent
+		err := rows.Scan(&e.Type, &e.DocID, &e.DocTitle, &e.UserID, &e.UserName, &e.Detail, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// SaveSnapshotBase64 saves a new snapshot for a document from base64 encoded data and updates document's updated_at
+func (db *DB) SaveSnapshotBase64(ctx context.Context, docID uuid.UUID, base64Data string) (*models.DocSnapshot, error) {
+	// Start a transaction to update both snapshot and document
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockUntrashedDocument(ctx, tx, docID); err != nil {
+		return nil, err
+	}
+
+	unchanged, err := latestChecksumMatches(ctx, tx, docID, "sha256(decode($2, 'base64'))", base64Data)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		return nil, ErrSnapshotUnchanged
+	}
+
+	var snapshot models.DocSnapshot
+	// Use PostgreSQL's decode function to convert base64 to bytea
+	err = tx.QueryRow(ctx, `
+		INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, decode($2, 'base64'), sha256(decode($2, 'base64'))
+		FROM doc_snapshots WHERE doc_id = $1
+		RETURNING doc_id, version, snapshot, created_at
+	`, docID, base64Data).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &snapshot.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update document's updated_at timestamp
+	_, err = tx.Exec(ctx, `UPDATE documents SET updated_at = NOW() WHERE id = $1`, docID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// Comment operations
+
+// replyPreviewLen is the number of characters of the latest reply returned with a thread
+const replyPreviewLen = 140
+
+// resolverColumns selects who resolved comment c and when; queries using it
+// must LEFT JOIN users rb ON rb.id = c.resolved_by
+const resolverColumns = `rb.id, COALESCE(rb.email, ''), COALESCE(rb.name, ''), COALESCE(rb.avatar_url, ''), c.resolved_at`
+
+// resolverScan receives resolverColumns
+type resolverScan struct {
+	id   *uuid.UUID
+	user models.User
+	at   *time.Time
+}
+
+func (r *resolverScan) dest() []interface{} {
+	return []interface{}{&r.id, &r.user.Email, &r.user.Name, &r.user.AvatarURL, &r.at}
+}
+
+// apply sets ResolvedBy and ResolvedAt on c if the comment is resolved
+func (r *resolverScan) apply(c *models.Comment) {
+	c.ResolvedAt = r.at
+	if r.id != nil {
+		r.user.ID = *r.id
+		c.ResolvedBy = &r.user
+	}
+}
+
+// Comment states for CommentListOptions.State
+const (
+	CommentsAll      = "all"
+	CommentsOpen     = "open"
+	CommentsResolved = "resolved"
+)
+
+// CommentListOptions selects a page of a document's top-level comments
+type CommentListOptions struct {
+	State  string // CommentsAll, CommentsOpen or CommentsResolved
+	Limit  int
+	Offset int
+}
+
+// ListComments returns a page of the top-level comments for a document, newest
+// first, each with its reply count and a preview of the latest reply
+func (db *DB) ListComments(ctx context.Context, docID uuid.UUID, opts CommentListOptions) ([]*models.Comment, error) {
+	stateFilter := ""
+	switch opts.State {
+	case CommentsOpen:
+		stateFilter = "AND NOT c.resolved"
+	case CommentsResolved:
+		stateFilter = "AND c.resolved"
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection, 
+		       c.resolved, c.parent_id, c.created_at, c.updated_at,
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
+		       (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) as reply_count,
+		       lr.id, COALESCE(lr.preview, ''), lr.created_at,
+		       lu.id, COALESCE(lu.email, ''), COALESCE(lu.name, ''), COALESCE(lu.avatar_url, ''),
+		       `+resolverColumns+`
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		LEFT JOIN users rb ON rb.id = c.resolved_by
+		LEFT JOIN LATERAL (
+			SELECT r.id, LEFT(r.content, $2) as preview, r.created_at, r.user_id
+			FROM comments r
+			WHERE r.parent_id = c.id
+			ORDER BY r.created_at DESC
+			LIMIT 1
+		) lr ON true
+		LEFT JOIN users lu ON lr.user_id = lu.id
+		WHERE c.doc_id = $1 AND c.parent_id IS NULL `+stateFilter+`
+		ORDER BY c.created_at DESC
+		LIMIT $3 OFFSET $4
+	`, docID, replyPreviewLen, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		var user models.User
+		var selectionJSON []byte
+		var replyID, replyUserID *uuid.UUID
+		var replyCreatedAt *time.Time
+		var reply models.ReplyPreview
+		var replyUser models.User
+		var resolver resolverScan
+		err := rows.Scan(append([]interface{}{
+			&c.ID, &c.DocID, &c.UserID, &c.Content, &selectionJSON,
+			&c.Resolved, &c.ParentID, &c.CreatedAt, &c.UpdatedAt,
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL,
+			&c.ReplyCount,
+			&replyID, &reply.Preview, &replyCreatedAt,
+			&replyUserID, &replyUser.Email, &replyUser.Name, &replyUser.AvatarURL,
+		}, resolver.dest()...)...)
+		if err != nil {
+			return nil, err
+		}
+		if selectionJSON != nil {
+			json.Unmarshal(selectionJSON, &c.Selection)
+		}
+		c.User = &user
+		resolver.apply(&c)
+		if replyID != nil {
+			reply.ID = *replyID
+			reply.CreatedAt = *replyCreatedAt
+			if replyUserID != nil {
+				replyUser.ID = *replyUserID
+				reply.User = &replyUser
+			}
+			c.LatestReply = &reply
+		}
+		comments = append(comments, &c)
+	}
+	return comments, nil
+}
+
+// CountCommentsByState returns how many of a document's top-level comments are open and resolved
+func (db *DB) CountCommentsByState(ctx context.Context, docID uuid.UUID) (open, resolved int, err error) {
+	err = db.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE NOT resolved), COUNT(*) FILTER (WHERE resolved)
+		FROM comments
+		WHERE doc_id = $1 AND parent_id IS NULL
+	`, docID).Scan(&open, &resolved)
+	return open, resolved, err
+}
+
+// ListCommentReplies returns the replies to a comment, oldest first
+func (db *DB) ListCommentReplies(ctx context.Context, parentID uuid.UUID, limit, offset int) ([]*models.Comment, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection,
+		       c.resolved, c.parent_id, c.created_at, c.updated_at, COALESCE(c.is_resolution, false),
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.parent_id = $1
+		ORDER BY c.created_at ASC
+		LIMIT $2 OFFSET $3
+	`, parentID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		var user models.User
+		var selectionJSON []byte
+		err := rows.Scan(
+			&c.ID, &c.DocID, &c.UserID, &c.Content, &selectionJSON,
+			&c.Resolved, &c.ParentID, &c.CreatedAt, &c.UpdatedAt, &c.IsResolution,
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if selectionJSON != nil {
+			json.Unmarshal(selectionJSON, &c.Selection)
+		}
+		c.User = &user
+		replies = append(replies, &c)
+	}
+	return replies, nil
+}
+
+// ListCommentTree returns a page of a document's top-level comments (as
+// ListComments) with their replies nested in Replies, down to maxDepth reply levels
+func (db *DB) ListCommentTree(ctx context.Context, docID uuid.UUID, opts CommentListOptions, maxDepth int) ([]*models.Comment, error) {
+	comments, err := db.ListComments(ctx, docID, opts)
+	if err != nil || len(comments) == 0 {
+		return comments, err
+	}
+
+	topLevel := make([]string, len(comments))
+	for i, c := range comments {
+		topLevel[i] = c.ID.String()
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		WITH RECURSIVE thread AS (
+			SELECT r.id, 1 AS depth
+			FROM comments r
+			WHERE r.doc_id = $1 AND r.parent_id = ANY($3::uuid[])
+			UNION ALL
+			SELECT r.id, t.depth + 1
+			FROM comments r
+			JOIN thread t ON r.parent_id = t.id
+			WHERE t.depth < $2
+		)
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection,
+		       c.resolved, c.parent_id, c.created_at, c.updated_at, COALESCE(c.is_resolution, false),
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
+		FROM thread t
+		JOIN comments c ON c.id = t.id
+		JOIN users u ON c.user_id = u.id
+		ORDER BY t.depth ASC, c.created_at ASC
+	`, docID, maxDepth, topLevel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Parents are always scanned before their replies (ordered by depth)
+	byID := make(map[uuid.UUID]*models.Comment, len(comments))
+	for _, c := range comments {
+		c.Replies = []*models.Comment{}
+		byID[c.ID] = c
+	}
+	for rows.Next() {
+		var c models.Comment
+		var user models.User
+		var selectionJSON []byte
+		err := rows.Scan(
+			&c.ID, &c.DocID, &c.UserID, &c.Content, &selectionJSON,
+			&c.Resolved, &c.ParentID, &c.CreatedAt, &c.UpdatedAt, &c.IsResolution,
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if selectionJSON != nil {
+			json.Unmarshal(selectionJSON, &c.Selection)
+		}
+		c.User = &user
+		c.Replies = []*models.Comment{}
+		if parent, ok := byID[*c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, &c)
+			byID[c.ID] = &c
+		}
+	}
+	return comments, rows.Err()
+}
+
+// GetCommentDepth returns how deep a comment is nested: 0 for a top-level
+// comment, 1 for a reply to it, and so on
+func (db *DB) GetCommentDepth(ctx context.Context, id uuid.UUID) (int, error) {
+	var depth int
+	err := db.pool.QueryRow(ctx, `
+		WITH RECURSIVE chain AS (
+			SELECT id, parent_id, 0 AS depth FROM comments WHERE id = $1
+			UNION ALL
+			SELECT p.id, p.parent_id, ch.depth + 1
+			FROM comments p
+			JOIN chain ch ON p.id = ch.parent_id
+			WHERE ch.depth < 100
+		)
+		SELECT COALESCE(MAX(depth), 0) FROM chain
+	`, id).Scan(&depth)
+	return depth, err
+}
+
+// CountOpenComments returns the number of unresolved comments (including replies) on a document
+func (db *DB) CountOpenComments(ctx context.Context, docID uuid.UUID) (int, error) {
+	var count int
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM comments WHERE doc_id = $1 AND NOT resolved
+	`, docID).Scan(&count)
+	return count, err
+}
+
+// CreateComment creates a new comment and records the users it mentions
+func (db *DB) CreateComment(ctx context.Context, docID, userID uuid.UUID, content string, selection *models.Selection, parentID *uuid.UUID, mentions []uuid.UUID) (*models.Comment, error) {
+	// For simple protocol mode, we need to pass JSONB as string, not []byte
+	var selectionStr *string
+	if selection != nil {
+		jsonBytes, _ := json.Marshal(selection)
+		s := string(jsonBytes)
+		selectionStr = &s
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var comment models.Comment
+	var selectionJSON []byte
+	err = tx.QueryRow(ctx, `
+		INSERT INTO comments (doc_id, user_id, content, selection, parent_id)
+		VALUES ($1, $2, $3, $4::jsonb, $5)
+		RETURNING id, doc_id, user_id, content, selection, resolved, parent_id, created_at, updated_at
+	`, docID, userID, content, selectionStr, parentID).Scan(
+		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
+		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
+	)
+	if err != nil {
+		logger.Error("[DB] CreateComment: error: %v", err)
+		return nil, err
+	}
+	if selectionJSON != nil {
+		json.Unmarshal(selectionJSON, &comment.Selection)
+	}
+
+	if len(mentions) > 0 {
+		ids := make([]string, len(mentions))
+		for i, id := range mentions {
+			ids[i] = id.String()
+		}
+		rows, err := tx.Query(ctx, `
+			WITH inserted AS (
+				INSERT INTO comment_mentions (comment_id, user_id)
+				SELECT $1, id FROM unnest($2::uuid[]) AS id
+				ON CONFLICT DO NOTHING
+				RETURNING user_id
+			)
+			SELECT u.id, u.email, u.name, COALESCE(u.avatar_url, '')
+			FROM inserted i
+			JOIN users u ON u.id = i.user_id
+			ORDER BY u.name
+		`, comment.ID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var u models.User
+			if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.AvatarURL); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			comment.Mentions = append(comment.Mentions, &u)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	logger.Info("[DB] CreateComment: success, commentID=%s", comment.ID)
+	return &comment, nil
+}
+
+// ListMentionsForUser returns the comments that mention a user, newest first,
+// on documents the user can still access and that are not in the trash
+func (db *DB) ListMentionsForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.Comment, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection,
+		       c.resolved, c.parent_id, c.created_at, c.updated_at, COALESCE(c.is_resolution, false),
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
+		       d.title
+		FROM comment_mentions m
+		JOIN comments c ON c.id = m.comment_id
+		JOIN users u ON c.user_id = u.id
+		JOIN documents d ON d.id = c.doc_id
+		WHERE m.user_id = $1
+		  AND d.deleted_at IS NULL
+		  AND (d.owner_id = $1 OR EXISTS (
+			SELECT 1 FROM document_permissions dp WHERE dp.doc_id = d.id AND dp.user_id = $1
+		  ))
+		ORDER BY c.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		var user models.User
+		var selectionJSON []byte
+		err := rows.Scan(
+			&c.ID, &c.DocID, &c.UserID, &c.Content, &selectionJSON,
+			&c.Resolved, &c.ParentID, &c.CreatedAt, &c.UpdatedAt, &c.IsResolution,
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL,
+			&c.DocTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if selectionJSON != nil {
+			json.Unmarshal(selectionJSON, &c.Selection)
+		}
+		c.User = &user
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+// UpdateComment updates a comment. Resolving records userID as the resolver
+// (an already resolved comment keeps its original resolver); reopening clears
+// resolved_by and resolved_at.
+func (db *DB) UpdateComment(ctx context.Context, id, userID uuid.UUID, content *string, resolved *bool) (*models.Comment, error) {
+	query := "UPDATE comments SET updated_at = NOW()"
+	args := []interface{}{}
+	argNum := 1
+
+	if content != nil {
+		query += fmt.Sprintf(", content = $%d", argNum)
+		args = append(args, *content)
+		argNum++
+	}
+	if resolved != nil {
+		// SET expressions see the row before the update
+		query += fmt.Sprintf(`, resolved = $%[1]d::boolean,
+			resolved_by = CASE WHEN $%[1]d::boolean THEN CASE WHEN resolved THEN resolved_by ELSE $%[2]d::uuid END END,
+			resolved_at = CASE WHEN $%[1]d::boolean THEN CASE WHEN resolved THEN resolved_at ELSE NOW() END END`, argNum, argNum+1)
+		args = append(args, *resolved, userID)
+		argNum += 2
+	}
+
+	query = fmt.Sprintf(`
+		WITH c AS (%s WHERE id = $%d RETURNING *)
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection, c.resolved, c.parent_id, c.created_at, c.updated_at,
+		       %s
+		FROM c
+		LEFT JOIN users rb ON rb.id = c.resolved_by
+	`, query, argNum, resolverColumns)
+	args = append(args, id)
+
+	var comment models.Comment
+	var selectionJSON []byte
+	var resolver resolverScan
+	err := db.pool.QueryRow(ctx, query, args...).Scan(append([]interface{}{
+		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
+		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
+	}, resolver.dest()...)...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if selectionJSON != nil {
+		json.Unmarshal(selectionJSON, &comment.Selection)
+	}
+	resolver.apply(&comment)
+	return &comment, nil
+}
+
+// DeleteComment deletes a comment
+func (db *DB) DeleteComment(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `DELETE FROM comments WHERE id = $1`, id)
+	return err
+}
+
+// ErrCommentResolved is returned by ResolveComment when the comment is already
+// resolved (or no longer exists)
+var ErrCommentResolved = errors.New("comment is already resolved")
+
+// ResolveComment marks a thread resolved and, if note is not empty, adds the
+// note as a resolution reply by userID, in one transaction. Returns the updated
+// comment and the note reply (nil without a note).
+func (db *DB) ResolveComment(ctx context.Context, id, userID uuid.UUID, note string) (*models.Comment, *models.Comment, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var comment models.Comment
+	var selectionJSON []byte
+	var resolver resolverScan
+	err = tx.QueryRow(ctx, `
+		WITH c AS (
+			UPDATE comments SET resolved = true, resolved_by = $2, resolved_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND NOT resolved
+			RETURNING *
+		)
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection, c.resolved, c.parent_id, c.created_at, c.updated_at,
+		       `+resolverColumns+`
+		FROM c
+		LEFT JOIN users rb ON rb.id = c.resolved_by
+	`, id, userID).Scan(append([]interface{}{
+		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
+		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
+	}, resolver.dest()...)...)
+	if err == pgx.ErrNoRows {
+		// Resolved concurrently; the first resolver and note stand
+		return nil, nil, ErrCommentResolved
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if selectionJSON != nil {
+		json.Unmarshal(selectionJSON, &comment.Selection)
+	}
+	resolver.apply(&comment)
+
+	var reply *models.Comment
+	if note != "" {
+		reply = &models.Comment{}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO comments (doc_id, user_id, content, parent_id, is_resolution)
+			VALUES ($1, $2, $3, $4, true)
+			RETURNING id, doc_id, user_id, content, resolved, parent_id, is_resolution, created_at, updated_at
+		`, comment.DocID, userID, note, comment.ID).Scan(
+			&reply.ID, &reply.DocID, &reply.UserID, &reply.Content,
+			&reply.Resolved, &reply.ParentID, &reply.IsResolution, &reply.CreatedAt, &reply.UpdatedAt,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return &comment, reply, nil
+}
+
+// GetComment retrieves a comment by ID
+func (db *DB) GetComment(ctx context.Context, id uuid.UUID) (*models.Comment, error) {
+	var comment models.Comment
+	var selectionJSON []byte
+	var resolver resolverScan
+	err := db.pool.QueryRow(ctx, `
+		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection, c.resolved, c.parent_id, c.created_at, c.updated_at,
+		       `+resolverColumns+`
+		FROM comments c
+		LEFT JOIN users rb ON rb.id = c.resolved_by
+		WHERE c.id = $1
+	`, id).Scan(append([]interface{}{
+		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
+		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
+	}, resolver.dest()...)...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if selectionJSON != nil {
+		json.Unmarshal(selectionJSON, &comment.Selection)
+	}
+	resolver.apply(&comment)
+	return &comment, nil
+}
+
+// Share link operations
+
+// CreateShareLink stores a new share link for a document; expiresAt may be nil
+func (db *DB) CreateShareLink(ctx context.Context, token string, docID, createdBy uuid.UUID, role string, expiresAt *time.Time, singleUse bool) (*models.ShareLink, error) {
+	var link models.ShareLink
+	err := db.pool.QueryRow(ctx, `
+		INSERT INTO document_share_links (token, doc_id, role, created_by, expires_at, single_use)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING token, doc_id, role, created_by, expires_at, single_use, used_at, created_at
+	`, token, docID, role, createdBy, expiresAt, singleUse).Scan(
+		&link.Token, &link.DocID, &link.Role, &link.CreatedBy, &link.ExpiresAt, &link.SingleUse, &link.UsedAt, &link.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// GetShareLink retrieves a share link by token
+func (db *DB) GetShareLink(ctx context.Context, token string) (*models.ShareLink, error) {
+	var link models.ShareLink
+	err := db.pool.QueryRow(ctx, `
+		SELECT token, doc_id, role, created_by, expires_at, COALESCE(single_use, false), used_at, created_at
+		FROM document_share_links WHERE token = $1
+	`, token).Scan(
+		&link.Token, &link.DocID, &link.Role, &link.CreatedBy, &link.ExpiresAt, &link.SingleUse, &link.UsedAt, &link.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ListShareLinks returns all share links of a document, newest first
+func (db *DB) ListShareLinks(ctx context.Context, docID uuid.UUID) ([]*models.ShareLink, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT token, doc_id, role, created_by, expires_at, COALESCE(single_use, false), used_at, created_at
+		FROM document_share_links
+		WHERE doc_id = $1
+		ORDER BY created_at DESC
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*models.ShareLink
+	for rows.Next() {
+		var link models.ShareLink
+		err := rows.Scan(
+			&link.Token, &link.DocID, &link.Role, &link.CreatedBy, &link.ExpiresAt, &link.SingleUse, &link.UsedAt, &link.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, &link)
+	}
+	return links, nil
+}
+
+// RevokeShareLink deletes a document's share link. Returns false if it did not exist.
+func (db *DB) RevokeShareLink(ctx context.Context, docID uuid.UUID, token string) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		DELETE FROM document_share_links WHERE doc_id = $1 AND token = $2
+	`, docID, token)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RedeemShareLink grants userID the link's role on its document in one
+// transaction. An existing higher role is kept. Single-use links are consumed;
+// returns false if the link was already used or has expired meanwhile.
+func (db *DB) RedeemShareLink(ctx context.Context, link *models.ShareLink, userID uuid.UUID) (bool, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Re-check the link under the transaction so concurrent redeems of a single-use link can't both succeed
+	tag, err := tx.Exec(ctx, `
+		UPDATE document_share_links
+		SET used_at = CASE WHEN single_use THEN NOW() ELSE used_at END
+		WHERE token = $1
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND NOT (single_use AND used_at IS NOT NULL)
+	`, link.Token)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
 	}
 
-	// Update document's updated_at timestamp
-	_, err = tx.Exec(ctx, `UPDATE documents SET updated_at = NOW() WHERE id = $1`, docID)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO document_permissions (doc_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (doc_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		WHERE (CASE document_permissions.role WHEN 'owner' THEN 4 WHEN 'edit' THEN 3 WHEN 'comment' THEN 2 ELSE 1 END)
+		    < (CASE EXCLUDED.role WHEN 'owner' THEN 4 WHEN 'edit' THEN 3 WHEN 'comment' THEN 2 ELSE 1 END)
+	`, link.DocID, userID, link.Role)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return nil, err
+		return false, err
 	}
+	return true, nil
+}
 
-	return &snapshot, nil
+// Tag operations
+
+// AddTag tags a document; adding an existing tag is a no-op
+func (db *DB) AddTag(ctx context.Context, docID uuid.UUID, tag string) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO tags (doc_id, tag) VALUES ($1, $2)
+		ON CONFLICT (doc_id, tag) DO NOTHING
+	`, docID, tag)
+	return err
 }
 
-// ListSnapshots returns all snapshots for a document
-func (db *DB) ListSnapshots(ctx context.Context, docID uuid.UUID) ([]*models.DocSnapshot, error) {
+// RemoveTag removes a tag from a document. Returns false if the document did not have it.
+func (db *DB) RemoveTag(ctx context.Context, docID uuid.UUID, tag string) (bool, error) {
+	result, err := db.pool.Exec(ctx, `
+		DELETE FROM tags WHERE doc_id = $1 AND tag = $2
+	`, docID, tag)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// ListTags returns a document's tags in alphabetical order
+func (db *DB) ListTags(ctx context.Context, docID uuid.UUID) ([]string, error) {
 	rows, err := db.pool.Query(ctx, `
-		SELECT doc_id, version, created_at
-		FROM doc_snapshots
-		WHERE doc_id = $1
-		ORDER BY version DESC
+		SELECT tag FROM tags WHERE doc_id = $1 ORDER BY tag
 	`, docID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var snapshots []*models.DocSnapshot
+	var tags []string
 	for rows.Next() {
-		var s models.DocSnapshot
-		err := rows.Scan(&s.DocID, &s.Version, &s.CreatedAt)
-		if err != nil {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
 			return nil, err
 		}
-		snapshots = append(snapshots, &s)
+		tags = append(tags, tag)
 	}
-	return snapshots, nil
+	return tags, nil
 }
 
-// SaveSnapshotBase64 saves a new snapshot for a document from base64 encoded data and updates document's updated_at
-func (db *DB) SaveSnapshotBase64(ctx context.Context, docID uuid.UUID, base64Data string) (*models.DocSnapshot, error) {
-	// Start a transaction to update both snapshot and document
-	tx, err := db.pool.Begin(ctx)
+// ListDocumentsByTag returns a page of the user's accessible documents carrying
+// the tag, most recently updated first, together with the total count
+func (db *DB) ListDocumentsByTag(ctx context.Context, userID uuid.UUID, tag string, role RoleFilter, limit, offset int) ([]*models.Document, int, error) {
+	var total int
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM documents d
+		JOIN tags t ON t.doc_id = d.id AND t.tag = $2
+		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+		WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		  AND `+roleFilterSQL(3, 4)+`
+	`, userID, tag, role.Role, role.AtLeast).Scan(&total)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	defer tx.Rollback(ctx)
 
-	var snapshot models.DocSnapshot
-	// Use PostgreSQL's decode function to convert base64 to bytea
-	err = tx.QueryRow(ctx, `
-		INSERT INTO doc_snapshots (doc_id, version, snapshot)
-		SELECT $1, COALESCE(MAX(version), 0) + 1, decode($2, 'base64')
-		FROM doc_snapshots WHERE doc_id = $1
-		RETURNING doc_id, version, snapshot, created_at
-	`, docID, base64Data).Scan(&snapshot.DocID, &snapshot.Version, &snapshot.Snapshot, &snapshot.CreatedAt)
+	rows, err := db.pool.Query(ctx, `
+		SELECT d.id, d.title, d.owner_id, d.created_at, d.updated_at,
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
+		       COALESCE(dp.role, 'view') as permission,
+		       f.doc_id IS NOT NULL as starred
+		FROM documents d
+		JOIN tags t ON t.doc_id = d.id AND t.tag = $2
+		JOIN users u ON d.owner_id = u.id
+		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+		LEFT JOIN favorites f ON d.id = f.doc_id AND f.user_id = $1
+		WHERE (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		  AND `+roleFilterSQL(5, 6)+`
+		ORDER BY d.updated_at DESC, d.id
+		LIMIT $3 OFFSET $4
+	`, userID, tag, limit, offset, role.Role, role.AtLeast)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer rows.Close()
 
-	// Update document's updated_at timestamp
-	_, err = tx.Exec(ctx, `UPDATE documents SET updated_at = NOW() WHERE id = $1`, docID)
-	if err != nil {
-		return nil, err
+	var docs []*models.Document
+	for rows.Next() {
+		var doc models.Document
+		var owner models.User
+		err := rows.Scan(
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.CreatedAt, &doc.UpdatedAt,
+			&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
+			&doc.Permission, &doc.Starred,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		doc.Owner = &owner
+		docs = append(docs, &doc)
 	}
+	return docs, total, nil
+}
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, err
-	}
+// Favorite operations
 
-	return &snapshot, nil
+// StarDocument adds a document to the user's favorites; starring twice is a no-op
+func (db *DB) StarDocument(ctx context.Context, userID, docID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO favorites (user_id, doc_id) VALUES ($1, $2)
+		ON CONFLICT (user_id, doc_id) DO NOTHING
+	`, userID, docID)
+	return err
 }
 
-// Comment operations
+// UnstarDocument removes a document from the user's favorites
+func (db *DB) UnstarDocument(ctx context.Context, userID, docID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `
+		DELETE FROM favorites WHERE user_id = $1 AND doc_id = $2
+	`, userID, docID)
+	return err
+}
 
-// ListComments returns all comments for a document
-func (db *DB) ListComments(ctx context.Context, docID uuid.UUID) ([]*models.Comment, error) {
+// ListStarredDocuments returns the user's starred documents they can still
+// access, most recently starred first
+func (db *DB) ListStarredDocuments(ctx context.Context, userID uuid.UUID) ([]*models.Document, error) {
 	rows, err := db.pool.Query(ctx, `
-		SELECT c.id, c.doc_id, c.user_id, c.content, c.selection, 
-		       c.resolved, c.parent_id, c.created_at, c.updated_at,
-		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
-		FROM comments c
-		JOIN users u ON c.user_id = u.id
-		WHERE c.doc_id = $1 AND c.parent_id IS NULL
-		ORDER BY c.created_at DESC
-	`, docID)
+		SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at,
+		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
+		       COALESCE(dp.role, 'view') as permission
+		FROM favorites f
+		JOIN documents d ON f.doc_id = d.id
+		JOIN users u ON d.owner_id = u.id
+		LEFT JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+		WHERE f.user_id = $1 AND (d.owner_id = $1 OR dp.user_id = $1) AND d.deleted_at IS NULL
+		ORDER BY f.created_at DESC, d.id
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var comments []*models.Comment
+	var docs []*models.Document
 	for rows.Next() {
-		var c models.Comment
-		var user models.User
-		var selectionJSON []byte
+		var doc models.Document
+		var owner models.User
 		err := rows.Scan(
-			&c.ID, &c.DocID, &c.UserID, &c.Content, &selectionJSON,
-			&c.Resolved, &c.ParentID, &c.CreatedAt, &c.UpdatedAt,
-			&user.ID, &user.Email, &user.Name, &user.AvatarURL,
+			&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.CreatedAt, &doc.UpdatedAt,
+			&owner.ID, &owner.Email, &owner.Name, &owner.AvatarURL,
+			&doc.Permission,
 		)
 		if err != nil {
 			return nil, err
 		}
-		if selectionJSON != nil {
-			json.Unmarshal(selectionJSON, &c.Selection)
-		}
-		c.User = &user
-		comments = append(comments, &c)
+		doc.Owner = &owner
+		doc.Starred = true
+		docs = append(docs, &doc)
 	}
-	return comments, nil
+	return docs, nil
 }
 
-// CreateComment creates a new comment
-func (db *DB) CreateComment(ctx context.Context, docID, userID uuid.UUID, content string, selection *models.Selection, parentID *uuid.UUID) (*models.Comment, error) {
-	// For simple protocol mode, we need to pass JSONB as string, not []byte
-	var selectionStr *string
-	if selection != nil {
-		jsonBytes, _ := json.Marshal(selection)
-		s := string(jsonBytes)
-		selectionStr = &s
-	}
+// Notification operations
 
-	var comment models.Comment
-	var selectionJSON []byte
-	err := db.pool.QueryRow(ctx, `
-		INSERT INTO comments (doc_id, user_id, content, selection, parent_id)
-		VALUES ($1, $2, $3, $4::jsonb, $5)
-		RETURNING id, doc_id, user_id, content, selection, resolved, parent_id, created_at, updated_at
-	`, docID, userID, content, selectionStr, parentID).Scan(
-		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
-		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
-	)
+// CreateNotification stores a notification for a user; payload is marshaled to JSON
+func (db *DB) CreateNotification(ctx context.Context, userID uuid.UUID, notificationType string, payload interface{}) (*models.Notification, error) {
+	// For simple protocol mode, JSONB is passed as a string
+	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
-		logger.Error("[DB] CreateComment: error: %v", err)
 		return nil, err
 	}
-	if selectionJSON != nil {
-		json.Unmarshal(selectionJSON, &comment.Selection)
-	}
-	logger.Info("[DB] CreateComment: success, commentID=%s", comment.ID)
-	return &comment, nil
-}
-
-// UpdateComment updates a comment
-func (db *DB) UpdateComment(ctx context.Context, id uuid.UUID, content *string, resolved *bool) (*models.Comment, error) {
-	query := "UPDATE comments SET updated_at = NOW()"
-	args := []interface{}{}
-	argNum := 1
 
-	if content != nil {
-		query += fmt.Sprintf(", content = $%d", argNum)
-		args = append(args, *content)
-		argNum++
-	}
-	if resolved != nil {
-		query += fmt.Sprintf(", resolved = $%d", argNum)
-		args = append(args, *resolved)
-		argNum++
+	n := models.Notification{UserID: userID, Type: notificationType, Payload: payloadJSON}
+	err = db.pool.QueryRow(ctx, `
+		INSERT INTO notifications (user_id, type, payload)
+		VALUES ($1, $2, $3::jsonb)
+		RETURNING id, read, created_at
+	`, userID, notificationType, string(payloadJSON)).Scan(&n.ID, &n.Read, &n.CreatedAt)
+	if err != nil {
+		return nil, err
 	}
+	return &n, nil
+}
 
-	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, doc_id, user_id, content, selection, resolved, parent_id, created_at, updated_at", argNum)
-	args = append(args, id)
-
-	var comment models.Comment
-	var selectionJSON []byte
-	err := db.pool.QueryRow(ctx, query, args...).Scan(
-		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
-		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
-	)
-	if err == pgx.ErrNoRows {
-		return nil, nil
-	}
+// ListNotifications returns a user's most recent notifications, newest first
+func (db *DB) ListNotifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]*models.Notification, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, type, payload, read, created_at
+		FROM notifications
+		WHERE user_id = $1 AND (NOT $2::boolean OR NOT read)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, userID, unreadOnly, limit)
 	if err != nil {
 		return nil, err
 	}
-	if selectionJSON != nil {
-		json.Unmarshal(selectionJSON, &comment.Selection)
+	defer rows.Close()
+
+	var notifications []*models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Payload, &n.Read, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
 	}
-	return &comment, nil
+	return notifications, rows.Err()
 }
 
-// DeleteComment deletes a comment
-func (db *DB) DeleteComment(ctx context.Context, id uuid.UUID) error {
-	_, err := db.pool.Exec(ctx, `DELETE FROM comments WHERE id = $1`, id)
-	return err
+// MarkNotificationRead marks one of a user's notifications read. Returns false
+// if the notification does not exist or belongs to someone else.
+func (db *DB) MarkNotificationRead(ctx context.Context, id, userID uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE notifications SET read = TRUE WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
-// GetComment retrieves a comment by ID
-func (db *DB) GetComment(ctx context.Context, id uuid.UUID) (*models.Comment, error) {
-	var comment models.Comment
-	var selectionJSON []byte
-	err := db.pool.QueryRow(ctx, `
-		SELECT id, doc_id, user_id, content, selection, resolved, parent_id, created_at, updated_at
-		FROM comments WHERE id = $1
-	`, id).Scan(
-		&comment.ID, &comment.DocID, &comment.UserID, &comment.Content, &selectionJSON,
-		&comment.Resolved, &comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt,
-	)
-	if err == pgx.ErrNoRows {
-		return nil, nil
-	}
+// MarkAllNotificationsRead marks all of a user's notifications read and returns how many changed
+func (db *DB) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE notifications SET read = TRUE WHERE user_id = $1 AND NOT read
+	`, userID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if selectionJSON != nil {
-		json.Unmarshal(selectionJSON, &comment.Selection)
-	}
-	return &comment, nil
+	return tag.RowsAffected(), nil
 }
 
 // Access Request operations
 
-// CreateAccessRequest creates a new access request
-func (db *DB) CreateAccessRequest(ctx context.Context, docID, requesterID uuid.UUID, requestedRole, message string) (*models.AccessRequest, error) {
+// CreateAccessRequest creates a new access request, or reopens the user's
+// previous one for the document, pending until expiresAt
+func (db *DB) CreateAccessRequest(ctx context.Context, docID, requesterID uuid.UUID, requestedRole, message string, expiresAt time.Time) (*models.AccessRequest, error) {
 	if requestedRole == "" {
 		requestedRole = "view"
 	}
 
 	var req models.AccessRequest
 	err := db.pool.QueryRow(ctx, `
-		INSERT INTO access_requests (doc_id, requester_id, requested_role, message)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO access_requests (doc_id, requester_id, requested_role, message, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
 		ON CONFLICT (doc_id, requester_id) DO UPDATE SET
 			status = 'pending',
 			requested_role = $3,
 			message = $4,
+			expires_at = $5,
 			updated_at = NOW()
-		RETURNING id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at
-	`, docID, requesterID, requestedRole, message).Scan(
-		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt,
+		RETURNING id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at, expires_at
+	`, docID, requesterID, requestedRole, message, expiresAt).Scan(
+		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 	)
 	if err != nil {
 		return nil, err
@@ -674,14 +2250,14 @@ func (db *DB) GetAccessRequest(ctx context.Context, id uuid.UUID) (*models.Acces
 	var requester models.User
 	err := db.pool.QueryRow(ctx, `
 		SELECT ar.id, ar.doc_id, ar.requester_id, ar.status, ar.requested_role, 
-		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at,
+		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at, ar.expires_at,
 		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
 		FROM access_requests ar
 		JOIN users u ON ar.requester_id = u.id
 		WHERE ar.id = $1
 	`, id).Scan(
 		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole,
-		&req.Message, &req.CreatedAt, &req.UpdatedAt,
+		&req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 		&requester.ID, &requester.Email, &requester.Name, &requester.AvatarURL,
 	)
 	if err == pgx.ErrNoRows {
@@ -694,17 +2270,19 @@ func (db *DB) GetAccessRequest(ctx context.Context, id uuid.UUID) (*models.Acces
 	return &req, nil
 }
 
-// ListAccessRequestsByDoc returns all access requests for a document
-func (db *DB) ListAccessRequestsByDoc(ctx context.Context, docID uuid.UUID) ([]*models.AccessRequest, error) {
+// ListAccessRequestsByDoc returns access requests for a document, newest first.
+// An empty status returns requests of every status.
+func (db *DB) ListAccessRequestsByDoc(ctx context.Context, docID uuid.UUID, status string, limit, offset int) ([]*models.AccessRequest, error) {
 	rows, err := db.pool.Query(ctx, `
 		SELECT ar.id, ar.doc_id, ar.requester_id, ar.status, ar.requested_role, 
-		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at,
+		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at, ar.expires_at,
 		       u.id, u.email, u.name, COALESCE(u.avatar_url, '')
 		FROM access_requests ar
 		JOIN users u ON ar.requester_id = u.id
-		WHERE ar.doc_id = $1
+		WHERE ar.doc_id = $1 AND ($2 = '' OR ar.status = $2)
 		ORDER BY ar.created_at DESC
-	`, docID)
+		LIMIT $3 OFFSET $4
+	`, docID, status, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -716,7 +2294,7 @@ func (db *DB) ListAccessRequestsByDoc(ctx context.Context, docID uuid.UUID) ([]*
 		var requester models.User
 		err := rows.Scan(
 			&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole,
-			&req.Message, &req.CreatedAt, &req.UpdatedAt,
+			&req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 			&requester.ID, &requester.Email, &requester.Name, &requester.AvatarURL,
 		)
 		if err != nil {
@@ -728,16 +2306,17 @@ func (db *DB) ListAccessRequestsByDoc(ctx context.Context, docID uuid.UUID) ([]*
 	return requests, nil
 }
 
-// UpdateAccessRequestStatus updates the status of an access request
+// UpdateAccessRequestStatus decides a pending, unexpired access request.
+// Returns nil if there is no such request, e.g. because it was already decided.
 func (db *DB) UpdateAccessRequestStatus(ctx context.Context, id uuid.UUID, status string) (*models.AccessRequest, error) {
 	var req models.AccessRequest
 	err := db.pool.QueryRow(ctx, `
 		UPDATE access_requests 
 		SET status = $2, updated_at = NOW()
-		WHERE id = $1
-		RETURNING id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at
+		WHERE id = $1 AND status = 'pending' AND expires_at > NOW()
+		RETURNING id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at, expires_at
 	`, id, status).Scan(
-		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt,
+		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -752,11 +2331,11 @@ func (db *DB) UpdateAccessRequestStatus(ctx context.Context, id uuid.UUID, statu
 func (db *DB) GetPendingAccessRequest(ctx context.Context, docID, requesterID uuid.UUID) (*models.AccessRequest, error) {
 	var req models.AccessRequest
 	err := db.pool.QueryRow(ctx, `
-		SELECT id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at
+		SELECT id, doc_id, requester_id, status, requested_role, COALESCE(message, ''), created_at, updated_at, expires_at
 		FROM access_requests
 		WHERE doc_id = $1 AND requester_id = $2
 	`, docID, requesterID).Scan(
-		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt,
+		&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole, &req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -767,17 +2346,17 @@ func (db *DB) GetPendingAccessRequest(ctx context.Context, docID, requesterID uu
 	return &req, nil
 }
 
-// ListPendingAccessRequestsForOwner returns all pending access requests for documents owned by a user
+// ListPendingAccessRequestsForOwner returns all pending, unexpired access requests for documents owned by a user
 func (db *DB) ListPendingAccessRequestsForOwner(ctx context.Context, ownerID uuid.UUID) ([]*models.AccessRequest, error) {
 	rows, err := db.pool.Query(ctx, `
 		SELECT ar.id, ar.doc_id, ar.requester_id, ar.status, ar.requested_role, 
-		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at,
+		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at, ar.expires_at,
 		       u.id, u.email, u.name, COALESCE(u.avatar_url, ''),
 		       d.id, d.title
 		FROM access_requests ar
 		JOIN users u ON ar.requester_id = u.id
 		JOIN documents d ON ar.doc_id = d.id
-		WHERE d.owner_id = $1 AND ar.status = 'pending'
+		WHERE d.owner_id = $1 AND ar.status = 'pending' AND ar.expires_at > NOW() AND d.deleted_at IS NULL
 		ORDER BY ar.created_at DESC
 	`, ownerID)
 	if err != nil {
@@ -792,7 +2371,7 @@ func (db *DB) ListPendingAccessRequestsForOwner(ctx context.Context, ownerID uui
 		var doc models.Document
 		err := rows.Scan(
 			&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole,
-			&req.Message, &req.CreatedAt, &req.UpdatedAt,
+			&req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
 			&requester.ID, &requester.Email, &requester.Name, &requester.AvatarURL,
 			&doc.ID, &doc.Title,
 		)
@@ -806,12 +2385,80 @@ func (db *DB) ListPendingAccessRequestsForOwner(ctx context.Context, ownerID uui
 	return requests, nil
 }
 
+// ExpireAccessRequests marks pending access requests past their expires_at as
+// expired, returning how many were updated
+func (db *DB) ExpireAccessRequests(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE access_requests SET status = 'expired', updated_at = NOW()
+		WHERE status = 'pending' AND expires_at <= NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CancelAccessRequest withdraws a pending access request. Returns false if it
+// is no longer pending. Requesting access again reopens it.
+func (db *DB) CancelAccessRequest(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE access_requests SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListMyAccessRequests returns the access requests a user has made, newest
+// first, with the document they are for (documents in the trash are left out)
+func (db *DB) ListMyAccessRequests(ctx context.Context, requesterID uuid.UUID, limit, offset int) ([]*models.AccessRequest, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT ar.id, ar.doc_id, ar.requester_id, ar.status, ar.requested_role,
+		       COALESCE(ar.message, ''), ar.created_at, ar.updated_at, ar.expires_at,
+		       d.id, d.title
+		FROM access_requests ar
+		JOIN documents d ON ar.doc_id = d.id
+		WHERE ar.requester_id = $1 AND d.deleted_at IS NULL
+		ORDER BY ar.updated_at DESC
+		LIMIT $2 OFFSET $3
+	`, requesterID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*models.AccessRequest
+	for rows.Next() {
+		var req models.AccessRequest
+		var doc models.Document
+		err := rows.Scan(
+			&req.ID, &req.DocID, &req.RequesterID, &req.Status, &req.RequestedRole,
+			&req.Message, &req.CreatedAt, &req.UpdatedAt, &req.ExpiresAt,
+			&doc.ID, &doc.Title,
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Document = &doc
+		requests = append(requests, &req)
+	}
+	return requests, nil
+}
+
 // ========== Folder Functions ==========
 
 // CreateFolder creates a new folder
 func (db *DB) CreateFolder(ctx context.Context, name string, ownerID uuid.UUID, parentID *uuid.UUID) (*models.Folder, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	var folder models.Folder
-	err := db.pool.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		INSERT INTO folders (name, owner_id, parent_id)
 		VALUES ($1, $2, $3)
 		RETURNING id, name, owner_id, parent_id, created_at, updated_at
@@ -821,6 +2468,14 @@ func (db *DB) CreateFolder(ctx context.Context, name string, ownerID uuid.UUID,
 	if err != nil {
 		return nil, err
 	}
+
+	if err := refreshFolderPaths(ctx, tx, folder.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 	return &folder, nil
 }
 
@@ -881,8 +2536,14 @@ func (db *DB) ListFolders(ctx context.Context, ownerID uuid.UUID, parentID *uuid
 
 // UpdateFolder updates a folder's name
 func (db *DB) UpdateFolder(ctx context.Context, id uuid.UUID, name string) (*models.Folder, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	var folder models.Folder
-	err := db.pool.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		UPDATE folders SET name = $2, updated_at = NOW()
 		WHERE id = $1
 		RETURNING id, name, owner_id, parent_id, created_at, updated_at
@@ -895,13 +2556,72 @@ func (db *DB) UpdateFolder(ctx context.Context, id uuid.UUID, name string) (*mod
 	if err != nil {
 		return nil, err
 	}
+
+	// Renaming changes the path of the folder and all its descendants
+	if err := refreshFolderPaths(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 	return &folder, nil
 }
 
-// DeleteFolder deletes a folder (cascades to subfolders)
-func (db *DB) DeleteFolder(ctx context.Context, id uuid.UUID) error {
-	_, err := db.pool.Exec(ctx, `DELETE FROM folders WHERE id = $1`, id)
-	return err
+// What DeleteFolder does with the documents of a deleted subtree
+const (
+	FolderDeleteReparent = "reparent" // Move them to the deleted folder's parent (or root)
+	FolderDeleteTrash    = "trash"    // Same, and move them to the trash
+)
+
+// DeleteFolder deletes a folder (cascades to subfolders). Its documents are
+// handled per policy; returns the IDs of the documents that were moved.
+func (db *DB) DeleteFolder(ctx context.Context, id uuid.UUID, policy string) ([]uuid.UUID, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Documents anywhere in the subtree move to the deleted folder's parent (NULL
+	// = root) before the folders go, so the folder_id cascade never removes them
+	rows, err := tx.Query(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION
+			SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id
+		)
+		UPDATE documents
+		SET folder_id = (SELECT parent_id FROM folders WHERE id = $1),
+		    deleted_at = CASE WHEN $2 THEN COALESCE(deleted_at, NOW()) ELSE deleted_at END
+		WHERE folder_id IN (SELECT id FROM subtree)
+		RETURNING id
+	`, id, policy == FolderDeleteTrash)
+	if err != nil {
+		return nil, err
+	}
+	var docIDs []uuid.UUID
+	for rows.Next() {
+		var docID uuid.UUID
+		if err := rows.Scan(&docID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		docIDs = append(docIDs, docID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM folders WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return docIDs, nil
 }
 
 // GetFolderPath returns the full path of folders from root to the given folder
@@ -949,7 +2669,19 @@ func (db *DB) GetFolderContents(ctx context.Context, ownerID uuid.UUID, folderID
 	}
 
 	// Get documents in this folder (with owner info)
+	contents.Documents, err = db.listFolderDocuments(ctx, ownerID, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// listFolderDocuments returns the documents directly inside a folder (nil = root)
+// that the user has a permission on, with owner info
+func (db *DB) listFolderDocuments(ctx context.Context, userID uuid.UUID, folderID *uuid.UUID) ([]*models.Document, error) {
 	var rows pgx.Rows
+	var err error
 	if folderID == nil {
 		rows, err = db.pool.Query(ctx, `
 			SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at,
@@ -958,9 +2690,9 @@ func (db *DB) GetFolderContents(ctx context.Context, ownerID uuid.UUID, folderID
 			FROM documents d
 			JOIN users u ON d.owner_id = u.id
 			JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
-			WHERE d.folder_id IS NULL
+			WHERE d.folder_id IS NULL AND d.deleted_at IS NULL
 			ORDER BY d.updated_at DESC
-		`, ownerID)
+		`, userID)
 	} else {
 		rows, err = db.pool.Query(ctx, `
 			SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at,
@@ -969,15 +2701,16 @@ func (db *DB) GetFolderContents(ctx context.Context, ownerID uuid.UUID, folderID
 			FROM documents d
 			JOIN users u ON d.owner_id = u.id
 			JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
-			WHERE d.folder_id = $2
+			WHERE d.folder_id = $2 AND d.deleted_at IS NULL
 			ORDER BY d.updated_at DESC
-		`, ownerID, folderID)
+		`, userID, folderID)
 	}
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	docs := []*models.Document{}
 	for rows.Next() {
 		var doc models.Document
 		var owner models.User
@@ -990,13 +2723,9 @@ func (db *DB) GetFolderContents(ctx context.Context, ownerID uuid.UUID, folderID
 			return nil, err
 		}
 		doc.Owner = &owner
-		contents.Documents = append(contents.Documents, &doc)
-	}
-	if contents.Documents == nil {
-		contents.Documents = []*models.Document{}
+		docs = append(docs, &doc)
 	}
-
-	return contents, nil
+	return docs, nil
 }
 
 // MoveDocument moves a document to a folder (nil = root)
@@ -1008,24 +2737,385 @@ func (db *DB) MoveDocument(ctx context.Context, docID uuid.UUID, folderID *uuid.
 	return err
 }
 
+// ErrFolderCycle is returned by MoveFolder when the new parent is the folder itself or one of its descendants
+var ErrFolderCycle = errors.New("cannot move a folder into its own descendant")
+
 // MoveFolder moves a folder to a new parent (nil = root)
 func (db *DB) MoveFolder(ctx context.Context, folderID uuid.UUID, parentID *uuid.UUID) error {
-	_, err := db.pool.Exec(ctx, `
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if parentID != nil {
+		// Walk up the parent chain of the new parent; finding the folder there
+		// means the move would turn the tree into a loop
+		var cycle bool
+		err = tx.QueryRow(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id, ARRAY[id] as visited
+				FROM folders WHERE id = $2
+
+				UNION ALL
+
+				SELECT f.id, f.parent_id, a.visited || f.id
+				FROM folders f
+				INNER JOIN ancestors a ON f.id = a.parent_id
+				WHERE NOT f.id = ANY(a.visited)
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $1)
+		`, folderID, *parentID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
 		UPDATE folders SET parent_id = $2, updated_at = NOW()
 		WHERE id = $1
 	`, folderID, parentID)
+	if err != nil {
+		return err
+	}
+
+	if err := refreshFolderPaths(ctx, tx, folderID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ErrSubtreeTooLarge is returned by DuplicateFolder when the subtree exceeds the node limit
+var ErrSubtreeTooLarge = errors.New("folder subtree too large")
+
+// DuplicateFolder copies a folder, its subfolders and their documents (latest
+// snapshot only, trashed documents skipped) next to the original, owned by
+// ownerID. The copy of the root is named newName. Fails with ErrSubtreeTooLarge
+// when folders plus documents exceed maxNodes.
+func (db *DB) DuplicateFolder(ctx context.Context, srcFolderID, ownerID uuid.UUID, newName string, maxNodes int) (*models.Folder, int, int, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Parents come before their children, so every parent is copied first.
+	// The depth bound keeps the walk finite even if parent links form a loop.
+	rows, err := tx.Query(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id, parent_id, name, 0 AS depth FROM folders WHERE id = $1
+			UNION
+			SELECT f.id, f.parent_id, f.name, s.depth + 1
+			FROM folders f INNER JOIN subtree s ON f.parent_id = s.id
+			WHERE s.depth < $2
+		)
+		SELECT id, parent_id, name FROM subtree ORDER BY depth
+	`, srcFolderID, maxNodes)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var folders []models.Folder
+	for rows.Next() {
+		var f models.Folder
+		if err := rows.Scan(&f.ID, &f.ParentID, &f.Name); err != nil {
+			rows.Close()
+			return nil, 0, 0, err
+		}
+		folders = append(folders, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	if len(folders) == 0 {
+		return nil, 0, 0, pgx.ErrNoRows
+	}
+
+	folderIDs := make([]string, len(folders))
+	for i, f := range folders {
+		folderIDs[i] = f.ID.String()
+	}
+	var docCount int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM documents
+		WHERE folder_id = ANY($1::uuid[]) AND deleted_at IS NULL
+	`, folderIDs).Scan(&docCount)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(folders)+docCount > maxNodes {
+		return nil, 0, 0, ErrSubtreeTooLarge
+	}
+
+	// Map each source folder to its copy
+	copies := make(map[uuid.UUID]uuid.UUID, len(folders))
+	var root models.Folder
+	for i, f := range folders {
+		name, parentID := f.Name, f.ParentID
+		if i == 0 {
+			name = newName
+		} else {
+			newParent := copies[*f.ParentID]
+			parentID = &newParent
+		}
+
+		var copied models.Folder
+		err := tx.QueryRow(ctx, `
+			INSERT INTO folders (name, owner_id, parent_id)
+			VALUES ($1, $2, $3)
+			RETURNING id, name, owner_id, parent_id, created_at, updated_at
+		`, name, ownerID, parentID).Scan(
+			&copied.ID, &copied.Name, &copied.OwnerID, &copied.ParentID, &copied.CreatedAt, &copied.UpdatedAt,
+		)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		copies[f.ID] = copied.ID
+		if i == 0 {
+			root = copied
+		}
+	}
+
+	docRows, err := tx.Query(ctx, `
+		SELECT id, title, folder_id FROM documents
+		WHERE folder_id = ANY($1::uuid[]) AND deleted_at IS NULL
+	`, folderIDs)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	type docRef struct {
+		id, folderID uuid.UUID
+		title        string
+	}
+	var docs []docRef
+	for docRows.Next() {
+		var d docRef
+		if err := docRows.Scan(&d.id, &d.title, &d.folderID); err != nil {
+			docRows.Close()
+			return nil, 0, 0, err
+		}
+		docs = append(docs, d)
+	}
+	docRows.Close()
+	if err := docRows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	for _, d := range docs {
+		var newDocID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			INSERT INTO documents (title, owner_id, folder_id)
+			VALUES ($1, $2, $3)
+			RETURNING id
+		`, d.title, ownerID, copies[d.folderID]).Scan(&newDocID)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO document_permissions (doc_id, user_id, role)
+			VALUES ($1, $2, 'owner')
+		`, newDocID, ownerID)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO doc_snapshots (doc_id, version, snapshot, checksum)
+			SELECT $1, 1, snapshot, checksum
+			FROM doc_snapshots
+			WHERE doc_id = $2
+			ORDER BY version DESC
+			LIMIT 1
+		`, newDocID, d.id)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	if err := refreshFolderPaths(ctx, tx, root.ID); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, 0, 0, err
+	}
+	return &root, len(folders), len(docs), nil
+}
+
+// TransferFolder hands a folder, its subfolders and the documents in them owned
+// by fromUserID over to toUserID in one transaction. The folder moves to the new
+// owner's root; the new owner gets the owner role on the documents and the
+// previous owner keeps edit access. Returns the number of folders and documents moved.
+func (db *DB) TransferFolder(ctx context.Context, folderID, fromUserID, toUserID uuid.UUID) (int64, int64, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	subtree := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders WHERE id = $1
+			UNION
+			SELECT f.id FROM folders f INNER JOIN subtree s ON f.parent_id = s.id
+		)`
+
+	folderTag, err := tx.Exec(ctx, subtree+`
+		UPDATE folders SET owner_id = $2, updated_at = NOW()
+		WHERE id IN (SELECT id FROM subtree)
+	`, folderID, toUserID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Detach from the previous owner's tree
+	_, err = tx.Exec(ctx, `UPDATE folders SET parent_id = NULL WHERE id = $1`, folderID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rows, err := tx.Query(ctx, subtree+`
+		UPDATE documents SET owner_id = $3, updated_at = NOW()
+		WHERE folder_id IN (SELECT id FROM subtree) AND owner_id = $2
+		RETURNING id
+	`, folderID, fromUserID, toUserID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var docIDs []string
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		docIDs = append(docIDs, id.String())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if len(docIDs) > 0 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO document_permissions (doc_id, user_id, role)
+			SELECT id, $2, 'owner' FROM unnest($1::uuid[]) AS id
+			ON CONFLICT (doc_id, user_id) DO UPDATE SET role = 'owner'
+		`, docIDs, toUserID)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE document_permissions SET role = 'edit'
+			WHERE doc_id = ANY($1::uuid[]) AND user_id = $2 AND role = 'owner'
+		`, docIDs, fromUserID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := refreshFolderPaths(ctx, tx, folderID); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	return folderTag.RowsAffected(), int64(len(docIDs)), nil
+}
+
+// FolderNameExists reports whether the owner already has a folder with the given
+// name (case-insensitive) under parentID (nil = root), ignoring excludeID
+func (db *DB) FolderNameExists(ctx context.Context, ownerID uuid.UUID, parentID *uuid.UUID, name string, excludeID *uuid.UUID) (bool, error) {
+	var exists bool
+	err := db.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM folders
+			WHERE owner_id = $1
+			  AND parent_id IS NOT DISTINCT FROM $2::uuid
+			  AND LOWER(name) = LOWER($3)
+			  AND ($4::uuid IS NULL OR id <> $4::uuid)
+		)
+	`, ownerID, parentID, name, excludeID).Scan(&exists)
+	return exists, err
+}
+
+// refreshFolderPaths recomputes the materialized path of a folder and all its
+// descendants from the folder names along the parent chain
+func refreshFolderPaths(ctx context.Context, tx pgx.Tx, folderID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, name, 0 as depth, ARRAY[id] as visited
+			FROM folders WHERE id = $1
+
+			UNION ALL
+
+			SELECT f.id, f.parent_id, f.name, a.depth + 1, a.visited || f.id
+			FROM folders f
+			INNER JOIN ancestors a ON f.id = a.parent_id
+			WHERE NOT f.id = ANY(a.visited)
+		),
+		subtree AS (
+			SELECT $1::uuid as id,
+				(SELECT string_agg('/' || name, '' ORDER BY depth DESC) FROM ancestors) as path,
+				ARRAY[$1::uuid] as visited
+
+			UNION ALL
+
+			SELECT f.id, s.path || '/' || f.name, s.visited || f.id
+			FROM folders f
+			INNER JOIN subtree s ON f.parent_id = s.id
+			WHERE NOT f.id = ANY(s.visited)
+		)
+		UPDATE folders f SET path = subtree.path
+		FROM subtree
+		WHERE f.id = subtree.id AND f.path IS DISTINCT FROM subtree.path
+	`, folderID)
 	return err
 }
 
+// RebuildFolderPaths recomputes the materialized path of every folder owned by
+// the user, backfilling missing paths and repairing stale ones.
+// Returns the number of folders whose path changed.
+func (db *DB) RebuildFolderPaths(ctx context.Context, ownerID uuid.UUID) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		WITH RECURSIVE folder_tree AS (
+			SELECT f.id, '/' || f.name as path
+			FROM folders f
+			WHERE f.owner_id = $1 AND f.parent_id IS NULL
+
+			UNION ALL
+
+			SELECT f.id, ft.path || '/' || f.name as path
+			FROM folders f
+			INNER JOIN folder_tree ft ON f.parent_id = ft.id
+			WHERE f.owner_id = $1
+		)
+		UPDATE folders f SET path = folder_tree.path
+		FROM folder_tree
+		WHERE f.id = folder_tree.id AND f.path IS DISTINCT FROM folder_tree.path
+	`, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 // GetFolderTree returns the complete folder tree for a user using WITH RECURSIVE
 func (db *DB) GetFolderTree(ctx context.Context, ownerID uuid.UUID) ([]*models.FolderTreeNode, error) {
 	rows, err := db.pool.Query(ctx, `
 		WITH RECURSIVE folder_tree AS (
 			-- Base case: root folders (no parent)
+			-- Paths come from the materialized column, computed only when it is not yet backfilled
 			SELECT 
 				f.id, f.name, f.owner_id, f.parent_id, f.created_at, f.updated_at,
 				0 as level,
-				'/' || f.name as path
+				COALESCE(f.path, '/' || f.name) as path
 			FROM folders f
 			WHERE f.owner_id = $1 AND f.parent_id IS NULL
 			
@@ -1035,7 +3125,7 @@ func (db *DB) GetFolderTree(ctx context.Context, ownerID uuid.UUID) ([]*models.F
 			SELECT 
 				f.id, f.name, f.owner_id, f.parent_id, f.created_at, f.updated_at,
 				ft.level + 1 as level,
-				ft.path || '/' || f.name as path
+				COALESCE(f.path, ft.path || '/' || f.name) as path
 			FROM folders f
 			INNER JOIN folder_tree ft ON f.parent_id = ft.id
 			WHERE f.owner_id = $1
@@ -1043,7 +3133,7 @@ func (db *DB) GetFolderTree(ctx context.Context, ownerID uuid.UUID) ([]*models.F
 		SELECT 
 			ft.id, ft.name, ft.owner_id, ft.parent_id, ft.created_at, ft.updated_at,
 			ft.level, ft.path,
-			COALESCE((SELECT COUNT(*) FROM documents d WHERE d.folder_id = ft.id), 0) as doc_count
+			COALESCE((SELECT COUNT(*) FROM documents d WHERE d.folder_id = ft.id AND d.deleted_at IS NULL), 0) as doc_count
 		FROM folder_tree ft
 		ORDER BY ft.path ASC
 	`, ownerID)
@@ -1073,7 +3163,7 @@ func (db *DB) GetFolderTree(ctx context.Context, ownerID uuid.UUID) ([]*models.F
 		SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at
 		FROM documents d
 		JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
-		WHERE d.folder_id IS NOT NULL
+		WHERE d.folder_id IS NOT NULL AND d.deleted_at IS NULL
 		ORDER BY d.title ASC
 	`, ownerID)
 	if err != nil {
@@ -1112,6 +3202,138 @@ func (db *DB) GetFolderTree(ctx context.Context, ownerID uuid.UUID) ([]*models.F
 	return tree, nil
 }
 
+// GetFolderTreeLevel returns the subfolders below a folder (nil = root) down to
+// the given depth, plus the documents directly inside that folder. Folders at
+// the depth boundary report HasChildren so the UI knows they can be expanded.
+func (db *DB) GetFolderTreeLevel(ctx context.Context, ownerID uuid.UUID, folderID *uuid.UUID, depth int) (*models.FolderTreeLevel, error) {
+	level := &models.FolderTreeLevel{}
+
+	// Resolve the starting folder's path so child paths and levels stay absolute
+	basePath := ""
+	baseLevel := 0
+	if folderID != nil {
+		path, err := db.GetFolderPath(ctx, *folderID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range path {
+			basePath += "/" + f.Name
+		}
+		baseLevel = len(path)
+		if len(path) > 0 {
+			level.Folder = path[len(path)-1]
+		}
+	}
+
+	baseCase := "f.parent_id IS NULL"
+	args := []interface{}{ownerID, basePath, baseLevel, baseLevel + depth}
+	if folderID != nil {
+		baseCase = "f.parent_id = $5"
+		args = append(args, *folderID)
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		WITH RECURSIVE folder_tree AS (
+			SELECT
+				f.id, f.name, f.owner_id, f.parent_id, f.created_at, f.updated_at,
+				$3::int as level,
+				COALESCE(f.path, $2 || '/' || f.name) as path
+			FROM folders f
+			WHERE f.owner_id = $1 AND `+baseCase+`
+
+			UNION ALL
+
+			SELECT
+				f.id, f.name, f.owner_id, f.parent_id, f.created_at, f.updated_at,
+				ft.level + 1 as level,
+				COALESCE(f.path, ft.path || '/' || f.name) as path
+			FROM folders f
+			INNER JOIN folder_tree ft ON f.parent_id = ft.id
+			WHERE f.owner_id = $1 AND ft.level + 1 < $4
+		)
+		SELECT
+			ft.id, ft.name, ft.owner_id, ft.parent_id, ft.created_at, ft.updated_at,
+			ft.level, ft.path,
+			COALESCE((SELECT COUNT(*) FROM documents d WHERE d.folder_id = ft.id AND d.deleted_at IS NULL), 0) as doc_count,
+			EXISTS (SELECT 1 FROM folders c WHERE c.parent_id = ft.id) as has_children
+		FROM folder_tree ft
+		ORDER BY ft.path ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*models.FolderTreeNode
+	var ids []string
+	for rows.Next() {
+		var node models.FolderTreeNode
+		err := rows.Scan(
+			&node.ID, &node.Name, &node.OwnerID, &node.ParentID,
+			&node.CreatedAt, &node.UpdatedAt, &node.Level, &node.Path, &node.DocCount, &node.HasChildren,
+		)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &node)
+		ids = append(ids, node.ID.String())
+	}
+
+	// Link the loaded nodes; the top of the slice hangs off folderID rather than nil
+	nodeMap := make(map[uuid.UUID]*models.FolderTreeNode)
+	for _, node := range nodes {
+		node.Children = []*models.FolderTreeNode{}
+		nodeMap[node.ID] = node
+	}
+	level.Children = []*models.FolderTreeNode{}
+	for _, node := range nodes {
+		if node.ParentID != nil {
+			if parent, ok := nodeMap[*node.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		level.Children = append(level.Children, node)
+	}
+
+	// Attach documents for the loaded folders only
+	if len(ids) > 0 {
+		docRows, err := db.pool.Query(ctx, `
+			SELECT d.id, d.title, d.owner_id, d.folder_id, d.created_at, d.updated_at
+			FROM documents d
+			JOIN document_permissions dp ON d.id = dp.doc_id AND dp.user_id = $1
+			WHERE d.folder_id = ANY($2::uuid[]) AND d.deleted_at IS NULL
+			ORDER BY d.title ASC
+		`, ownerID, ids)
+		if err != nil {
+			return nil, err
+		}
+		defer docRows.Close()
+
+		folderDocs := make(map[uuid.UUID][]*models.Document)
+		for docRows.Next() {
+			var doc models.Document
+			err := docRows.Scan(
+				&doc.ID, &doc.Title, &doc.OwnerID, &doc.FolderID, &doc.CreatedAt, &doc.UpdatedAt,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if doc.FolderID != nil {
+				folderDocs[*doc.FolderID] = append(folderDocs[*doc.FolderID], &doc)
+			}
+		}
+		attachDocumentsToTree(level.Children, folderDocs)
+	}
+
+	level.Documents, err = db.listFolderDocuments(ctx, ownerID, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return level, nil
+}
+
 // attachDocumentsToTree recursively attaches documents to folder nodes
 func attachDocumentsToTree(nodes []*models.FolderTreeNode, folderDocs map[uuid.UUID][]*models.Document) {
 	for _, node := range nodes {
@@ -1147,6 +3369,7 @@ func buildFolderTree(nodes []*models.FolderTreeNode) []*models.FolderTreeNode {
 		} else {
 			if parent, ok := nodeMap[*node.ParentID]; ok {
 				parent.Children = append(parent.Children, node)
+				parent.HasChildren = true
 			}
 		}
 	}
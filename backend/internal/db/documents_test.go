@@ -0,0 +1,31 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+)
+
+func TestCreatedDocumentsAreOwned(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+
+	doc, err := database.CreateDocument(ctx, "Plain", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Permission != models.RoleOwner {
+		t.Errorf("CreateDocument: permission = %q, want %q", doc.Permission, models.RoleOwner)
+	}
+
+	welcome, err := database.CreateDocumentWithInitialContent(ctx, "Welcome", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if welcome.Permission != models.RoleOwner {
+		t.Errorf("CreateDocumentWithInitialContent: permission = %q, want %q", welcome.Permission, models.RoleOwner)
+	}
+}
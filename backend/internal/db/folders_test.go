@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestFolderPaths(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+
+	folder := func(name string, parent *models.Folder) *models.Folder {
+		var parentID *uuid.UUID
+		if parent != nil {
+			parentID = &parent.ID
+		}
+		f, err := database.CreateFolder(ctx, name, owner.ID, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// checkPaths compares the stored (materialized) paths
+	checkPaths := func(step string, want map[*models.Folder]string) {
+		t.Helper()
+		for f, path := range want {
+			var got string
+			if err := dbtest.QueryRow(t, `SELECT COALESCE(path, '') FROM folders WHERE id = $1`, f.ID).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != path {
+				t.Errorf("%s: path of %s = %q, want %q", step, f.Name, got, path)
+			}
+		}
+	}
+
+	a := folder("A", nil)
+	b := folder("B", a)
+	c := folder("C", b)
+	checkPaths("create", map[*models.Folder]string{a: "/A", b: "/A/B", c: "/A/B/C"})
+
+	if err := database.MoveFolder(ctx, b.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkPaths("move", map[*models.Folder]string{a: "/A", b: "/B", c: "/B/C"})
+
+	if _, err := database.UpdateFolder(ctx, b.ID, "Beta"); err != nil {
+		t.Fatal(err)
+	}
+	checkPaths("rename", map[*models.Folder]string{b: "/Beta", c: "/Beta/C"})
+
+	dbtest.Exec(t, `UPDATE folders SET path = '/wrong' WHERE id = $1`, c.ID)
+	updated, err := database.RebuildFolderPaths(ctx, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 {
+		t.Errorf("rebuild updated %d folders, want 1", updated)
+	}
+	checkPaths("rebuild", map[*models.Folder]string{a: "/A", b: "/Beta", c: "/Beta/C"})
+
+	if updated, err := database.RebuildFolderPaths(ctx, owner.ID); err != nil || updated != 0 {
+		t.Errorf("second rebuild: updated %d, err %v; want 0, nil", updated, err)
+	}
+}
@@ -0,0 +1,75 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+)
+
+func TestResolveEffectivePermission(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+
+	owner := dbtest.User(t, database, "Owner")
+	rowlessOwner := dbtest.User(t, database, "RowlessOwner")
+	editor := dbtest.User(t, database, "Editor")
+	linkUser := dbtest.User(t, database, "LinkUser")
+	stranger := dbtest.User(t, database, "Stranger")
+
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+	dbtest.Grant(t, database, doc.ID, editor.ID, models.RoleEdit)
+	link, err := database.CreateShareLink(ctx, "token-"+doc.ID.String(), doc.ID, owner.ID, models.RoleComment, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.RedeemShareLink(ctx, link, linkUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Ownership alone counts even when the owner's permission row is missing
+	rowless := dbtest.Document(t, database, rowlessOwner.ID, "Rowless")
+	dbtest.Exec(t, `DELETE FROM document_permissions WHERE doc_id = $1`, rowless.ID)
+
+	tests := []struct {
+		name string
+		doc  *models.Document
+		user *models.User
+		want string
+	}{
+		{"owner", doc, owner, models.RoleOwner},
+		{"owner without a permission row", rowless, rowlessOwner, models.RoleOwner},
+		{"direct permission", doc, editor, models.RoleEdit},
+		{"redeemed share link", doc, linkUser, models.RoleComment},
+		{"no access", doc, stranger, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perm, err := database.ResolveEffectivePermission(ctx, tt.doc.ID, tt.user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if perm != nil {
+				got = perm.Role
+				if perm.Trashed {
+					t.Error("Trashed is set for a document outside the trash")
+				}
+			}
+			if got != tt.want {
+				t.Errorf("role = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := database.SoftDeleteDocument(ctx, doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	perm, err := database.ResolveEffectivePermission(ctx, doc.ID, editor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm == nil || perm.Role != models.RoleEdit || !perm.Trashed {
+		t.Errorf("trashed document: got %+v, want role edit with Trashed set", perm)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no rows", pgx.ErrNoRows, false},
+		{"canceled", context.Canceled, false},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"EOF", io.ErrUnexpectedEOF, true},
+		{"network", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true},
+		{"other", errors.New("syntax error"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientError(tt.err); got != tt.want {
+			t.Errorf("%s: isTransientError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithReadRetry(t *testing.T) {
+	transient := &pgconn.PgError{Code: "08006"}
+
+	// stub fails with the given errors in turn, then succeeds
+	stub := func(errs ...error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+
+	t.Run("fails once then succeeds", func(t *testing.T) {
+		query, calls := stub(transient)
+		if err := withReadRetry(context.Background(), query); err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+		if *calls != 2 {
+			t.Errorf("calls = %d, want 2", *calls)
+		}
+	})
+
+	t.Run("permanent error is not retried", func(t *testing.T) {
+		query, calls := stub(pgx.ErrNoRows)
+		if err := withReadRetry(context.Background(), query); !errors.Is(err, pgx.ErrNoRows) {
+			t.Fatalf("err = %v, want %v", err, pgx.ErrNoRows)
+		}
+		if *calls != 1 {
+			t.Errorf("calls = %d, want 1", *calls)
+		}
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		query, calls := stub(transient, transient, transient, transient)
+		if err := withReadRetry(context.Background(), query); !errors.Is(err, transient) {
+			t.Fatalf("err = %v, want %v", err, transient)
+		}
+		if *calls != readRetryAttempts {
+			t.Errorf("calls = %d, want %d", *calls, readRetryAttempts)
+		}
+	})
+
+	t.Run("canceled context stops retrying", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		query, calls := stub(transient)
+		if err := withReadRetry(ctx, query); !errors.Is(err, transient) {
+			t.Fatalf("err = %v, want %v", err, transient)
+		}
+		if *calls != 1 {
+			t.Errorf("calls = %d, want 1", *calls)
+		}
+	})
+}
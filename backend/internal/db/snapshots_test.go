@@ -0,0 +1,197 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+	"github.com/google/uuid"
+)
+
+func TestGetLatestSnapshotVersions(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+
+	user := dbtest.User(t, database, "User")
+	other := dbtest.User(t, database, "Other")
+
+	owned := dbtest.Document(t, database, user.ID, "Owned")
+	rowless := dbtest.Document(t, database, user.ID, "Owned without a permission row")
+	dbtest.Exec(t, `DELETE FROM document_permissions WHERE doc_id = $1`, rowless.ID)
+	shared := dbtest.Document(t, database, other.ID, "Shared")
+	dbtest.Grant(t, database, shared.ID, user.ID, models.RoleView)
+	private := dbtest.Document(t, database, other.ID, "Private")
+	trashed := dbtest.Document(t, database, user.ID, "Trashed")
+
+	for i, doc := range []*models.Document{owned, owned, shared, private, trashed} {
+		block := yjstest.Block{Name: "paragraph", ID: "p", Text: string(rune('a' + i))}
+		if _, err := database.SaveSnapshot(ctx, doc.ID, yjstest.Document(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.SoftDeleteDocument(ctx, trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := database.GetLatestSnapshotVersions(ctx, user.ID,
+		[]uuid.UUID{owned.ID, rowless.ID, shared.ID, private.ID, trashed.ID, uuid.New()})
+	if err != nil {
+		t.Fatalf("GetLatestSnapshotVersions: %v", err)
+	}
+	want := map[uuid.UUID]int{owned.ID: 2, rowless.ID: 0, shared.ID: 1}
+	if len(versions) != len(want) {
+		t.Errorf("got %d documents %v, want %v", len(versions), versions, want)
+	}
+	for id, version := range want {
+		if got, ok := versions[id]; !ok || got != version {
+			t.Errorf("version of %s = %d (present %v), want %d", id, got, ok, version)
+		}
+	}
+	for _, id := range []uuid.UUID{private.ID, trashed.ID} {
+		if _, ok := versions[id]; ok {
+			t.Errorf("inaccessible document %s was reported", id)
+		}
+	}
+}
+
+func TestSnapshotByVersionVerifiesChecksum(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+
+	var contents [][]byte
+	for _, text := range []string{"first", "second", "third"} {
+		content := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: text})
+		if _, err := database.SaveSnapshot(ctx, doc.ID, content); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	// Version 1 is corrupted on disk; version 2 predates checksums
+	dbtest.Exec(t, `UPDATE doc_snapshots SET snapshot = snapshot || '\x00'::bytea WHERE doc_id = $1 AND version = 1`, doc.ID)
+	dbtest.Exec(t, `UPDATE doc_snapshots SET checksum = NULL WHERE doc_id = $1 AND version = 2`, doc.ID)
+
+	if _, err := database.GetSnapshotByVersion(ctx, doc.ID, 1); !errors.Is(err, db.ErrSnapshotCorrupt) {
+		t.Errorf("corrupted version: err = %v, want ErrSnapshotCorrupt", err)
+	}
+	if _, err := database.RestoreSnapshot(ctx, doc.ID, 1); !errors.Is(err, db.ErrSnapshotCorrupt) {
+		t.Errorf("restoring a corrupted version: err = %v, want ErrSnapshotCorrupt", err)
+	}
+	for version := 2; version <= 3; version++ {
+		snapshot, err := database.GetSnapshotByVersion(ctx, doc.ID, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if snapshot == nil || !bytes.Equal(snapshot.Snapshot, contents[version-1]) {
+			t.Errorf("version %d: got %+v, want its saved content", version, snapshot)
+		}
+	}
+	if snapshot, err := database.GetSnapshotByVersion(ctx, doc.ID, 9); err != nil || snapshot != nil {
+		t.Errorf("missing version: got %+v, %v, want nil, nil", snapshot, err)
+	}
+}
+
+func TestLatestSnapshotFallsBackOnChecksumMismatch(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+
+	if snapshot, err := database.GetLatestSnapshot(ctx, doc.ID); err != nil || snapshot != nil {
+		t.Fatalf("no snapshots: got %+v, %v, want nil, nil", snapshot, err)
+	}
+
+	var contents [][]byte
+	for _, text := range []string{"first", "second"} {
+		content := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: text})
+		if _, err := database.SaveSnapshot(ctx, doc.ID, content); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	corrupt := func(version int) {
+		dbtest.Exec(t, `UPDATE doc_snapshots SET snapshot = snapshot || '\x00'::bytea WHERE doc_id = $1 AND version = $2`, doc.ID, version)
+	}
+	latest := func(t *testing.T, version int) {
+		t.Helper()
+		snapshot, err := database.GetLatestSnapshot(ctx, doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot == nil || snapshot.Version != version || !bytes.Equal(snapshot.Snapshot, contents[version-1]) {
+			t.Errorf("got %+v, want version %d with its saved content", snapshot, version)
+		}
+	}
+
+	t.Run("matching checksum", func(t *testing.T) {
+		latest(t, 2)
+	})
+	t.Run("corrupted latest", func(t *testing.T) {
+		corrupt(2)
+		latest(t, 1)
+	})
+	t.Run("all corrupted", func(t *testing.T) {
+		corrupt(1)
+		if _, err := database.GetLatestSnapshot(ctx, doc.ID); !errors.Is(err, db.ErrSnapshotCorrupt) {
+			t.Errorf("err = %v, want ErrSnapshotCorrupt", err)
+		}
+	})
+}
+
+func TestSaveSnapshotSkipsUnchanged(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+	owner := dbtest.User(t, database, "Owner")
+	doc := dbtest.Document(t, database, owner.ID, "Doc")
+
+	first := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "first"})
+	second := yjstest.Document(yjstest.Block{Name: "paragraph", ID: "p", Text: "second"})
+	versions := func() int {
+		t.Helper()
+		snapshots, err := database.ListSnapshots(ctx, doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(snapshots)
+	}
+
+	steps := []struct {
+		name     string
+		data     []byte
+		base64   bool
+		wantErr  error
+		versions int
+	}{
+		{"first save", first, false, nil, 1},
+		{"same bytes", first, false, db.ErrSnapshotUnchanged, 1},
+		{"same bytes as base64", first, true, db.ErrSnapshotUnchanged, 1},
+		{"changed", second, false, nil, 2},
+		// Only the latest version is compared, so going back to earlier content is a change
+		{"back to the first content", first, true, nil, 3},
+	}
+	for _, step := range steps {
+		var snapshot *models.DocSnapshot
+		var err error
+		if step.base64 {
+			snapshot, err = database.SaveSnapshotBase64(ctx, doc.ID, base64.StdEncoding.EncodeToString(step.data))
+		} else {
+			snapshot, err = database.SaveSnapshot(ctx, doc.ID, step.data)
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+		}
+		if err == nil && snapshot.Version != step.versions {
+			t.Errorf("%s: saved version %d, want %d", step.name, snapshot.Version, step.versions)
+		}
+		if got := versions(); got != step.versions {
+			t.Errorf("%s: %d versions stored, want %d", step.name, got, step.versions)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/collab-docs/backend/internal/dbtest"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestListInactiveUsers(t *testing.T) {
+	database := dbtest.New(t)
+	ctx := context.Background()
+
+	// Every user below registered long ago; only their later activity differs
+	backdate := func(user *models.User) *models.User {
+		dbtest.Exec(t, `UPDATE users SET created_at = NOW() - INTERVAL '48 hours' WHERE id = $1`, user.ID)
+		return user
+	}
+	idle := backdate(dbtest.User(t, database, "Idle"))
+	idleOwner := backdate(dbtest.User(t, database, "IdleOwner"))
+	loggedIn := backdate(dbtest.User(t, database, "LoggedIn"))
+	refreshed := backdate(dbtest.User(t, database, "Refreshed"))
+	keyUser := backdate(dbtest.User(t, database, "KeyUser"))
+	editor := backdate(dbtest.User(t, database, "Editor"))
+	sharer := backdate(dbtest.User(t, database, "Sharer"))
+	collaborator := dbtest.User(t, database, "Collaborator")
+
+	oldDoc := dbtest.Document(t, database, idleOwner.ID, "Old")
+	dbtest.Exec(t, `UPDATE documents SET updated_at = NOW() - INTERVAL '48 hours' WHERE id = $1`, oldDoc.ID)
+	dbtest.Document(t, database, editor.ID, "Recently edited")
+	shared := dbtest.Document(t, database, sharer.ID, "Shared")
+	dbtest.Exec(t, `UPDATE documents SET updated_at = NOW() - INTERVAL '48 hours' WHERE id = $1`, shared.ID)
+	dbtest.Grant(t, database, shared.ID, collaborator.ID, models.RoleView)
+
+	if err := database.UpdateLastLogin(ctx, loggedIn.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateLastActive(ctx, refreshed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateAPIKey(ctx, keyUser.ID, "ci", "ck_test", "hash-"+keyUser.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.GetUserByAPIKeyHash(ctx, "hash-"+keyUser.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := database.ListInactiveUsers(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ListInactiveUsers: %v", err)
+	}
+	listed := make(map[uuid.UUID]bool)
+	for _, u := range users {
+		listed[u.ID] = true
+	}
+	for _, tt := range []struct {
+		user *models.User
+		want bool
+	}{
+		{idle, true},
+		{idleOwner, true},
+		{loggedIn, false},
+		{refreshed, false},
+		{keyUser, false},
+		{editor, false},
+		{sharer, false},
+		{collaborator, false},
+	} {
+		if listed[tt.user.ID] != tt.want {
+			t.Errorf("%s listed = %v, want %v", tt.user.Name, listed[tt.user.ID], tt.want)
+		}
+	}
+}
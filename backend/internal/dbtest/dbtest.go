@@ -0,0 +1,154 @@
+// Package dbtest gives integration tests a throwaway database. Tests using it
+// are skipped unless TEST_DATABASE_URL points at a Postgres server where the
+// tests may create schemas; each call to New gets its own schema with
+// db/schema.sql applied, dropped again when the test ends.
+package dbtest
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/db"
+	"github.com/collab-docs/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// New returns a connection to a fresh copy of the schema, pointing DATABASE_URL
+// at it for the duration of the test
+func New(t testing.TB) *db.DB {
+	t.Helper()
+	baseURL := os.Getenv("TEST_DATABASE_URL")
+	if baseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	config, err := pgx.ParseConfig(baseURL)
+	if err != nil {
+		t.Fatalf("dbtest: invalid TEST_DATABASE_URL: %v", err)
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	admin, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("dbtest: connect: %v", err)
+	}
+	t.Cleanup(func() { admin.Close(context.Background()) })
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	// uuid-ossp must exist once per database; creating it from concurrent
+	// test packages can race, so serialize on an advisory lock
+	for _, stmt := range []string{
+		`SELECT pg_advisory_lock(7406)`,
+		`CREATE EXTENSION IF NOT EXISTS "uuid-ossp" WITH SCHEMA public`,
+		`SELECT pg_advisory_unlock(7406)`,
+		`CREATE SCHEMA ` + schema,
+		`SET search_path TO ` + schema + `, public`,
+	} {
+		if _, err := admin.Exec(ctx, stmt); err != nil {
+			t.Fatalf("dbtest: %s: %v", stmt, err)
+		}
+	}
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), `DROP SCHEMA `+schema+` CASCADE`)
+	})
+
+	ddl, err := os.ReadFile(schemaPath())
+	if err != nil {
+		t.Fatalf("dbtest: read schema: %v", err)
+	}
+	if _, err := admin.Exec(ctx, string(ddl)); err != nil {
+		t.Fatalf("dbtest: apply schema: %v", err)
+	}
+
+	t.Setenv("DATABASE_URL", withSearchPath(baseURL, schema+",public"))
+	database, err := db.New(ctx)
+	if err != nil {
+		t.Fatalf("dbtest: %v", err)
+	}
+	t.Cleanup(database.Close)
+	return database
+}
+
+// schemaPath locates db/schema.sql relative to this file
+func schemaPath() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "db", "schema.sql")
+}
+
+// withSearchPath adds a search_path runtime parameter to a URL or keyword/value connection string
+func withSearchPath(connString, searchPath string) string {
+	if u, err := url.Parse(connString); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", searchPath)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return connString + " search_path=" + searchPath
+}
+
+// User creates a user with a unique email address
+func User(t testing.TB, database *db.DB, name string) *models.User {
+	t.Helper()
+	email := strings.ToLower(name) + "-" + uuid.NewString()[:8] + "@example.com"
+	user, err := database.CreateUser(context.Background(), email, name)
+	if err != nil {
+		t.Fatalf("dbtest: create user: %v", err)
+	}
+	return user
+}
+
+// Document creates a document owned by ownerID
+func Document(t testing.TB, database *db.DB, ownerID uuid.UUID, title string) *models.Document {
+	t.Helper()
+	doc, err := database.CreateDocument(context.Background(), title, ownerID)
+	if err != nil {
+		t.Fatalf("dbtest: create document: %v", err)
+	}
+	return doc
+}
+
+// Grant gives a user a direct role on a document
+func Grant(t testing.TB, database *db.DB, docID, userID uuid.UUID, role string) {
+	t.Helper()
+	if err := database.SetPermission(context.Background(), docID, userID, role); err != nil {
+		t.Fatalf("dbtest: grant %s: %v", role, err)
+	}
+}
+
+// Exec runs SQL against the schema of the latest New, for fixtures the DB API
+// cannot set up (such as backdated timestamps)
+func Exec(t testing.TB, sql string, args ...interface{}) {
+	t.Helper()
+	if _, err := connect(t).Exec(context.Background(), sql, args...); err != nil {
+		t.Fatalf("dbtest: %s: %v", sql, err)
+	}
+}
+
+// QueryRow runs a query against the schema of the latest New, for checking
+// stored values the DB API does not return as-is
+func QueryRow(t testing.TB, sql string, args ...interface{}) pgx.Row {
+	t.Helper()
+	return connect(t).QueryRow(context.Background(), sql, args...)
+}
+
+// connect opens a connection to the schema of the latest New, closed when the test ends
+func connect(t testing.TB) *pgx.Conn {
+	t.Helper()
+	config, err := pgx.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatalf("dbtest: %v", err)
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	conn, err := pgx.ConnectConfig(context.Background(), config)
+	if err != nil {
+		t.Fatalf("dbtest: connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return conn
+}
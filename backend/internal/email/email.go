@@ -0,0 +1,89 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"github.com/collab-docs/backend/internal/logger"
+)
+
+// EmailSender delivers transactional email (password resets, verification
+// links, access request decisions)
+type EmailSender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewFromEnv returns an SMTPSender when SMTP_HOST is set and a LogSender
+// otherwise, so sending is always safe
+func NewFromEnv() EmailSender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogSender{LogBody: strings.EqualFold(os.Getenv("APP_ENV"), "dev")}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USERNAME")
+	}
+	return &SMTPSender{
+		Addr:     host + ":" + port,
+		Host:     host,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// LogSender writes messages to the log instead of sending them. Bodies may
+// carry tokens, so they are only logged when LogBody is set (APP_ENV=dev).
+type LogSender struct {
+	LogBody bool
+}
+
+// Send logs the message
+func (s LogSender) Send(ctx context.Context, to, subject, body string) error {
+	if s.LogBody {
+		logger.Info("[Email] to=%s subject=%q body=%q", to, subject, body)
+	} else {
+		logger.Info("[Email] to=%s subject=%q (not sent, SMTP_HOST is not set)", to, subject)
+	}
+	return nil
+}
+
+// SMTPSender sends plain-text mail through an SMTP server, authenticating with
+// PLAIN auth when Username is set
+type SMTPSender struct {
+	Addr     string // host:port
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message. net/smtp does not take a context, so ctx is only
+// checked before connecting.
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	to, subject = headerValue(to), headerValue(subject)
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		headerValue(s.From), to, subject, body)
+	return smtp.SendMail(s.Addr, auth, s.From, []string{to}, []byte(msg))
+}
+
+// headerValue drops line breaks so values cannot inject extra headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,51 @@
+package email
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	t.Run("unconfigured", func(t *testing.T) {
+		t.Setenv("SMTP_HOST", "")
+		t.Setenv("APP_ENV", "dev")
+		sender, ok := NewFromEnv().(LogSender)
+		if !ok || !sender.LogBody {
+			t.Fatalf("sender = %#v, want a LogSender logging bodies in dev", sender)
+		}
+		if err := sender.Send(context.Background(), "a@example.com", "Hi", "body"); err != nil {
+			t.Errorf("Send: %v", err)
+		}
+	})
+
+	t.Run("smtp", func(t *testing.T) {
+		t.Setenv("SMTP_HOST", "smtp.example.com")
+		t.Setenv("SMTP_PORT", "")
+		t.Setenv("SMTP_USERNAME", "mailer@example.com")
+		t.Setenv("SMTP_PASSWORD", "secret")
+		t.Setenv("SMTP_FROM", "")
+		sender, ok := NewFromEnv().(*SMTPSender)
+		if !ok {
+			t.Fatalf("sender = %#v, want an SMTPSender", sender)
+		}
+		if sender.Addr != "smtp.example.com:587" || sender.From != "mailer@example.com" {
+			t.Errorf("addr %q, from %q; want the default port and the username as sender", sender.Addr, sender.From)
+		}
+	})
+}
+
+func TestSMTPSenderHonorsCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The address is never dialed because the context is checked first
+	sender := &SMTPSender{Addr: "127.0.0.1:1", Host: "127.0.0.1", From: "a@example.com"}
+	if err := sender.Send(ctx, "b@example.com", "Hi", "body"); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	if got := headerValue("Hi\r\nBcc: victim@example.com"); got != "HiBcc: victim@example.com" {
+		t.Errorf("headerValue = %q, want the line break removed", got)
+	}
+}
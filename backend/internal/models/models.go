@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,13 +9,15 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID           uuid.UUID `json:"id" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"` // Never expose in JSON
-	Name         string    `json:"name" db:"name"`
-	AvatarURL    string    `json:"avatar_url,omitempty" db:"avatar_url"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID            uuid.UUID  `json:"id" db:"id"`
+	Email         string     `json:"email" db:"email"`
+	PasswordHash  string     `json:"-" db:"password_hash"` // Never expose in JSON
+	Name          string     `json:"name" db:"name"`
+	AvatarURL     string     `json:"avatar_url,omitempty" db:"avatar_url"`
+	EmailVerified *bool      `json:"email_verified,omitempty" db:"email_verified"` // only set for full user records
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 // Document represents a collaborative document
@@ -23,12 +26,21 @@ type Document struct {
 	Title     string     `json:"title" db:"title"`
 	OwnerID   uuid.UUID  `json:"owner_id" db:"owner_id"`
 	FolderID  *uuid.UUID `json:"folder_id,omitempty" db:"folder_id"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"` // Set while in the trash
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 
 	// Joined fields
 	Owner      *User  `json:"owner,omitempty"`
 	Permission string `json:"permission,omitempty"`
+	Starred    bool   `json:"starred,omitempty"` // Starred by the requesting user; set in listings
+}
+
+// DocumentPage is one page of a document listing
+type DocumentPage struct {
+	Items   []*Document `json:"items"`
+	Total   int         `json:"total"`
+	HasMore bool        `json:"hasMore"`
 }
 
 // Permission roles
@@ -46,6 +58,9 @@ type DocumentPermission struct {
 	Role      string    `json:"role" db:"role"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 
+	// Trashed is set by ResolveEffectivePermission when the document is in the trash
+	Trashed bool `json:"-" db:"-"`
+
 	// Joined fields
 	User *User `json:"user,omitempty"`
 }
@@ -73,6 +88,24 @@ type DocSnapshot struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+// DocumentStats holds text statistics of a document's latest snapshot
+type DocumentStats struct {
+	Words      int `json:"word_count"`
+	Characters int `json:"character_count"`
+	Paragraphs int `json:"paragraph_count"`
+	Version    int `json:"version"` // Snapshot version counted (0 for an empty document)
+}
+
+// SnapshotVersionsRequest represents a request for the latest snapshot versions of several documents
+type SnapshotVersionsRequest struct {
+	DocIDs []uuid.UUID `json:"doc_ids" binding:"required,min=1,max=200"`
+}
+
+// UserLookupRequest represents a request to resolve user IDs to profiles
+type UserLookupRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1,max=100"`
+}
+
 // Selection represents a text selection in the document
 type Selection struct {
 	Anchor  int    `json:"anchor"`
@@ -92,9 +125,30 @@ type Comment struct {
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 
+	// IsResolution marks the closing note left by ResolveComment (replies only)
+	IsResolution bool `json:"is_resolution,omitempty" db:"is_resolution"`
+
+	// Who resolved the thread and when, while it is resolved
+	ResolvedBy *User      `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+
 	// Joined fields
-	User    *User      `json:"user,omitempty"`
-	Replies []*Comment `json:"replies,omitempty"`
+	User     *User      `json:"user,omitempty"`
+	Replies  []*Comment `json:"replies,omitempty"`
+	Mentions []*User    `json:"mentions,omitempty"`
+	DocTitle string     `json:"doc_title,omitempty"` // ListMentionsForUser only
+
+	// Thread summary (top-level comments in ListComments)
+	ReplyCount  int           `json:"reply_count"`
+	LatestReply *ReplyPreview `json:"latest_reply,omitempty"`
+}
+
+// ReplyPreview summarizes the most recent reply in a comment thread
+type ReplyPreview struct {
+	ID        uuid.UUID `json:"id"`
+	User      *User     `json:"user"`
+	Preview   string    `json:"preview"` // Truncated reply content
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // CreateDocumentRequest represents requests to create a document
@@ -102,6 +156,11 @@ type CreateDocumentRequest struct {
 	Title string `json:"title" binding:"required"`
 }
 
+// DuplicateDocumentRequest represents a request to copy a document; the title defaults to "Copy of <title>"
+type DuplicateDocumentRequest struct {
+	Title string `json:"title,omitempty"`
+}
+
 // UpdateDocumentRequest represents requests to update a document
 type UpdateDocumentRequest struct {
 	Title string `json:"title" binding:"required"`
@@ -113,11 +172,28 @@ type SetPermissionRequest struct {
 	Role   string `json:"role" binding:"required,oneof=owner edit comment view"`
 }
 
+// BulkPermissionEntry is one entry of a bulk permission update; owner cannot be assigned
+type BulkPermissionEntry struct {
+	UserID string `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=edit comment view"`
+}
+
+// TransferOwnershipRequest represents a request to hand a document to another user
+type TransferOwnershipRequest struct {
+	NewOwnerID string `json:"new_owner_id" binding:"required"`
+}
+
 // CreateCommentRequest represents a request to create a comment
 type CreateCommentRequest struct {
 	Content   string     `json:"content" binding:"required"`
 	Selection *Selection `json:"selection,omitempty"`
 	ParentID  *string    `json:"parent_id,omitempty"`
+	Mentions  []string   `json:"mentions,omitempty" binding:"max=20"` // user IDs
+}
+
+// ResolveCommentRequest represents a request to resolve a thread with an optional closing note
+type ResolveCommentRequest struct {
+	Note string `json:"note,omitempty"`
 }
 
 // UpdateCommentRequest represents a request to update a comment
@@ -173,8 +249,24 @@ type LoginRequest struct {
 
 // LoginResponse represents a login response
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"` // Omitted when Redis is not available
+	User         *User  `json:"user"`
+}
+
+// RefreshTokenRequest represents a request to exchange a refresh token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest optionally names the refresh token whose chain ends with the session
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ValidateTokenRequest represents a request to check a JWT
+type ValidateTokenRequest struct {
+	Token string `json:"token" binding:"required"`
 }
 
 // ChangePasswordRequest represents a password change request
@@ -188,17 +280,89 @@ type ForgotPasswordRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+// VerifyEmailRequest represents a request to confirm an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
 // ResetPasswordRequest represents a password reset request
 type ResetPasswordRequest struct {
 	Token       string `json:"token" binding:"required"`
 	NewPassword string `json:"new_password" binding:"required,min=6"`
 }
 
+// APIKey represents a long-lived key for programmatic access.
+// Only the hash of the key is stored; the full key is shown once on creation.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // First characters of the key, for identification
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ShareLink grants a role on a document to any signed-in user who redeems its token
+type ShareLink struct {
+	Token     string     `json:"token" db:"token"`
+	DocID     uuid.UUID  `json:"doc_id" db:"doc_id"`
+	Role      string     `json:"role" db:"role"`
+	CreatedBy uuid.UUID  `json:"created_by" db:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	SingleUse bool       `json:"single_use" db:"single_use"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateShareLinkRequest represents a request to create a share link
+type CreateShareLinkRequest struct {
+	Role      string     `json:"role" binding:"required,oneof=edit comment view"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	SingleUse bool       `json:"single_use,omitempty"`
+}
+
+// AddTagRequest represents a request to tag a document
+type AddTagRequest struct {
+	Tag string `json:"tag" binding:"required"`
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateAPIKeyResponse returns the newly created key (the only time it is visible)
+type CreateAPIKeyResponse struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+// Activity event types
+const (
+	ActivitySnapshot   = "snapshot"
+	ActivityComment    = "comment"
+	ActivityPermission = "permission"
+)
+
+// ActivityEvent represents one entry in a user's activity feed.
+// Events are derived from snapshots, comments and permission grants.
+type ActivityEvent struct {
+	Type      string     `json:"type"`
+	DocID     uuid.UUID  `json:"doc_id"`
+	DocTitle  string     `json:"doc_title"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"` // Commenter or grantee; nil for snapshots
+	UserName  string     `json:"user_name,omitempty"`
+	Detail    string     `json:"detail,omitempty"` // Snapshot version, comment ID or granted role
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 // Access request status constants
 const (
-	AccessRequestPending  = "pending"
-	AccessRequestApproved = "approved"
-	AccessRequestRejected = "rejected"
+	AccessRequestPending   = "pending"
+	AccessRequestApproved  = "approved"
+	AccessRequestRejected  = "rejected"
+	AccessRequestCancelled = "cancelled" // withdrawn by the requester
+	AccessRequestExpired   = "expired"   // not decided before expires_at
 )
 
 // AccessRequest represents a request for document access
@@ -211,12 +375,33 @@ type AccessRequest struct {
 	Message       string    `json:"message,omitempty" db:"message"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
 
 	// Joined fields
 	Requester *User     `json:"requester,omitempty"`
 	Document  *Document `json:"document,omitempty"`
 }
 
+// Notification types
+const (
+	NotificationAccessRequest  = "access-request"  // to the owner: someone asked for access
+	NotificationAccessDecision = "access-decision" // to the requester: approved or rejected
+	NotificationMention        = "mention"         // to a user mentioned in a comment
+	NotificationAccessGranted  = "access-granted"  // to a user given a permission by the owner
+)
+
+// Notification is an inbox entry for one user. New notifications are also
+// published on the user's Redis channel (redis.GetUserChannel) and relayed to
+// their connected clients.
+type Notification struct {
+	ID        uuid.UUID       `json:"id" db:"id"`
+	UserID    uuid.UUID       `json:"user_id" db:"user_id"`
+	Type      string          `json:"type" db:"type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	Read      bool            `json:"read" db:"read"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
 // CreateAccessRequestRequest represents a request to create an access request
 type CreateAccessRequestRequest struct {
 	RequestedRole string `json:"requested_role,omitempty"` // defaults to 'view'
@@ -239,6 +424,11 @@ type Folder struct {
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 }
 
+// DuplicateFolderRequest represents a request to copy a folder subtree; the name defaults to "Copy of <name>"
+type DuplicateFolderRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
 // CreateFolderRequest represents a request to create a folder
 type CreateFolderRequest struct {
 	Name     string     `json:"name" binding:"required"`
@@ -255,6 +445,11 @@ type MoveItemRequest struct {
 	FolderID *uuid.UUID `json:"folder_id"` // NULL = move to root
 }
 
+// TransferFolderRequest represents a request to hand a folder subtree to another user
+type TransferFolderRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
 // FolderContents represents the contents of a folder
 type FolderContents struct {
 	Folder    *Folder     `json:"folder,omitempty"` // nil for root
@@ -264,15 +459,24 @@ type FolderContents struct {
 
 // FolderTreeNode represents a folder node in the tree structure
 type FolderTreeNode struct {
-	ID        uuid.UUID         `json:"id" db:"id"`
-	Name      string            `json:"name" db:"name"`
-	OwnerID   uuid.UUID         `json:"owner_id" db:"owner_id"`
-	ParentID  *uuid.UUID        `json:"parent_id,omitempty" db:"parent_id"`
-	Level     int               `json:"level" db:"level"` // Depth in the tree (0 = root)
-	Path      string            `json:"path" db:"path"`   // Full path like /folder1/folder2
-	CreatedAt time.Time         `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
-	Children  []*FolderTreeNode `json:"children,omitempty"`       // Nested children (built in code)
-	DocCount  int               `json:"doc_count" db:"doc_count"` // Number of documents in this folder
-	Documents []*Document       `json:"documents,omitempty"`      // Documents in this folder
+	ID          uuid.UUID         `json:"id" db:"id"`
+	Name        string            `json:"name" db:"name"`
+	OwnerID     uuid.UUID         `json:"owner_id" db:"owner_id"`
+	ParentID    *uuid.UUID        `json:"parent_id,omitempty" db:"parent_id"`
+	Level       int               `json:"level" db:"level"` // Depth in the tree (0 = root)
+	Path        string            `json:"path" db:"path"`   // Full path like /folder1/folder2
+	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at" db:"updated_at"`
+	Children    []*FolderTreeNode `json:"children,omitempty"`       // Nested children (built in code)
+	HasChildren bool              `json:"has_children"`             // Whether the folder has subfolders (loaded or not)
+	DocCount    int               `json:"doc_count" db:"doc_count"` // Number of documents in this folder
+	Documents   []*Document       `json:"documents,omitempty"`      // Documents in this folder
+}
+
+// FolderTreeLevel represents a depth-limited slice of the folder tree, used by
+// the UI to expand large hierarchies on demand
+type FolderTreeLevel struct {
+	Folder    *Folder           `json:"folder,omitempty"` // nil for root
+	Children  []*FolderTreeNode `json:"children"`         // Subfolders down to the requested depth
+	Documents []*Document       `json:"documents"`        // Documents directly in the folder
 }
@@ -0,0 +1,180 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/collab-docs/backend/internal/logger"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// GetUserChannel returns the channel that carries notifications for one user;
+// the collab server subscribes to all of them (user:*:notifications)
+func GetUserChannel(userID uuid.UUID) string {
+	return "user:" + userID.String() + ":notifications"
+}
+
+// PubSub wraps the Redis client used for cross-instance messaging and
+// short-lived shared state (idempotency keys, tokens, caches)
+type PubSub struct {
+	client *goredis.Client
+}
+
+// New creates a new Redis connection from REDIS_URL
+func New(ctx context.Context) (*PubSub, error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379"
+	}
+
+	opts, err := goredis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+	}
+
+	logger.Info("[Redis] Connecting to redis...")
+	client := goredis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	logger.Info("[Redis] Redis connection established")
+	return &PubSub{client: client}, nil
+}
+
+// Close closes the Redis connection
+func (ps *PubSub) Close() error {
+	return ps.client.Close()
+}
+
+// Publish publishes a message to a channel
+func (ps *PubSub) Publish(ctx context.Context, channel string, message []byte) error {
+	return ps.client.Publish(ctx, channel, message).Err()
+}
+
+// MessageHandler receives the messages delivered to a subscription
+type MessageHandler func(channel string, payload []byte)
+
+// Subscription is an active Subscribe or PSubscribe; Close stops delivery
+type Subscription struct {
+	sub *goredis.PubSub
+}
+
+// Close unsubscribes and releases the subscription's connection
+func (s *Subscription) Close() error {
+	return s.sub.Close()
+}
+
+// Subscribe calls handler for every message published to channel
+func (ps *PubSub) Subscribe(ctx context.Context, channel string, handler MessageHandler) (*Subscription, error) {
+	return listen(ctx, ps.client.Subscribe(ctx, channel), handler)
+}
+
+// PSubscribe calls handler for every message published to a channel matching
+// pattern (e.g. "control:rooms:*"). Each subscription uses its own connection,
+// so a channel matched by both a Subscribe and a PSubscribe reaches each
+// handler exactly once.
+func (ps *PubSub) PSubscribe(ctx context.Context, pattern string, handler MessageHandler) (*Subscription, error) {
+	return listen(ctx, ps.client.PSubscribe(ctx, pattern), handler)
+}
+
+// listen waits for the subscription to be confirmed, so messages published
+// after it returns are not missed, then delivers messages in order until closed
+func listen(ctx context.Context, sub *goredis.PubSub, handler MessageHandler) (*Subscription, error) {
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			handler(msg.Channel, []byte(msg.Payload))
+		}
+	}()
+	return &Subscription{sub: sub}, nil
+}
+
+// Get returns the value stored at key, or nil if the key does not exist
+func (ps *PubSub) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := ps.client.Get(ctx, key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// Set stores a value at key without expiry
+func (ps *PubSub) Set(ctx context.Context, key string, value []byte) error {
+	return ps.client.Set(ctx, key, value, 0).Err()
+}
+
+// SetWithTTL stores a value at key that expires after ttl
+func (ps *PubSub) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return ps.client.Set(ctx, key, value, ttl).Err()
+}
+
+// GetDel atomically returns the value stored at key and deletes it,
+// or nil if the key does not exist (requires Redis 6.2+)
+func (ps *PubSub) GetDel(ctx context.Context, key string) ([]byte, error) {
+	val, err := ps.client.GetDel(ctx, key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// SetNX stores a value at key with a TTL only if the key does not exist yet.
+// Returns true if the value was stored.
+func (ps *PubSub) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return ps.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+// IncrWindow increments a fixed-window counter, starting the window on the
+// first hit. Returns the new count and the time until the window resets.
+func (ps *PubSub) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	pipe := ps.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	ttl := pttl.Val()
+	if ttl < 0 {
+		// First hit of a new window (or a TTL lost earlier): start the window now
+		if err := ps.client.PExpire(ctx, key, window).Err(); err != nil {
+			return 0, 0, err
+		}
+		ttl = window
+	}
+	return incr.Val(), ttl, nil
+}
+
+// AddToSet adds a member to the set at key and restarts the set's expiry
+func (ps *PubSub) AddToSet(ctx context.Context, key, member string, ttl time.Duration) error {
+	pipe := ps.client.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetMembers returns the members of the set at key (none if it does not exist)
+func (ps *PubSub) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return ps.client.SMembers(ctx, key).Result()
+}
+
+// Delete removes keys
+func (ps *PubSub) Delete(ctx context.Context, keys ...string) error {
+	return ps.client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestPubSub(t *testing.T) *PubSub {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	t.Setenv("REDIS_URL", url)
+	ps, err := New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+// recorder collects the payloads delivered to a handler
+type recorder struct {
+	mu       sync.Mutex
+	payloads []string
+}
+
+func (r *recorder) handle(channel string, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payloads = append(r.payloads, channel+" "+string(payload))
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.payloads...)
+}
+
+func TestSubscribeAndPSubscribeCoexist(t *testing.T) {
+	ps := newTestPubSub(t)
+	ctx := context.Background()
+	// A unique prefix keeps concurrent runs against the same server apart
+	prefix := "test:" + uuid.NewString() + ":"
+	channel, other := prefix+"rooms:evict", prefix+"users:1"
+
+	var exact, pattern recorder
+	sub, err := ps.Subscribe(ctx, channel, exact.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	psub, err := ps.PSubscribe(ctx, prefix+"rooms:*", pattern.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer psub.Close()
+
+	for _, msg := range []struct{ channel, payload string }{
+		{channel, "1"},
+		{other, "ignored"},
+		{channel, "2"},
+		{prefix + "rooms:reload", "3"},
+	} {
+		if err := ps.Publish(ctx, msg.channel, []byte(msg.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantExact := []string{channel + " 1", channel + " 2"}
+	wantPattern := []string{channel + " 1", channel + " 2", prefix + "rooms:reload 3"}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exact.get()) < len(wantExact) || len(pattern.get()) < len(wantPattern) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out: Subscribe got %q, PSubscribe got %q", exact.get(), pattern.get())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give duplicates a chance to show up
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tt := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"Subscribe", exact.get(), wantExact},
+		{"PSubscribe", pattern.get(), wantPattern},
+	} {
+		if len(tt.got) != len(tt.want) {
+			t.Errorf("%s received %q, want %q", tt.name, tt.got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if tt.got[i] != tt.want[i] {
+				t.Errorf("%s received %q, want %q", tt.name, tt.got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSubscriptionCloseStopsDelivery(t *testing.T) {
+	ps := newTestPubSub(t)
+	ctx := context.Background()
+	channel := "test:" + uuid.NewString()
+
+	var got recorder
+	sub, err := ps.Subscribe(ctx, channel, got.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(ctx, channel, []byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if payloads := got.get(); len(payloads) != 0 {
+		t.Errorf("received %q after Close", payloads)
+	}
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/collab-docs/backend/internal/logger"
+)
+
+// Event types
+const (
+	EventDocumentCreated = "document.created"
+	EventSnapshotSaved   = "snapshot.saved"
+	EventAccessGranted   = "access.granted"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature" // "sha256=" + hex HMAC-SHA256 of the body
+	EventHeader     = "X-Webhook-Event"
+)
+
+const (
+	maxAttempts    = 4
+	initialBackoff = time.Second
+)
+
+// Event is the JSON payload posted to the webhook URL
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher delivers events to a configured URL in the background
+type Dispatcher struct {
+	url    string
+	secret string
+	events map[string]bool // nil = all events
+	client *http.Client
+}
+
+// NewFromEnv creates a dispatcher from WEBHOOK_URL, WEBHOOK_SECRET and
+// WEBHOOK_EVENTS (comma-separated, default all). Returns nil if WEBHOOK_URL is unset.
+func NewFromEnv() *Dispatcher {
+	url := os.Getenv("WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+
+	d := &Dispatcher{
+		url:    url,
+		secret: os.Getenv("WEBHOOK_SECRET"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if list := os.Getenv("WEBHOOK_EVENTS"); list != "" {
+		d.events = make(map[string]bool)
+		for _, e := range strings.Split(list, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				d.events[e] = true
+			}
+		}
+	}
+	if d.secret == "" {
+		logger.Warn("[Webhook] WEBHOOK_SECRET is not set, deliveries will be unsigned")
+	}
+	logger.Info("[Webhook] Delivering events to %s", url)
+	return d
+}
+
+// Send delivers an event asynchronously; it never blocks the caller.
+// Safe to call on a nil dispatcher (webhooks disabled).
+func (d *Dispatcher) Send(eventType string, data interface{}) {
+	if d == nil || (d.events != nil && !d.events[eventType]) {
+		return
+	}
+
+	body, err := json.Marshal(Event{Type: eventType, Timestamp: time.Now().UTC(), Data: data})
+	if err != nil {
+		logger.Error("[Webhook] failed to encode %s event: %v", eventType, err)
+		return
+	}
+	go d.deliver(eventType, body)
+}
+
+// Sign returns the signature header value for a payload
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts the payload, retrying with exponential backoff on network errors and 5xx responses
+func (d *Dispatcher) deliver(eventType string, body []byte) {
+	backoff := initialBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err := d.post(eventType, body)
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			logger.Error("[Webhook] giving up on %s after %d attempts: %v", eventType, attempt, err)
+			return
+		}
+		logger.Warn("[Webhook] %s delivery failed (attempt %d/%d): %v", eventType, attempt, maxAttempts, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) post(eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if d.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("server responded %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		// Client errors won't succeed on retry
+		logger.Error("[Webhook] %s rejected with status %d", eventType, resp.StatusCode)
+	}
+	return nil
+}
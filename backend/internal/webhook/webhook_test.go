@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// delivery is a request received by the test endpoint
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// newEndpoint starts a webhook receiver answering with the given statuses in
+// turn (then 200) and points WEBHOOK_URL at it
+func newEndpoint(t *testing.T, statuses ...int) <-chan delivery {
+	t.Helper()
+	received := make(chan delivery, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{header: r.Header.Clone(), body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("WEBHOOK_URL", srv.URL)
+	return received
+}
+
+func receive(t *testing.T, received <-chan delivery, timeout time.Duration) *delivery {
+	t.Helper()
+	select {
+	case d := <-received:
+		return &d
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	received := newEndpoint(t)
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+
+	NewFromEnv().Send(EventDocumentCreated, map[string]string{"doc_id": "d1"})
+	d := receive(t, received, 5*time.Second)
+	if d == nil {
+		t.Fatal("webhook was not delivered")
+	}
+
+	if got, want := d.header.Get(SignatureHeader), Sign("s3cret", d.body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got := d.header.Get(SignatureHeader); got == Sign("other", d.body) {
+		t.Error("signature does not depend on the secret")
+	}
+	if got := d.header.Get(EventHeader); got != EventDocumentCreated {
+		t.Errorf("event header = %q, want %q", got, EventDocumentCreated)
+	}
+	var event struct {
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(d.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventDocumentCreated || event.Data["doc_id"] != "d1" {
+		t.Errorf("payload = %s", d.body)
+	}
+}
+
+func TestDeliveryRetriesServerErrors(t *testing.T) {
+	received := newEndpoint(t, http.StatusBadGateway)
+
+	NewFromEnv().Send(EventSnapshotSaved, nil)
+	if receive(t, received, 5*time.Second) == nil {
+		t.Fatal("webhook was not delivered")
+	}
+	if receive(t, received, initialBackoff+5*time.Second) == nil {
+		t.Fatal("failed delivery was not retried")
+	}
+	if d := receive(t, received, initialBackoff+500*time.Millisecond); d != nil {
+		t.Errorf("successful delivery was retried: %s", d.body)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	received := newEndpoint(t)
+	t.Setenv("WEBHOOK_EVENTS", EventAccessGranted)
+
+	d := NewFromEnv()
+	d.Send(EventDocumentCreated, nil)
+	d.Send(EventAccessGranted, nil)
+	got := receive(t, received, 5*time.Second)
+	if got == nil || got.header.Get(EventHeader) != EventAccessGranted {
+		t.Fatalf("delivery = %+v, want only %s", got, EventAccessGranted)
+	}
+	if extra := receive(t, received, 200*time.Millisecond); extra != nil {
+		t.Errorf("filtered event was delivered: %s", extra.body)
+	}
+}
+
+func TestDisabledWithoutURL(t *testing.T) {
+	t.Setenv("WEBHOOK_URL", "")
+	d := NewFromEnv()
+	if d != nil {
+		t.Fatalf("NewFromEnv = %+v, want nil", d)
+	}
+	d.Send(EventDocumentCreated, nil) // must not panic
+}
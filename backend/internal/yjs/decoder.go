@@ -0,0 +1,194 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// errUnexpectedEOF is returned when an update ends in the middle of a value
+var errUnexpectedEOF = errors.New("yjs: unexpected end of update")
+
+// maxAnyDepth bounds the nesting of objects and arrays in "any" values, so a
+// crafted update cannot exhaust the stack
+const maxAnyDepth = 100
+
+// decoder reads lib0-encoded values. Errors are sticky: after the first
+// failure every read returns a zero value and err is kept.
+type decoder struct {
+	buf   []byte
+	pos   int
+	err   error
+	depth int // current nesting of readAny
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) readUint8() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.buf) {
+		d.fail(errUnexpectedEOF)
+		return 0
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+// remaining returns the number of unread bytes
+func (d *decoder) remaining() uint64 {
+	return uint64(len(d.buf) - d.pos)
+}
+
+func (d *decoder) readBytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > d.remaining() {
+		d.fail(errUnexpectedEOF)
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+// readCount reads the number of entries that follow. Every entry takes at
+// least one byte, so a count larger than the rest of the update is malformed;
+// rejecting it keeps hostile counts from driving allocations and loops.
+func (d *decoder) readCount() uint64 {
+	n := d.readVarUint()
+	if n > d.remaining() {
+		d.fail(errUnexpectedEOF)
+		return 0
+	}
+	return n
+}
+
+// readVarUint reads an unsigned integer stored 7 bits per byte, least significant first
+func (d *decoder) readVarUint() uint64 {
+	var num uint64
+	var shift uint
+	for d.err == nil {
+		b := d.readUint8()
+		num |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return num
+		}
+		shift += 7
+		if shift > 63 {
+			d.fail(errors.New("yjs: varuint overflow"))
+		}
+	}
+	return 0
+}
+
+// readVarInt reads a signed integer: the first byte holds a continuation bit,
+// a sign bit and 6 value bits, following bytes hold 7 value bits each
+func (d *decoder) readVarInt() int64 {
+	b := d.readUint8()
+	num := int64(b & 0x3f)
+	negative := b&0x40 != 0
+	shift := uint(6)
+	for b&0x80 != 0 && d.err == nil {
+		b = d.readUint8()
+		num |= int64(b&0x7f) << shift
+		shift += 7
+		if shift > 63 {
+			d.fail(errors.New("yjs: varint overflow"))
+		}
+	}
+	if negative {
+		return -num
+	}
+	return num
+}
+
+func (d *decoder) readVarUint8Array() []byte {
+	return d.readBytes(d.readVarUint())
+}
+
+func (d *decoder) readVarString() string {
+	return string(d.readVarUint8Array())
+}
+
+func (d *decoder) readID() ID {
+	client := d.readVarUint()
+	clock := d.readVarUint()
+	return ID{Client: client, Clock: clock}
+}
+
+// readAny reads a lib0 "any" value (the encoding used for map values and array content)
+func (d *decoder) readAny() interface{} {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxAnyDepth {
+		d.fail(errors.New("yjs: any value nested too deeply"))
+		return nil
+	}
+
+	switch t := d.readUint8(); t {
+	case 127: // undefined
+		return nil
+	case 126: // null
+		return nil
+	case 125: // integer
+		return d.readVarInt()
+	case 124: // float32
+		b := d.readBytes(4)
+		if b == nil {
+			return nil
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 123: // float64
+		b := d.readBytes(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	case 122: // bigint
+		b := d.readBytes(8)
+		if b == nil {
+			return nil
+		}
+		return int64(binary.BigEndian.Uint64(b))
+	case 121:
+		return false
+	case 120:
+		return true
+	case 119:
+		return d.readVarString()
+	case 118: // object
+		n := d.readCount()
+		obj := make(map[string]interface{})
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key := d.readVarString()
+			obj[key] = d.readAny()
+		}
+		return obj
+	case 117: // array
+		n := d.readCount()
+		arr := make([]interface{}, 0)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			arr = append(arr, d.readAny())
+		}
+		return arr
+	case 116: // Uint8Array
+		return d.readVarUint8Array()
+	default:
+		if d.err == nil {
+			d.fail(errors.New("yjs: unknown any type"))
+		}
+		return nil
+	}
+}
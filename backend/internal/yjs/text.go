@@ -0,0 +1,35 @@
+package yjs
+
+import "strings"
+
+// TextContent returns the text of the node and all of its descendants, without
+// separators between elements
+func (n *XmlNode) TextContent() string {
+	var sb strings.Builder
+	n.Walk(func(node *XmlNode) {
+		sb.WriteString(node.Text)
+	})
+	return sb.String()
+}
+
+// TextBlocks returns the text of each element that directly holds text
+// (paragraphs, headings, code blocks, ...) in document order. Containers such
+// as lists and blockquotes are descended into rather than returned whole.
+func (n *XmlNode) TextBlocks() []string {
+	var blocks []string
+	n.collectTextBlocks(&blocks)
+	return blocks
+}
+
+func (n *XmlNode) collectTextBlocks(blocks *[]string) {
+	for _, child := range n.Children {
+		if child.Name == "" && child.Children == nil {
+			// A text node directly below this element: the element is a text block
+			*blocks = append(*blocks, n.TextContent())
+			return
+		}
+	}
+	for _, child := range n.Children {
+		child.collectTextBlocks(blocks)
+	}
+}
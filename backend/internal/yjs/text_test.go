@@ -0,0 +1,31 @@
+package yjs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTextBlocks(t *testing.T) {
+	text := func(s string) *XmlNode { return &XmlNode{Text: s} }
+	fragment := &XmlNode{Children: []*XmlNode{
+		{Name: "heading", Children: []*XmlNode{text("Title")}},
+		{Name: "bulletList", Children: []*XmlNode{
+			{Name: "listItem", Children: []*XmlNode{
+				{Name: "paragraph", Children: []*XmlNode{text("first "), {Name: "hardBreak", Children: []*XmlNode{}}, text("line")}},
+			}},
+			{Name: "listItem", Children: []*XmlNode{
+				{Name: "paragraph", Children: []*XmlNode{text("second")}},
+			}},
+		}},
+		{Name: "horizontalRule", Children: []*XmlNode{}},
+		{Name: "paragraph", Children: []*XmlNode{text("")}},
+	}}
+
+	want := []string{"Title", "first line", "second", ""}
+	if got := fragment.TextBlocks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TextBlocks() = %q, want %q", got, want)
+	}
+	if got := fragment.TextContent(); got != "Titlefirst linesecond" {
+		t.Errorf("TextContent() = %q", got)
+	}
+}
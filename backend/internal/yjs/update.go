@@ -0,0 +1,609 @@
+package yjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// ID identifies a struct in a Yjs document by client and logical clock
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// Shared type refs as encoded in ContentType
+const (
+	typeArray       = 0
+	typeMap         = 1
+	typeText        = 2
+	typeXmlElement  = 3
+	typeXmlFragment = 4
+	typeXmlHook     = 5
+	typeXmlText     = 6
+)
+
+// Struct content refs (low 5 bits of the info byte)
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// content is the payload of an item
+type content interface {
+	// length is the number of clock ticks the content occupies
+	length() uint64
+	// splice cuts the content at offset, keeping the left part and returning the right part
+	splice(offset uint64) content
+}
+
+type contentDeleted struct{ n uint64 }
+
+func (c *contentDeleted) length() uint64 { return c.n }
+func (c *contentDeleted) splice(offset uint64) content {
+	right := &contentDeleted{n: c.n - offset}
+	c.n = offset
+	return right
+}
+
+// contentValues holds ContentAny / ContentJSON values
+type contentValues struct{ values []interface{} }
+
+func (c *contentValues) length() uint64 { return uint64(len(c.values)) }
+func (c *contentValues) splice(offset uint64) content {
+	right := &contentValues{values: c.values[offset:]}
+	c.values = c.values[:offset]
+	return right
+}
+
+// contentString stores text as UTF-16 code units, since Yjs clocks count those
+type contentString struct{ units []uint16 }
+
+func (c *contentString) length() uint64 { return uint64(len(c.units)) }
+func (c *contentString) splice(offset uint64) content {
+	right := &contentString{units: c.units[offset:]}
+	c.units = c.units[:offset]
+	return right
+}
+func (c *contentString) String() string { return string(utf16.Decode(c.units)) }
+
+// contentSingle covers contents that always have length 1 (binary, embed, format, doc)
+type contentSingle struct {
+	ref   int
+	key   string
+	value interface{}
+}
+
+func (c *contentSingle) length() uint64        { return 1 }
+func (c *contentSingle) splice(uint64) content { return nil }
+
+type contentType struct{ t *sharedType }
+
+func (c *contentType) length() uint64        { return 1 }
+func (c *contentType) splice(uint64) content { return nil }
+
+// sharedType is a Y.Array / Y.Map / Y.Text / Y.Xml* instance (or a root type)
+type sharedType struct {
+	ref      int
+	name     string           // node name for XmlElement, hook name for XmlHook
+	item     *item            // nil for root types
+	start    *item            // first item of the list part
+	mapItems map[string]*item // current (rightmost) item per map key
+}
+
+func newSharedType(ref int, name string) *sharedType {
+	return &sharedType{ref: ref, name: name, mapItems: make(map[string]*item)}
+}
+
+// item is a struct in the document store. GC structs are items with gc set and no content.
+type item struct {
+	id          ID
+	len         uint64
+	origin      *ID
+	rightOrigin *ID
+	left, right *item
+	parent      *sharedType
+	parentSub   *string
+	content     content
+	deleted     bool
+	gc          bool
+
+	// Parent info as read from the update, resolved on integration
+	parentID  *ID
+	parentKey *string
+}
+
+func (it *item) lastID() ID {
+	return ID{Client: it.id.Client, Clock: it.id.Clock + it.len - 1}
+}
+
+// Doc is a decoded Yjs document
+type Doc struct {
+	clients map[uint64][]*item // structs per client, sorted by clock
+	roots   map[string]*sharedType
+}
+
+// DecodeUpdate decodes a v1 update (as produced by Y.encodeStateAsUpdate) into a document
+func DecodeUpdate(update []byte) (*Doc, error) {
+	d := newDecoder(update)
+	refs := readStructs(d)
+	ds := readDeleteSet(d)
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	doc := &Doc{
+		clients: make(map[uint64][]*item),
+		roots:   make(map[string]*sharedType),
+	}
+	if err := doc.integrateAll(refs); err != nil {
+		return nil, err
+	}
+	doc.applyDeleteSet(ds)
+	return doc, nil
+}
+
+// readStructs reads the struct section of an update, grouped by client
+func readStructs(d *decoder) map[uint64][]*item {
+	refs := make(map[uint64][]*item)
+	numClients := d.readCount()
+	for i := uint64(0); i < numClients && d.err == nil; i++ {
+		numStructs := d.readCount()
+		client := d.readVarUint()
+		clock := d.readVarUint()
+		for j := uint64(0); j < numStructs && d.err == nil; j++ {
+			info := d.readUint8()
+			switch ref := int(info & 0x1f); ref {
+			case refGC:
+				n := d.readVarUint()
+				refs[client] = append(refs[client], &item{id: ID{client, clock}, len: n, gc: true})
+				clock += n
+			case refSkip:
+				// Skips mark missing ranges; a full state update has none
+				clock += d.readVarUint()
+			default:
+				it := &item{id: ID{Client: client, Clock: clock}}
+				if info&0x80 != 0 {
+					id := d.readID()
+					it.origin = &id
+				}
+				if info&0x40 != 0 {
+					id := d.readID()
+					it.rightOrigin = &id
+				}
+				if info&0xc0 == 0 {
+					// No origins, so the parent is written explicitly
+					if d.readVarUint() == 1 {
+						key := d.readVarString()
+						it.parentKey = &key
+					} else {
+						id := d.readID()
+						it.parentID = &id
+					}
+					if info&0x20 != 0 {
+						sub := d.readVarString()
+						it.parentSub = &sub
+					}
+				}
+				it.content = readContent(d, ref)
+				if it.content == nil {
+					d.fail(fmt.Errorf("yjs: unknown content ref %d", ref))
+					return refs
+				}
+				it.len = it.content.length()
+				refs[client] = append(refs[client], it)
+				clock += it.len
+			}
+		}
+	}
+	return refs
+}
+
+func readContent(d *decoder, ref int) content {
+	switch ref {
+	case refDeleted:
+		return &contentDeleted{n: d.readVarUint()}
+	case refJSON:
+		n := d.readCount()
+		var values []interface{}
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			s := d.readVarString()
+			var v interface{}
+			if s != "undefined" {
+				json.Unmarshal([]byte(s), &v)
+			}
+			values = append(values, v)
+		}
+		return &contentValues{values: values}
+	case refBinary:
+		return &contentSingle{ref: ref, value: d.readVarUint8Array()}
+	case refString:
+		return &contentString{units: utf16.Encode([]rune(d.readVarString()))}
+	case refEmbed:
+		var v interface{}
+		json.Unmarshal([]byte(d.readVarString()), &v)
+		return &contentSingle{ref: ref, value: v}
+	case refFormat:
+		key := d.readVarString()
+		var v interface{}
+		json.Unmarshal([]byte(d.readVarString()), &v)
+		return &contentSingle{ref: ref, key: key, value: v}
+	case refType:
+		typeRef := int(d.readVarUint())
+		name := ""
+		if typeRef == typeXmlElement || typeRef == typeXmlHook {
+			name = d.readVarString()
+		}
+		return &contentType{t: newSharedType(typeRef, name)}
+	case refAny:
+		n := d.readCount()
+		var values []interface{}
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			values = append(values, d.readAny())
+		}
+		return &contentValues{values: values}
+	case refDoc:
+		guid := d.readVarString()
+		opts := d.readAny()
+		return &contentSingle{ref: ref, key: guid, value: opts}
+	}
+	return nil
+}
+
+// deleteRange is a deleted clock range of one client
+type deleteRange struct {
+	client uint64
+	clock  uint64
+	len    uint64
+}
+
+func readDeleteSet(d *decoder) []deleteRange {
+	var ranges []deleteRange
+	numClients := d.readCount()
+	for i := uint64(0); i < numClients && d.err == nil; i++ {
+		client := d.readVarUint()
+		numDeletes := d.readCount()
+		for j := uint64(0); j < numDeletes && d.err == nil; j++ {
+			clock := d.readVarUint()
+			n := d.readVarUint()
+			ranges = append(ranges, deleteRange{client: client, clock: clock, len: n})
+		}
+	}
+	return ranges
+}
+
+// state returns the next expected clock of a client
+func (doc *Doc) state(client uint64) uint64 {
+	structs := doc.clients[client]
+	if len(structs) == 0 {
+		return 0
+	}
+	last := structs[len(structs)-1]
+	return last.id.Clock + last.len
+}
+
+// integrateAll integrates structs in causal order. Any causal order converges
+// to the same document, so clients are simply drained while their next struct
+// has all of its dependencies available.
+func (doc *Doc) integrateAll(refs map[uint64][]*item) error {
+	clients := make([]uint64, 0, len(refs))
+	for client := range refs {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	for progress := true; progress; {
+		progress = false
+		for _, client := range clients {
+			queue := refs[client]
+			for len(queue) > 0 && doc.ready(queue[0]) {
+				doc.integrate(queue[0])
+				queue = queue[1:]
+				progress = true
+			}
+			refs[client] = queue
+		}
+	}
+
+	for _, queue := range refs {
+		if len(queue) > 0 {
+			return errors.New("yjs: update has missing dependencies")
+		}
+	}
+	return nil
+}
+
+// ready reports whether every struct the item depends on is already integrated
+func (doc *Doc) ready(it *item) bool {
+	if it.id.Clock != doc.state(it.id.Client) {
+		return false
+	}
+	for _, dep := range []*ID{it.origin, it.rightOrigin, it.parentID} {
+		if dep != nil && dep.Client != it.id.Client && dep.Clock >= doc.state(dep.Client) {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the index of the struct containing id
+func (doc *Doc) find(id ID) (int, bool) {
+	structs := doc.clients[id.Client]
+	i := sort.Search(len(structs), func(i int) bool {
+		return structs[i].id.Clock+structs[i].len > id.Clock
+	})
+	if i == len(structs) || structs[i].id.Clock > id.Clock {
+		return 0, false
+	}
+	return i, true
+}
+
+func (doc *Doc) getItem(id ID) *item {
+	i, ok := doc.find(id)
+	if !ok {
+		return nil
+	}
+	return doc.clients[id.Client][i]
+}
+
+// splitItem cuts it at offset and returns the right part, which is inserted into the store
+func (doc *Doc) splitItem(it *item, offset uint64) *item {
+	right := &item{
+		id:      ID{Client: it.id.Client, Clock: it.id.Clock + offset},
+		len:     it.len - offset,
+		deleted: it.deleted,
+		gc:      it.gc,
+	}
+	if !it.gc {
+		origin := ID{Client: it.id.Client, Clock: it.id.Clock + offset - 1}
+		right.origin = &origin
+		right.rightOrigin = it.rightOrigin
+		right.parent = it.parent
+		right.parentSub = it.parentSub
+		right.content = it.content.splice(offset)
+		right.left = it
+		right.right = it.right
+		if it.right != nil {
+			it.right.left = right
+		}
+		it.right = right
+		if it.parent != nil && it.parentSub != nil && it.parent.mapItems[*it.parentSub] == it {
+			it.parent.mapItems[*it.parentSub] = right
+		}
+	}
+	it.len = offset
+
+	structs := doc.clients[it.id.Client]
+	i, _ := doc.find(it.id)
+	structs = append(structs, nil)
+	copy(structs[i+2:], structs[i+1:])
+	structs[i+1] = right
+	doc.clients[it.id.Client] = structs
+	return right
+}
+
+// getItemCleanStart returns the struct starting exactly at id, splitting if needed
+func (doc *Doc) getItemCleanStart(id ID) *item {
+	it := doc.getItem(id)
+	if it == nil {
+		return nil
+	}
+	if it.id.Clock < id.Clock {
+		return doc.splitItem(it, id.Clock-it.id.Clock)
+	}
+	return it
+}
+
+// getItemCleanEnd returns the struct ending exactly at id, splitting if needed
+func (doc *Doc) getItemCleanEnd(id ID) *item {
+	it := doc.getItem(id)
+	if it == nil {
+		return nil
+	}
+	if id.Clock != it.id.Clock+it.len-1 {
+		doc.splitItem(it, id.Clock-it.id.Clock+1)
+	}
+	return it
+}
+
+func (doc *Doc) root(name string) *sharedType {
+	t, ok := doc.roots[name]
+	if !ok {
+		t = newSharedType(-1, "")
+		doc.roots[name] = t
+	}
+	return t
+}
+
+func (doc *Doc) appendStruct(it *item) {
+	doc.clients[it.id.Client] = append(doc.clients[it.id.Client], it)
+}
+
+// integrate inserts an item into its parent using the YATA conflict resolution
+// rules of Item.integrate in Yjs, so the resulting order matches every Yjs peer
+func (doc *Doc) integrate(it *item) {
+	if it.gc {
+		doc.appendStruct(it)
+		return
+	}
+
+	// Resolve origins and parent (Item.getMissing)
+	if it.origin != nil {
+		it.left = doc.getItemCleanEnd(*it.origin)
+		if it.left != nil {
+			last := it.left.lastID()
+			it.origin = &last
+		}
+	}
+	if it.rightOrigin != nil {
+		it.right = doc.getItemCleanStart(*it.rightOrigin)
+		if it.right != nil {
+			it.rightOrigin = &it.right.id
+		}
+	}
+	switch {
+	case (it.left != nil && it.left.gc) || (it.right != nil && it.right.gc):
+		it.parent = nil
+	case it.parentID != nil:
+		if p := doc.getItem(*it.parentID); p != nil && !p.gc {
+			if ct, ok := p.content.(*contentType); ok {
+				it.parent = ct.t
+			}
+		}
+	case it.parentKey != nil:
+		it.parent = doc.root(*it.parentKey)
+	default:
+		if it.left != nil {
+			it.parent = it.left.parent
+			it.parentSub = it.left.parentSub
+		}
+		if it.right != nil {
+			it.parent = it.right.parent
+			it.parentSub = it.right.parentSub
+		}
+	}
+
+	if it.parent == nil {
+		// The parent was garbage collected: keep the clock range as a GC struct
+		doc.appendStruct(&item{id: it.id, len: it.len, gc: true})
+		return
+	}
+
+	parent := it.parent
+	if (it.left == nil && (it.right == nil || it.right.left != nil)) || (it.left != nil && it.left.right != it.right) {
+		left := it.left
+		var o *item
+		if left != nil {
+			o = left.right
+		} else if it.parentSub != nil {
+			o = parent.mapItems[*it.parentSub]
+			for o != nil && o.left != nil {
+				o = o.left
+			}
+		} else {
+			o = parent.start
+		}
+
+		conflicting := make(map[*item]bool)
+		beforeOrigin := make(map[*item]bool)
+		for o != nil && o != it.right {
+			beforeOrigin[o] = true
+			conflicting[o] = true
+			if sameID(it.origin, o.origin) {
+				if o.id.Client < it.id.Client {
+					left = o
+					conflicting = make(map[*item]bool)
+				} else if sameID(it.rightOrigin, o.rightOrigin) {
+					break
+				}
+			} else if o.origin != nil && beforeOrigin[doc.getItem(*o.origin)] {
+				if !conflicting[doc.getItem(*o.origin)] {
+					left = o
+					conflicting = make(map[*item]bool)
+				}
+			} else {
+				break
+			}
+			o = o.right
+		}
+		it.left = left
+	}
+
+	// Link into the list
+	if it.left != nil {
+		it.right = it.left.right
+		it.left.right = it
+	} else {
+		var r *item
+		if it.parentSub != nil {
+			r = parent.mapItems[*it.parentSub]
+			for r != nil && r.left != nil {
+				r = r.left
+			}
+		} else {
+			r = parent.start
+			parent.start = it
+		}
+		it.right = r
+	}
+	if it.right != nil {
+		it.right.left = it
+	} else if it.parentSub != nil {
+		// The rightmost entry is the current map value; older values are deleted
+		parent.mapItems[*it.parentSub] = it
+		if it.left != nil {
+			doc.markDeleted(it.left)
+		}
+	}
+
+	doc.appendStruct(it)
+	switch c := it.content.(type) {
+	case *contentType:
+		c.t.item = it
+	case *contentDeleted:
+		it.deleted = true
+	}
+
+	if (parent.item != nil && parent.item.deleted) || (it.parentSub != nil && it.right != nil) {
+		doc.markDeleted(it)
+	}
+}
+
+func sameID(a, b *ID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// markDeleted deletes an item; deleting a type deletes all of its children
+func (doc *Doc) markDeleted(it *item) {
+	if it.deleted || it.gc {
+		return
+	}
+	it.deleted = true
+	if ct, ok := it.content.(*contentType); ok {
+		for child := ct.t.start; child != nil; child = child.right {
+			doc.markDeleted(child)
+		}
+		for _, child := range ct.t.mapItems {
+			doc.markDeleted(child)
+		}
+	}
+}
+
+func (doc *Doc) applyDeleteSet(ranges []deleteRange) {
+	for _, r := range ranges {
+		end := r.clock + r.len
+		if end > doc.state(r.client) {
+			end = doc.state(r.client)
+		}
+		if r.clock >= end {
+			continue
+		}
+		it := doc.getItemCleanStart(ID{Client: r.client, Clock: r.clock})
+		for it != nil && it.id.Clock < end {
+			if it.id.Clock+it.len > end {
+				doc.splitItem(it, end-it.id.Clock)
+			}
+			doc.markDeleted(it)
+			i, ok := doc.find(it.id)
+			structs := doc.clients[r.client]
+			if !ok || i+1 >= len(structs) {
+				break
+			}
+			it = structs[i+1]
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package yjs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/collab-docs/backend/internal/yjs/yjstest"
+)
+
+func TestDecodeUpdateDocument(t *testing.T) {
+	update := yjstest.Document(
+		yjstest.Block{Name: "heading", ID: "b1", Text: "Title"},
+		yjstest.Block{Name: "paragraph", ID: "b2", Text: "Hello wörld 👋"},
+		yjstest.Block{Name: "paragraph"},
+	)
+
+	doc, err := DecodeUpdate(update)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	fragment := doc.XmlFragment("default")
+	if fragment == nil {
+		t.Fatal("default fragment missing")
+	}
+
+	var names, ids []string
+	for _, n := range fragment.Children {
+		names = append(names, n.Name)
+		id, _ := n.Attrs["id"].(string)
+		ids = append(ids, id)
+	}
+	if want := []string{"heading", "paragraph", "paragraph"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("element names = %q, want %q", names, want)
+	}
+	if want := []string{"b1", "b2", ""}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("element ids = %q, want %q", ids, want)
+	}
+	if got, want := fragment.TextBlocks(), []string{"Title", "Hello wörld 👋", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TextBlocks() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeUpdateAppliesDeleteSet(t *testing.T) {
+	update := yjstest.DocumentWithDeletes(
+		[]yjstest.Block{{Name: "paragraph", Text: "Hello brave world"}},
+		[]yjstest.Deletion{{Block: 0, Offset: 5, Len: 6}},
+	)
+
+	doc, err := DecodeUpdate(update)
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	if got, want := doc.XmlFragment("default").TextBlocks(), []string{"Hello world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TextBlocks() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeUpdateEmpty(t *testing.T) {
+	doc, err := DecodeUpdate([]byte{0, 0})
+	if err != nil {
+		t.Fatalf("DecodeUpdate: %v", err)
+	}
+	if doc.XmlFragment("default") != nil {
+		t.Error("empty update should have no default fragment")
+	}
+}
+
+func TestDecodeUpdateMalformed(t *testing.T) {
+	// One client with one struct at clock 0, whose parent is the root type "x"
+	head := func(info byte) *yjstest.Writer {
+		return new(yjstest.Writer).VarUint(1).VarUint(1).VarUint(1).VarUint(0).Byte(info).VarUint(1).String("x")
+	}
+
+	nested := new(yjstest.Writer).VarUint(1).VarUint(1).VarUint(1).VarUint(0).Byte(8).VarUint(1).String("x").VarUint(1)
+	for i := 0; i < 10000; i++ {
+		nested.Byte(117).VarUint(1) // array holding one element
+	}
+
+	tests := []struct {
+		name   string
+		update []byte
+	}{
+		{"empty input", nil},
+		{"any count beyond input", head(8).VarUint(1 << 62).Buf},
+		{"any count of 2^28", head(8).VarUint(1<<28).Byte(125, 1).Buf},
+		{"json count beyond input", head(2).VarUint(1 << 40).Buf},
+		{"string length overflowing position", head(4).VarUint(1<<63 - 3).Buf},
+		{"binary length beyond input", head(3).VarUint(1<<20).Byte(1, 2, 3).Buf},
+		{"struct count beyond input", new(yjstest.Writer).VarUint(1).VarUint(1 << 50).VarUint(1).VarUint(0).Buf},
+		{"client count beyond input", new(yjstest.Writer).VarUint(1 << 33).Buf},
+		{"delete set count beyond input", new(yjstest.Writer).VarUint(0).VarUint(1).VarUint(1).VarUint(1 << 30).Buf},
+		{"object count beyond input", head(8).VarUint(1).Byte(118).VarUint(1 << 30).Buf},
+		{"deeply nested any", nested.Buf},
+		{"varuint overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"unknown content ref", head(15).Buf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeUpdate(tt.update); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDecodeUpdateTruncated(t *testing.T) {
+	update := yjstest.DocumentWithDeletes(
+		[]yjstest.Block{{Name: "heading", ID: "a", Text: "One"}, {Name: "paragraph", ID: "b", Text: "Two"}},
+		[]yjstest.Deletion{{Block: 1, Offset: 0, Len: 1}},
+	)
+	for n := 0; n < len(update); n++ {
+		// Every prefix is invalid; it must fail cleanly
+		if _, err := DecodeUpdate(update[:n]); err == nil {
+			t.Errorf("prefix of %d bytes decoded without error", n)
+		}
+	}
+}
+
+func FuzzDecodeUpdate(f *testing.F) {
+	f.Add([]byte{0, 0})
+	f.Add(yjstest.Document(yjstest.Block{Name: "paragraph", ID: "a", Text: "Hello"}))
+	f.Add(yjstest.DocumentWithDeletes(
+		[]yjstest.Block{{Name: "heading", Text: "Title"}, {Name: "paragraph", ID: "p", Text: "Body text"}},
+		[]yjstest.Deletion{{Block: 1, Offset: 2, Len: 3}},
+	))
+
+	f.Fuzz(func(t *testing.T, update []byte) {
+		doc, err := DecodeUpdate(update)
+		if err != nil {
+			return
+		}
+		if fragment := doc.XmlFragment("default"); fragment != nil {
+			fragment.TextBlocks()
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package yjs
+
+import "strings"
+
+// XmlNode is a node of a decoded Y.XmlFragment (the structure y-prosemirror /
+// TipTap store documents in)
+type XmlNode struct {
+	Name     string                 // Element name (e.g. "paragraph"); empty for text nodes and the fragment
+	Attrs    map[string]interface{} // Element attributes
+	Text     string                 // Text content (text nodes only)
+	Children []*XmlNode
+}
+
+// XmlFragment returns the root fragment with the given name (TipTap uses "default"),
+// or nil if the document has no such root type
+func (doc *Doc) XmlFragment(name string) *XmlNode {
+	t, ok := doc.roots[name]
+	if !ok {
+		return nil
+	}
+	return &XmlNode{Children: xmlChildren(t)}
+}
+
+func xmlChildren(t *sharedType) []*XmlNode {
+	children := []*XmlNode{}
+	for it := t.start; it != nil; it = it.right {
+		if it.deleted {
+			continue
+		}
+		ct, ok := it.content.(*contentType)
+		if !ok {
+			continue
+		}
+		switch ct.t.ref {
+		case typeXmlElement:
+			children = append(children, &XmlNode{
+				Name:     ct.t.name,
+				Attrs:    mapValues(ct.t),
+				Children: xmlChildren(ct.t),
+			})
+		case typeXmlText, typeText:
+			children = append(children, &XmlNode{Text: textContent(ct.t)})
+		case typeXmlFragment:
+			children = append(children, &XmlNode{Children: xmlChildren(ct.t)})
+		}
+	}
+	return children
+}
+
+// mapValues returns the current values of a type's map part (attributes for XML elements)
+func mapValues(t *sharedType) map[string]interface{} {
+	values := make(map[string]interface{})
+	for key, it := range t.mapItems {
+		if it.deleted {
+			continue
+		}
+		switch c := it.content.(type) {
+		case *contentValues:
+			if len(c.values) > 0 {
+				values[key] = c.values[len(c.values)-1]
+			}
+		case *contentString:
+			values[key] = c.String()
+		case *contentSingle:
+			values[key] = c.value
+		}
+	}
+	return values
+}
+
+// textContent concatenates the visible text of a Y.Text, ignoring formatting and embeds
+func textContent(t *sharedType) string {
+	var sb strings.Builder
+	for it := t.start; it != nil; it = it.right {
+		if it.deleted {
+			continue
+		}
+		if c, ok := it.content.(*contentString); ok {
+			sb.WriteString(c.String())
+		}
+	}
+	return sb.String()
+}
+
+// Walk calls fn for the node and all of its descendants, depth first
+func (n *XmlNode) Walk(fn func(*XmlNode)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
@@ -0,0 +1,125 @@
+// Package yjstest builds Yjs updates for tests, shaped like the documents
+// TipTap's collaboration extension stores: block elements in the "default"
+// XML fragment, each holding one Y.XmlText.
+package yjstest
+
+import "unicode/utf16"
+
+// Block is a top-level element of a test document
+type Block struct {
+	Name string // Element name, e.g. "paragraph"
+	ID   string // Value of the "id" attribute; none when empty
+	Text string
+}
+
+// Struct content refs and shared type refs of the v1 encoding
+const (
+	refString   = 4
+	refType     = 7
+	refAny      = 8
+	typeElement = 3
+	typeXmlText = 6
+	anyString   = 119
+)
+
+const client = 1
+
+// Writer encodes lib0 values
+type Writer struct {
+	Buf []byte
+}
+
+// VarUint appends an unsigned integer, 7 bits per byte
+func (w *Writer) VarUint(n uint64) *Writer {
+	for n >= 0x80 {
+		w.Buf = append(w.Buf, byte(n)|0x80)
+		n >>= 7
+	}
+	w.Buf = append(w.Buf, byte(n))
+	return w
+}
+
+// String appends a length-prefixed UTF-8 string
+func (w *Writer) String(s string) *Writer {
+	w.VarUint(uint64(len(s)))
+	w.Buf = append(w.Buf, s...)
+	return w
+}
+
+// Byte appends raw bytes
+func (w *Writer) Byte(b ...byte) *Writer {
+	w.Buf = append(w.Buf, b...)
+	return w
+}
+
+// Document encodes the blocks as a full-state update from a single client
+func Document(blocks ...Block) []byte {
+	return DocumentWithDeletes(blocks, nil)
+}
+
+// Deletion removes n UTF-16 code units of a block's text, starting at Offset
+type Deletion struct {
+	Block  int
+	Offset uint64
+	Len    uint64
+}
+
+// DocumentWithDeletes is Document followed by a delete set removing text ranges
+func DocumentWithDeletes(blocks []Block, deletions []Deletion) []byte {
+	var structs Writer
+	numStructs := uint64(0)
+	clock := uint64(0)
+	textClock := make([]uint64, len(blocks))
+	var prevElement *uint64
+
+	for i, b := range blocks {
+		element := clock
+		if prevElement == nil {
+			// First element: no origin, parent is the root fragment
+			structs.Byte(refType).VarUint(1).String("default")
+		} else {
+			structs.Byte(0x80 | refType).VarUint(client).VarUint(*prevElement)
+		}
+		structs.VarUint(typeElement).String(b.Name)
+		numStructs++
+		clock++
+		prevElement = &element
+
+		if b.ID != "" {
+			structs.Byte(0x20 | refAny).VarUint(0).VarUint(client).VarUint(element).String("id")
+			structs.VarUint(1).Byte(anyString).String(b.ID)
+			numStructs++
+			clock++
+		}
+
+		text := clock
+		structs.Byte(refType).VarUint(0).VarUint(client).VarUint(element).VarUint(typeXmlText)
+		numStructs++
+		clock++
+
+		if b.Text != "" {
+			structs.Byte(refString).VarUint(0).VarUint(client).VarUint(text).String(b.Text)
+			numStructs++
+			textClock[i] = clock
+			clock += uint64(len(utf16.Encode([]rune(b.Text))))
+		}
+	}
+
+	var w Writer
+	if numStructs == 0 {
+		w.VarUint(0)
+	} else {
+		w.VarUint(1).VarUint(numStructs).VarUint(client).VarUint(0)
+		w.Buf = append(w.Buf, structs.Buf...)
+	}
+
+	if len(deletions) == 0 {
+		w.VarUint(0)
+	} else {
+		w.VarUint(1).VarUint(client).VarUint(uint64(len(deletions)))
+		for _, d := range deletions {
+			w.VarUint(textClock[d.Block] + d.Offset).VarUint(d.Len)
+		}
+	}
+	return w.Buf
+}
@@ -18,6 +18,9 @@ CREATE TABLE IF NOT EXISTS users (
     password_hash TEXT,
     name TEXT,
     avatar_url TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    last_login_at TIMESTAMPTZ,
+    last_active_at TIMESTAMPTZ,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
@@ -28,6 +31,7 @@ CREATE TABLE IF NOT EXISTS folders (
     name TEXT NOT NULL DEFAULT 'New Folder',
     owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     parent_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    path TEXT, -- Materialized /parent/child path, maintained by the API (see RebuildFolderPaths)
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
@@ -38,6 +42,7 @@ CREATE TABLE IF NOT EXISTS documents (
     title TEXT NOT NULL DEFAULT 'Untitled Document',
     owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     folder_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    deleted_at TIMESTAMPTZ, -- set while the document is in the trash
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
@@ -48,6 +53,7 @@ CREATE TABLE IF NOT EXISTS document_permissions (
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     role TEXT NOT NULL CHECK (role IN ('owner', 'edit', 'comment', 'view')),
     created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
     PRIMARY KEY (doc_id, user_id)
 );
 
@@ -56,6 +62,7 @@ CREATE TABLE IF NOT EXISTS doc_snapshots (
     doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
     version INTEGER NOT NULL,
     snapshot BYTEA NOT NULL,
+    checksum BYTEA, -- SHA-256 of snapshot, verified on load; NULL for rows saved before checksums
     created_at TIMESTAMPTZ DEFAULT NOW(),
     PRIMARY KEY (doc_id, version)
 );
@@ -68,40 +75,109 @@ CREATE TABLE IF NOT EXISTS comments (
     content TEXT NOT NULL,
     selection JSONB, -- { "anchor": number, "head": number }
     resolved BOOLEAN DEFAULT FALSE,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL, -- set with resolved_at while resolved
+    resolved_at TIMESTAMPTZ,
     parent_id UUID REFERENCES comments(id) ON DELETE CASCADE,
+    is_resolution BOOLEAN DEFAULT FALSE, -- closing note left when the thread was resolved
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
 
+-- Users mentioned in a comment
+CREATE TABLE IF NOT EXISTS comment_mentions (
+    comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id)
+);
+
 -- Access requests table for permission requests
 CREATE TABLE IF NOT EXISTS access_requests (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
     requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'cancelled', 'expired')),
     requested_role TEXT NOT NULL DEFAULT 'view' CHECK (requested_role IN ('view', 'comment', 'edit')),
     message TEXT,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '7 days',
     UNIQUE(doc_id, requester_id)
 );
 
+-- API keys for programmatic access (only the key hash is stored)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Share links grant a role on a document to whoever redeems the token
+CREATE TABLE IF NOT EXISTS document_share_links (
+    token TEXT PRIMARY KEY,
+    doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('edit', 'comment', 'view')),
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ, -- NULL = never expires
+    single_use BOOLEAN DEFAULT FALSE,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Freeform per-document tags, stored lowercased and trimmed
+CREATE TABLE IF NOT EXISTS tags (
+    doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (doc_id, tag)
+);
+
+-- Documents a user has starred for quick access
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    doc_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, doc_id)
+);
+
+-- Per-user notifications inbox
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    read BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
 -- =============================================================================
 -- Indexes for Performance
 -- =============================================================================
 
 CREATE INDEX IF NOT EXISTS idx_documents_owner ON documents(owner_id);
 CREATE INDEX IF NOT EXISTS idx_documents_folder ON documents(folder_id);
+CREATE INDEX IF NOT EXISTS idx_documents_title_search ON documents USING GIN (to_tsvector('simple', title));
 CREATE INDEX IF NOT EXISTS idx_doc_permissions_user ON document_permissions(user_id);
 CREATE INDEX IF NOT EXISTS idx_doc_permissions_doc ON document_permissions(doc_id);
 CREATE INDEX IF NOT EXISTS idx_snapshots_doc ON doc_snapshots(doc_id);
 CREATE INDEX IF NOT EXISTS idx_comments_doc ON comments(doc_id);
 CREATE INDEX IF NOT EXISTS idx_comments_user ON comments(user_id);
+CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id);
+CREATE INDEX IF NOT EXISTS idx_comment_mentions_user ON comment_mentions(user_id);
 CREATE INDEX IF NOT EXISTS idx_folders_owner ON folders(owner_id);
 CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders(parent_id);
 CREATE INDEX IF NOT EXISTS idx_access_requests_doc ON access_requests(doc_id);
 CREATE INDEX IF NOT EXISTS idx_access_requests_requester ON access_requests(requester_id);
 CREATE INDEX IF NOT EXISTS idx_access_requests_status ON access_requests(status);
+CREATE INDEX IF NOT EXISTS idx_access_requests_expiry ON access_requests(expires_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_doc ON document_share_links(doc_id);
+CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag);
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at DESC);
 
 -- =============================================================================
 -- Triggers for updated_at
@@ -140,6 +216,11 @@ CREATE TRIGGER update_access_requests_updated_at
     FOR EACH ROW
     EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_document_permissions_updated_at
+    BEFORE UPDATE ON document_permissions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
 -- =============================================================================
 -- Test Data (Local Development Only)
 -- =============================================================================
@@ -566,7 +566,7 @@ export default function HomePage() {
 
                             {/* Description */}
                             <p className="text-center text-slate-500 dark:text-slate-400 mb-6">
-                                This action cannot be undone, the document will be permanently deleted.
+                                The document will be moved to the trash and can be restored within 30 days.
                             </p>
 
                             {/* Document Info */}
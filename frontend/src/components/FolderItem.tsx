@@ -228,7 +228,7 @@ export function FolderItem({ folder, onClick, onUpdated }: FolderItemProps) {
                             <p className="text-center text-slate-500 dark:text-slate-400 mb-6">
                                 <span className="text-red-500 font-medium">This action cannot be undone!</span>
                                 <br />
-                                All documents and subfolders within the folder will be permanently deleted.
+                                Subfolders will be deleted. Documents inside are moved to the parent folder.
                             </p>
 
                             {/* Folder Info */}
@@ -1,4 +1,4 @@
-import type { User, Document, Comment, DocumentPermission, LoginResponse, AccessRequest, Folder, FolderContents, FolderTreeNode } from '@/types'
+import type { User, Document, DocumentPage, Comment, DocumentPermission, LoginResponse, AccessRequest, Folder, FolderContents, FolderTreeNode } from '@/types'
 
 const API_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080'
 
@@ -122,8 +122,8 @@ class ApiClient {
     }
 
     // Documents
-    async listDocuments(): Promise<Document[]> {
-        return this.fetch<Document[]>('/api/docs')
+    async listDocuments(limit = 50, offset = 0): Promise<DocumentPage> {
+        return this.fetch<DocumentPage>(`/api/docs?limit=${limit}&offset=${offset}`)
     }
 
     async getDocument(id: string): Promise<Document> {
@@ -178,7 +178,8 @@ class ApiClient {
 
     // Comments
     async listComments(docId: string): Promise<Comment[]> {
-        return this.fetch<Comment[]>(`/api/docs/${docId}/comments`)
+        // The panel shows resolved threads too (dimmed)
+        return this.fetch<Comment[]>(`/api/docs/${docId}/comments?resolved=all&limit=100`)
     }
 
     async createComment(docId: string, data: { content: string; selection?: { anchor: number; head: number } }): Promise<Comment> {
@@ -16,10 +16,17 @@ export interface Document {
     folder_id?: string
     owner?: User
     permission?: string
+    starred?: boolean
     created_at: string
     updated_at: string
 }
 
+export interface DocumentPage {
+    items: Document[]
+    total: number
+    hasMore: boolean
+}
+
 // Permission types
 export type PermissionRole = 'owner' | 'edit' | 'comment' | 'view'
 
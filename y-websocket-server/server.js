@@ -7,14 +7,29 @@
 const http = require('http')
 const WebSocket = require('ws')
 const Y = require('yjs')
-const { setupWSConnection, setPersistence } = require('y-websocket/bin/utils')
+const { setupWSConnection, setPersistence, docs } = require('y-websocket/bin/utils')
+const { createClient } = require('redis')
 
 const PORT = process.env.PORT || 1234
 const API_URL = process.env.API_URL || 'http://api-service:8080'
+// Shared with the backend; internal endpoints are disabled when empty
+const COLLAB_SECRET = process.env.COLLAB_SECRET || ''
+// Room control messages from the backend; disabled when empty
+const REDIS_URL = process.env.REDIS_URL || ''
+// Connections allowed per room; 0 (the default) means no limit
+const MAX_ROOM_CLIENTS = Number(process.env.MAX_ROOM_CLIENTS || 0)
+if (!Number.isInteger(MAX_ROOM_CLIENTS) || MAX_ROOM_CLIENTS < 0) {
+    console.error(`Invalid MAX_ROOM_CLIENTS: ${process.env.MAX_ROOM_CLIENTS}`)
+    process.exit(1)
+}
 
 console.log(`y-websocket server starting...`)
 console.log(`  Port: ${PORT}`)
 console.log(`  API URL: ${API_URL}`)
+console.log(`  Max room clients: ${MAX_ROOM_CLIENTS || 'unlimited'}`)
+
+// Rooms whose snapshot has finished loading; until then a room's content is incomplete
+const loadedDocs = new WeakSet()
 
 // Persistence layer - saves/loads documents to/from Go backend
 const persistence = {
@@ -43,6 +58,7 @@ const persistence = {
         } catch (error) {
             console.error(`Error loading document ${docName}:`, error.message)
         }
+        loadedDocs.add(ydoc)
     },
 
     writeState: async (docName, ydoc) => {
@@ -85,25 +101,335 @@ const persistence = {
 // Set persistence
 setPersistence(persistence)
 
+// Backend status of a document: 200, 404 when it does not exist, 410 when trashed
+const documentStatus = async (docName) => {
+    const response = await fetch(`${API_URL}/api/yjs/${docName}`, { method: 'HEAD' })
+    return response.status
+}
+
+// Whether the backend reports a document as trashed (HTTP 410). Checked before
+// a connection joins its room, so a trashed document is never loaded or saved.
+const isTrashed = async (docName) => {
+    try {
+        return (await documentStatus(docName)) === 410
+    } catch (error) {
+        console.error(`Failed to check document ${docName}:`, error.message)
+        return false
+    }
+}
+
+// Replace the content of an open room with the latest backend snapshot (after a
+// version restore). The replacement is applied as a regular update on top of the
+// current state, so connected clients converge on it instead of merging their
+// stale state back. Returns false if the room is not open.
+const reloadRoom = async (docName) => {
+    const ydoc = docs.get(docName)
+    if (!ydoc) {
+        return false
+    }
+
+    const response = await fetch(`${API_URL}/api/yjs/${docName}/snapshot`)
+    if (!response.ok) {
+        throw new Error(`snapshot request failed: ${response.status}`)
+    }
+    const data = await response.json()
+
+    const restored = new Y.Doc()
+    if (data.snapshot) {
+        Y.applyUpdate(restored, Buffer.from(data.snapshot, 'base64'))
+    }
+    // TipTap keeps the document in the "default" XML fragment
+    const source = restored.getXmlFragment('default')
+    const target = ydoc.getXmlFragment('default')
+    ydoc.transact(() => {
+        target.delete(0, target.length)
+        target.insert(0, source.toArray().map((node) => node.clone()))
+    })
+    restored.destroy()
+    console.log(`Reloaded room ${docName} from snapshot version ${data.version}`)
+    return true
+}
+
+// Whether a room already has MAX_ROOM_CLIENTS connections
+const roomFull = (docName) => {
+    const ydoc = docs.get(docName)
+    return MAX_ROOM_CLIENTS > 0 && ydoc !== undefined && ydoc.conns.size >= MAX_ROOM_CLIENTS
+}
+
+const roomPath = /^\/([^/]+)$/
+const reloadPath = /^\/rooms\/([^/]+)\/reload$/
+const blockPath = /^\/rooms\/([^/]+)\/blocks\/([^/]+)$/
+
+// Report whether an open room contains an element with the given block ID (the
+// backend checks comment anchors against live content). Rooms still loading
+// their snapshot are reported as not open, so the backend uses that snapshot.
+const roomBlock = (docName, blockId) => {
+    const ydoc = docs.get(docName)
+    if (!ydoc || !loadedDocs.has(ydoc)) {
+        return { open: false, exists: false }
+    }
+    const walker = ydoc.getXmlFragment('default').createTreeWalker((node) => node instanceof Y.XmlElement)
+    for (const node of walker) {
+        if (node.getAttribute('id') === blockId) {
+            return { open: true, exists: true }
+        }
+    }
+    return { open: true, exists: false }
+}
+
+// Time of the last message received in each room (or of its first connection)
+const lastActivity = new WeakMap()
+
+// Load overview of this instance: connected clients in total and per room, and
+// how long the least recently active room has been idle
+const roomStats = () => {
+    const now = Date.now()
+    const rooms = []
+    let totalClients = 0
+    let oldestIdleMs = 0
+    for (const [docId, ydoc] of docs) {
+        const active = lastActivity.get(ydoc) || now
+        totalClients += ydoc.conns.size
+        oldestIdleMs = Math.max(oldestIdleMs, now - active)
+        rooms.push({ docId, clientCount: ydoc.conns.size, lastActivity: new Date(active).toISOString() })
+    }
+    return { roomCount: rooms.length, totalClients, oldestIdleMs, rooms }
+}
+
+// Internal endpoints require the secret shared with the backend
+const authorizedInternal = (request) =>
+    COLLAB_SECRET !== '' && request.headers['x-collab-secret'] === COLLAB_SECRET
+
+// Disconnect every client from an open room. Once the last connection closes
+// y-websocket persists and destroys the room as usual. Returns false if the
+// room is not open on this instance.
+const evictRoom = (docName) => {
+    const ydoc = docs.get(docName)
+    if (!ydoc) {
+        return false
+    }
+    for (const conn of ydoc.conns.keys()) {
+        conn.close(4410, 'Document is in the trash')
+    }
+    console.log(`Evicted room ${docName}`)
+    return true
+}
+
+// Notification sockets by user ID. Clients open /notifications?token=<access
+// token>; the token is checked with the backend before the socket is registered.
+const notificationSockets = new Map()
+
+const registerNotificationSocket = async (conn, token) => {
+    let userId
+    try {
+        const response = await fetch(`${API_URL}/api/auth/validate`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token }),
+        })
+        const data = await response.json()
+        if (!response.ok || !data.valid || data.claims.typ !== 'access') {
+            conn.close(4401, 'Invalid token')
+            return
+        }
+        userId = data.claims.sub
+    } catch (error) {
+        console.error('Failed to validate notification token:', error.message)
+        conn.close(1011, 'Token validation failed')
+        return
+    }
+
+    if (!notificationSockets.has(userId)) {
+        notificationSockets.set(userId, new Set())
+    }
+    notificationSockets.get(userId).add(conn)
+    conn.on('close', () => {
+        const sockets = notificationSockets.get(userId)
+        sockets.delete(conn)
+        if (sockets.size === 0) {
+            notificationSockets.delete(userId)
+        }
+    })
+}
+
+// Forward a message from user:<id>:notifications to that user's sockets as-is
+const relayNotification = (message, channel) => {
+    const userId = channel.split(':')[1]
+    for (const conn of notificationSockets.get(userId) || []) {
+        if (conn.readyState === WebSocket.OPEN) {
+            conn.send(message)
+        }
+    }
+}
+
+// Every instance listens on control:rooms:* so a room is handled wherever it is
+// open (the backend publishes to control:rooms:evict when a document is
+// trashed and to control:rooms:reload after a version restore), and on
+// user:*:notifications to relay notifications
+const subscribeRedisChannels = async () => {
+    const subscriber = createClient({ url: REDIS_URL })
+    subscriber.on('error', (error) => console.error('Redis subscriber error:', error.message))
+    await subscriber.connect()
+    await subscriber.pSubscribe('user:*:notifications', relayNotification)
+    await subscriber.pSubscribe('control:rooms:*', (message, channel) => {
+        try {
+            const { room } = JSON.parse(message)
+            if (channel === 'control:rooms:evict' && room) {
+                evictRoom(room)
+            } else if (channel === 'control:rooms:reload' && room) {
+                reloadRoom(room).catch((error) => {
+                    console.error(`Error reloading room ${room}:`, error.message)
+                })
+            }
+        } catch (error) {
+            console.error(`Invalid room control message on ${channel}:`, error.message)
+        }
+    })
+    console.log('Subscribed to room control and notification channels')
+}
+
+if (REDIS_URL) {
+    subscribeRedisChannels().catch((error) => {
+        console.error('Failed to subscribe to Redis channels:', error.message)
+    })
+}
+
+// Security headers for HTTP responses, configured like the backend: an env
+// variable set to "off" disables its header, CSP is only sent when configured.
+// WebSocket upgrades never reach the request handler, so they are not affected.
+const headerSetting = (key, def) => {
+    const value = (process.env[key] ?? def).trim()
+    return value.toLowerCase() === 'off' ? '' : value
+}
+const securityHeaders = Object.entries({
+    'X-Content-Type-Options': headerSetting('SECURITY_CONTENT_TYPE_OPTIONS', 'nosniff'),
+    'X-Frame-Options': headerSetting('SECURITY_FRAME_OPTIONS', 'DENY'),
+    'Referrer-Policy': headerSetting('SECURITY_REFERRER_POLICY', 'strict-origin-when-cross-origin'),
+    'Content-Security-Policy': headerSetting('SECURITY_CSP', ''),
+}).filter(([, value]) => value !== '')
+const securityHeadersSkip = new Set(
+    headerSetting('SECURITY_HEADERS_SKIP_PATHS', '/health').split(',').map((p) => p.trim()).filter(Boolean)
+)
+
 // Create HTTP server
 const server = http.createServer((request, response) => {
+    if (!securityHeadersSkip.has(new URL(request.url, 'http://localhost').pathname)) {
+        for (const [name, value] of securityHeaders) {
+            response.setHeader(name, value)
+        }
+    }
+
     if (request.url === '/health') {
         response.writeHead(200, { 'Content-Type': 'application/json' })
         response.end(JSON.stringify({ status: 'ok' }))
         return
     }
 
+    if (request.method === 'GET' && request.url === '/stats') {
+        if (!authorizedInternal(request)) {
+            response.writeHead(403, { 'Content-Type': 'application/json' })
+            response.end(JSON.stringify({ error: 'Forbidden' }))
+            return
+        }
+        response.writeHead(200, { 'Content-Type': 'application/json' })
+        response.end(JSON.stringify(roomStats()))
+        return
+    }
+
+    const block = request.method === 'GET' && request.url.match(blockPath)
+    if (block) {
+        if (!authorizedInternal(request)) {
+            response.writeHead(403, { 'Content-Type': 'application/json' })
+            response.end(JSON.stringify({ error: 'Forbidden' }))
+            return
+        }
+        let result
+        try {
+            result = roomBlock(decodeURIComponent(block[1]), decodeURIComponent(block[2]))
+        } catch (error) {
+            response.writeHead(400, { 'Content-Type': 'application/json' })
+            response.end(JSON.stringify({ error: error.message }))
+            return
+        }
+        response.writeHead(200, { 'Content-Type': 'application/json' })
+        response.end(JSON.stringify(result))
+        return
+    }
+
+    const reload = request.method === 'POST' && request.url.match(reloadPath)
+    if (reload) {
+        if (!authorizedInternal(request)) {
+            response.writeHead(403, { 'Content-Type': 'application/json' })
+            response.end(JSON.stringify({ error: 'Forbidden' }))
+            return
+        }
+        reloadRoom(decodeURIComponent(reload[1]))
+            .then((reloaded) => {
+                response.writeHead(200, { 'Content-Type': 'application/json' })
+                response.end(JSON.stringify({ reloaded }))
+            })
+            .catch((error) => {
+                console.error(`Error reloading room ${reload[1]}:`, error.message)
+                response.writeHead(502, { 'Content-Type': 'application/json' })
+                response.end(JSON.stringify({ error: error.message }))
+            })
+        return
+    }
+
+    // Room paths only accept WebSocket connections. A plain GET (e.g. a browser
+    // opening the URL) gets 426 with what the endpoint is instead of a bare page.
+    const pathname = new URL(request.url, 'http://localhost').pathname
+    const room = request.method === 'GET' && pathname !== '/notifications' && pathname.match(roomPath)
+    if (room) {
+        // The room name is the raw path segment, as for WebSocket connections
+        const docId = room[1]
+        documentStatus(docId)
+            .then((status) => ({ exists: status === 200, trashed: status === 410 }))
+            .catch((error) => {
+                console.error(`Failed to check document ${docId}:`, error.message)
+                return { exists: null, trashed: null }
+            })
+            .then((state) => {
+                response.writeHead(426, { 'Content-Type': 'application/json', Upgrade: 'websocket' })
+                response.end(JSON.stringify({ error: 'WebSocket connection required', docId, ...state }))
+            })
+        return
+    }
+
     response.writeHead(200, { 'Content-Type': 'text/plain' })
     response.end('y-websocket server')
 })
 
-// Create WebSocket server
-const wss = new WebSocket.Server({ server })
+// Create WebSocket server. Upgrades are handled below so room connections can
+// be checked before y-websocket sets up the room.
+const wss = new WebSocket.Server({ noServer: true })
+
+server.on('upgrade', async (request, socket, head) => {
+    const url = new URL(request.url, `http://${request.headers.host}`)
+    const trashed = url.pathname !== '/notifications' && (await isTrashed(url.pathname.slice(1)))
+    wss.handleUpgrade(request, socket, head, (conn) => {
+        if (trashed) {
+            console.log(`Rejecting connection to trashed document: ${url.pathname.slice(1)}`)
+            conn.close(4410, 'Document is in the trash')
+            return
+        }
+        if (url.pathname !== '/notifications' && roomFull(url.pathname.slice(1))) {
+            console.log(`Rejecting connection to full room: ${url.pathname.slice(1)}`)
+            conn.close(1013, `Room is full (${MAX_ROOM_CLIENTS} clients)`)
+            return
+        }
+        wss.emit('connection', conn, request)
+    })
+})
 
 wss.on('connection', (conn, req) => {
     // Extract room name from URL path
     // y-websocket client sends path as /<roomName>
     const url = new URL(req.url, `http://${req.headers.host}`)
+    if (url.pathname === '/notifications') {
+        registerNotificationSocket(conn, url.searchParams.get('token') || '')
+        return
+    }
     let roomName = url.pathname.slice(1) // Remove leading /
 
     // Get user info from query params (optional)
@@ -115,6 +441,10 @@ wss.on('connection', (conn, req) => {
         docName: roomName,
         gc: true, // Enable garbage collection
     })
+
+    const ydoc = docs.get(roomName)
+    lastActivity.set(ydoc, Date.now())
+    conn.on('message', () => lastActivity.set(ydoc, Date.now()))
 })
 
 // Start server
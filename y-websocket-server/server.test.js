@@ -0,0 +1,161 @@
+// Integration tests: each test starts server.js against a fake backend
+const { test } = require('node:test')
+const assert = require('node:assert')
+const http = require('http')
+const net = require('net')
+const path = require('path')
+const { spawn } = require('child_process')
+const WebSocket = require('ws')
+
+const docId = '22222222-2222-2222-2222-222222222222'
+
+const freePort = () =>
+    new Promise((resolve, reject) => {
+        const srv = net.createServer()
+        srv.on('error', reject)
+        srv.listen(0, '127.0.0.1', () => {
+            const { port } = srv.address()
+            srv.close(() => resolve(port))
+        })
+    })
+
+// Fake backend: HEAD /api/yjs/:docId answers status(docId); there are no snapshots
+const startBackend = async (t, status = () => 200) => {
+    const backend = http.createServer((request, response) => {
+        const id = request.url.split('/')[3]
+        response.writeHead(request.method === 'HEAD' ? status(id) : 404)
+        response.end()
+    })
+    await new Promise((resolve) => backend.listen(0, '127.0.0.1', resolve))
+    t.after(() => backend.close())
+    return `http://127.0.0.1:${backend.address().port}`
+}
+
+// Start server.js with the given environment and wait until it listens
+const startServer = async (t, env) => {
+    const port = await freePort()
+    const child = spawn(process.execPath, [path.join(__dirname, 'server.js')], {
+        env: { ...process.env, REDIS_URL: '', ...env, PORT: String(port) },
+        stdio: ['ignore', 'pipe', 'inherit'],
+    })
+    t.after(() => child.kill())
+    await new Promise((resolve, reject) => {
+        child.stdout.on('data', (data) => {
+            if (data.toString().includes('running on port')) {
+                resolve()
+            }
+        })
+        child.on('exit', (code) => reject(new Error(`server exited with ${code}`)))
+    })
+    return `127.0.0.1:${port}`
+}
+
+// Open a WebSocket and resolve with the close code if it is closed within a
+// short delay, or with the open socket otherwise
+const connect = (t, url) =>
+    new Promise((resolve, reject) => {
+        const ws = new WebSocket(url)
+        t.after(() => ws.terminate())
+        let timer
+        ws.on('open', () => {
+            timer = setTimeout(() => resolve({ ws }), 300)
+        })
+        ws.on('close', (code, reason) => {
+            clearTimeout(timer)
+            resolve({ code, reason: reason.toString() })
+        })
+        ws.on('error', reject)
+    })
+
+test('MAX_ROOM_CLIENTS refuses the next client', async (t) => {
+    const host = await startServer(t, { API_URL: await startBackend(t), MAX_ROOM_CLIENTS: '2' })
+
+    for (let i = 0; i < 2; i++) {
+        const { ws, code } = await connect(t, `ws://${host}/${docId}`)
+        assert.ok(ws, `client ${i + 1} was closed with ${code}`)
+    }
+    const refused = await connect(t, `ws://${host}/${docId}`)
+    assert.strictEqual(refused.code, 1013)
+    assert.match(refused.reason, /Room is full/)
+
+    // Other rooms are counted separately
+    const other = await connect(t, `ws://${host}/33333333-3333-3333-3333-333333333333`)
+    assert.ok(other.ws, `client in another room was closed with ${other.code}`)
+})
+
+test('rooms are not limited without MAX_ROOM_CLIENTS', async (t) => {
+    const host = await startServer(t, { API_URL: await startBackend(t) })
+
+    for (let i = 0; i < 3; i++) {
+        const { ws, code } = await connect(t, `ws://${host}/${docId}`)
+        assert.ok(ws, `client ${i + 1} was closed with ${code}`)
+    }
+})
+
+test('plain GET on a room path returns 426 with the document state', async (t) => {
+    const trashedId = '44444444-4444-4444-4444-444444444444'
+    const statuses = { [docId]: 200, [trashedId]: 410 }
+    const host = await startServer(t, { API_URL: await startBackend(t, (id) => statuses[id] || 404) })
+
+    const cases = [
+        { id: docId, exists: true, trashed: false },
+        { id: trashedId, exists: false, trashed: true },
+        { id: 'missing', exists: false, trashed: false },
+    ]
+    for (const c of cases) {
+        const response = await fetch(`http://${host}/${c.id}`)
+        assert.strictEqual(response.status, 426)
+        assert.strictEqual(response.headers.get('upgrade'), 'websocket')
+        assert.deepStrictEqual(await response.json(), {
+            error: 'WebSocket connection required',
+            docId: c.id,
+            exists: c.exists,
+            trashed: c.trashed,
+        })
+    }
+
+    const health = await fetch(`http://${host}/health`)
+    assert.strictEqual(health.status, 200)
+})
+
+test('HTTP responses carry security headers except on skipped paths', async (t) => {
+    const host = await startServer(t, {
+        API_URL: await startBackend(t),
+        SECURITY_FRAME_OPTIONS: 'SAMEORIGIN',
+        SECURITY_CSP: "default-src 'none'",
+    })
+
+    const room = await fetch(`http://${host}/${docId}`)
+    assert.strictEqual(room.headers.get('x-content-type-options'), 'nosniff')
+    assert.strictEqual(room.headers.get('x-frame-options'), 'SAMEORIGIN')
+    assert.strictEqual(room.headers.get('referrer-policy'), 'strict-origin-when-cross-origin')
+    assert.strictEqual(room.headers.get('content-security-policy'), "default-src 'none'")
+
+    const health = await fetch(`http://${host}/health`)
+    for (const name of ['x-content-type-options', 'x-frame-options', 'referrer-policy', 'content-security-policy']) {
+        assert.strictEqual(health.headers.get(name), null, `/health sent ${name}`)
+    }
+})
+
+test('GET /stats reports clients per room to internal callers', async (t) => {
+    const otherId = '33333333-3333-3333-3333-333333333333'
+    const host = await startServer(t, { API_URL: await startBackend(t), COLLAB_SECRET: 'test-secret' })
+    for (const id of [docId, docId, otherId]) {
+        const { ws, code } = await connect(t, `ws://${host}/${id}`)
+        assert.ok(ws, `client in ${id} was closed with ${code}`)
+    }
+
+    assert.strictEqual((await fetch(`http://${host}/stats`)).status, 403)
+
+    const response = await fetch(`http://${host}/stats`, { headers: { 'x-collab-secret': 'test-secret' } })
+    assert.strictEqual(response.status, 200)
+    const stats = await response.json()
+    assert.strictEqual(stats.roomCount, 2)
+    assert.strictEqual(stats.totalClients, 3)
+    assert.ok(stats.oldestIdleMs >= 0)
+    const counts = Object.fromEntries(stats.rooms.map((room) => [room.docId, room.clientCount]))
+    assert.deepStrictEqual(counts, { [docId]: 2, [otherId]: 1 })
+    for (const room of stats.rooms) {
+        assert.ok(Date.now() - Date.parse(room.lastActivity) < 60000, `lastActivity ${room.lastActivity}`)
+    }
+})